@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/migrate"
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+type command func(ctx context.Context, args []string) error
+
+// commands are run instead of the server when their name is the first argument.
+func commands() map[string]command {
+	return map[string]command{
+		"migrate":           runMigrate,
+		"replicate":         runReplicate,
+		"reencrypt":         runReencrypt,
+		"gc":                runGC,
+		"migrate-blobs":     runMigrateBlobs,
+		"recalculate-usage": runRecalculateUsage,
+		"verify-lock":       runVerifyLock,
+	}
+}
+
+type migrateFlags struct {
+	from, to string
+	opts     migrate.Options
+}
+
+func parseMigrateFlags(fs *flag.FlagSet, args []string) (*migrateFlags, error) {
+	f := &migrateFlags{}
+	fs.StringVar(&f.from, "from", "", "Path to the config file of the source storage")
+	fs.StringVar(&f.to, "to", "", "Path to the config file of the destination storage")
+	fs.IntVar(&f.opts.Concurrency, "concurrency", 8, "Number of files copied concurrently")
+	fs.BoolVar(&f.opts.DryRun, "dry-run", false, "Only report which files would be copied")
+	fs.BoolVar(&f.opts.VerifyExisting, "verify-existing", false, "Compare checksums of files that already exist in the destination")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if f.from == "" || f.to == "" {
+		return nil, errors.New("both --from and --to are required")
+	}
+	return f, nil
+}
+
+func openStorages(ctx context.Context, f *migrateFlags) (storage.Storage, storage.Storage, error) {
+	srcCfg, err := config.LoadStorageConfig(f.from)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load source config")
+	}
+	dstCfg, err := config.LoadStorageConfig(f.to)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load destination config")
+	}
+
+	src, err := storage.New(ctx, srcCfg)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to initialize source storage")
+	}
+	dst, err := storage.New(ctx, dstCfg)
+	if err != nil {
+		_ = src.Close()
+		return nil, nil, errors.Wrap(err, "failed to initialize destination storage")
+	}
+	return src, dst, nil
+}
+
+func runMigrate(ctx context.Context, args []string) error {
+	f, err := parseMigrateFlags(flag.NewFlagSet("migrate", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	src, dst, err := openStorages(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	report, err := migrate.Run(ctx, src, dst, f.opts)
+	if report != nil {
+		printReport(report, f.opts.DryRun)
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Failed) > 0 {
+		return errors.Errorf("failed to copy %d files", len(report.Failed))
+	}
+	return nil
+}
+
+func runReplicate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replicate", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Minute, "Time between replication runs")
+	f, err := parseMigrateFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	src, dst, err := openStorages(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info().Dur("interval", *interval).Msg("Starting replication")
+	return migrate.Replicate(ctx, src, dst, f.opts, *interval)
+}
+
+func runReencrypt(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	configFilePath := fs.String("config", "", "Path to config file")
+	dryRun := fs.Bool("dry-run", false, "Only report which files are not encrypted with the primary key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	strg, err := openStorage(ctx, *configFilePath)
+	if err != nil {
+		return err
+	}
+	defer strg.Close()
+
+	encrypted, ok := strg.(*storage.EncryptedStorage)
+	if !ok {
+		return errors.New("storage.encryption.enabled is not set")
+	}
+
+	packages, err := encrypted.ListPackages(ctx)
+	if err != nil {
+		return err
+	}
+
+	rewritten, failed := 0, 0
+	for _, pkg := range packages {
+		files, err := encrypted.ListPackageFiles(ctx, pkg)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			filePath := path.Join(pkg, f)
+
+			if *dryRun {
+				keyID, err := encrypted.KeyID(ctx, filePath)
+				switch {
+				case errors.Is(err, storage.ErrNotEncrypted):
+					fmt.Fprintf(os.Stdout, "would encrypt\t%s\n", filePath)
+					rewritten++
+				case err != nil:
+					fmt.Fprintf(os.Stdout, "failed\t%s\t%v\n", filePath, err)
+					failed++
+				case keyID != encrypted.Keyring().Primary():
+					fmt.Fprintf(os.Stdout, "would reencrypt\t%s\t%s\n", filePath, keyID)
+					rewritten++
+				}
+				continue
+			}
+
+			changed, err := encrypted.Reencrypt(ctx, filePath)
+			switch {
+			case err != nil:
+				fmt.Fprintf(os.Stdout, "failed\t%s\t%v\n", filePath, err)
+				failed++
+			case changed:
+				fmt.Fprintf(os.Stdout, "reencrypted\t%s\n", filePath)
+				rewritten++
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "rewritten: %d, failed: %d\n", rewritten, failed)
+	if failed > 0 {
+		return errors.Errorf("failed to reencrypt %d files", failed)
+	}
+	return nil
+}
+
+func runGC(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	configFilePath := fs.String("config", "", "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	blobs, closeStorage, err := openContentAddressedStorage(ctx, *configFilePath)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	report, err := blobs.GC(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sum := range report.Deleted {
+		fmt.Fprintf(os.Stdout, "deleted\t%s\n", sum)
+	}
+	for _, p := range report.Dangling {
+		fmt.Fprintf(os.Stdout, "dangling\t%s\n", p)
+	}
+	fmt.Fprintf(os.Stdout, "blobs: %d, deleted: %d, freed bytes: %d\n", report.Blobs, len(report.Deleted), report.FreedBytes)
+	return nil
+}
+
+func runMigrateBlobs(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate-blobs", flag.ExitOnError)
+	configFilePath := fs.String("config", "", "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	blobs, closeStorage, err := openContentAddressedStorage(ctx, *configFilePath)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	converted, err := blobs.MigrateLayout(ctx)
+	for _, p := range converted {
+		fmt.Fprintf(os.Stdout, "converted\t%s\n", p)
+	}
+	fmt.Fprintf(os.Stdout, "converted: %d\n", len(converted))
+	return err
+}
+
+func runRecalculateUsage(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("recalculate-usage", flag.ExitOnError)
+	configFilePath := fs.String("config", "", "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	strg, err := openStorage(ctx, *configFilePath)
+	if err != nil {
+		return err
+	}
+	defer strg.Close()
+
+	usage, err := packageindex.NewIndex(strg).RecalculateUsage(ctx)
+	if err != nil {
+		return err
+	}
+	printUsage("project", usage.Projects)
+	printUsage("user", usage.Users)
+	return nil
+}
+
+func printUsage(kind string, entries map[string]*packageindex.UsageEntry) {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stdout, "%s\t%s\t%d bytes\t%d files\n", kind, name, entries[name].Bytes, entries[name].Files)
+	}
+}
+
+func runVerifyLock(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify-lock", flag.ExitOnError)
+	configFilePath := fs.String("config", "", "Path to config file")
+	indexURL := fs.String("index-url", "", "URL the index is served at, e.g. https://pypi.example.com. If empty, only the paths of file URLs are checked")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: verify-lock --config=config.yaml [--index-url=URL] pylock.toml")
+	}
+
+	lock, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	strg, err := openStorage(ctx, *configFilePath)
+	if err != nil {
+		return err
+	}
+	defer strg.Close()
+
+	report, err := packageindex.NewIndex(strg).VerifyLock(ctx, lock, *indexURL)
+	if err != nil {
+		return err
+	}
+	printLockIssues("missing", report.Missing)
+	printLockIssues("yanked", report.Yanked)
+	printLockIssues("hash-mismatch", report.HashMismatches)
+	printLockIssues("overridden", report.Overridden)
+	printLockIssues("unchecked", report.Unchecked)
+	fmt.Fprintf(os.Stdout, "checked %d files of %d packages\n", report.Files, report.Packages)
+	if !report.OK() {
+		return errors.New("lock file doesn't match the index")
+	}
+	return nil
+}
+
+func printLockIssues(kind string, issues []*packageindex.LockIssue) {
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stdout, "%s\t%s\t%s\t%s\t%s\n", kind, issue.Package, issue.Version, issue.FileName, issue.Detail)
+	}
+}
+
+func openStorage(ctx context.Context, configFilePath string) (storage.Storage, error) {
+	cfg, err := config.LoadStorageConfig(configFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load config")
+	}
+	strg, err := storage.New(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize storage")
+	}
+	return strg, nil
+}
+
+func openContentAddressedStorage(ctx context.Context, configFilePath string) (*storage.ContentAddressedStorage, func(), error) {
+	strg, err := openStorage(ctx, configFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blobs, ok := strg.(*storage.ContentAddressedStorage)
+	if !ok {
+		_ = strg.Close()
+		return nil, nil, errors.New("storage.content_addressed.enabled is not set")
+	}
+	return blobs, func() { _ = strg.Close() }, nil
+}
+
+func printReport(report *migrate.Report, dryRun bool) {
+	verb := "copied"
+	if dryRun {
+		verb = "would copy"
+	}
+	for _, p := range report.Copied {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", verb, p)
+	}
+
+	failed := make([]string, 0, len(report.Failed))
+	for p := range report.Failed {
+		failed = append(failed, p)
+	}
+	sort.Strings(failed)
+	for _, p := range failed {
+		fmt.Fprintf(os.Stdout, "failed\t%s\t%v\n", p, report.Failed[p])
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", report)
+}
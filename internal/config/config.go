@@ -17,6 +17,9 @@ type ServerConfig struct {
 
 type LocalConfig struct {
 	Path string `mapstructure:"path"`
+	// Layout is either "nested" (<project>/<file>), "flat" (all files in one directory, like pypiserver)
+	// or "mixed" (reads both, writes nested).
+	Layout string `mapstructure:"layout"`
 }
 
 type S3Config struct {
@@ -29,19 +32,213 @@ type S3Config struct {
 	SecretKey    string `mapstructure:"secret_key"`
 }
 
+type GCSConfig struct {
+	Bucket          string `mapstructure:"bucket"`
+	Prefix          string `mapstructure:"prefix"`
+	Endpoint        string `mapstructure:"endpoint"`
+	CredentialsFile string `mapstructure:"credentials_file"`
+	// WithoutAuthentication disables authentication, which is only useful with emulators.
+	WithoutAuthentication bool `mapstructure:"without_authentication"`
+}
+
+type AzureConfig struct {
+	// ServiceURL defaults to https://<account_name>.blob.core.windows.net/.
+	ServiceURL  string `mapstructure:"service_url"`
+	AccountName string `mapstructure:"account_name"`
+	Container   string `mapstructure:"container"`
+	Prefix      string `mapstructure:"prefix"`
+
+	// Credentials are picked in this order: SAS token, shared key, then managed identity.
+	AccountKey              string `mapstructure:"account_key"`
+	SASToken                string `mapstructure:"sas_token"`
+	ManagedIdentityClientID string `mapstructure:"managed_identity_client_id"`
+
+	UploadBlockSize   int64 `mapstructure:"upload_block_size"`
+	UploadConcurrency int   `mapstructure:"upload_concurrency"`
+}
+
+// CacheConfig configures a local disk cache in front of any storage backend.
+type CacheConfig struct {
+	Enabled           bool   `mapstructure:"enabled"`
+	Path              string `mapstructure:"path"`
+	MaxSizeBytes      int64  `mapstructure:"max_size_bytes"`
+	ListingTTLSeconds int    `mapstructure:"listing_ttl_seconds"`
+}
+
+// MirrorConfig configures a storage that writes to every backend and reads from the first healthy one.
+type MirrorConfig struct {
+	// Backends are tried in order when reading. Repairs are tracked by position,
+	// so backends should not be reordered while the journal has pending entries.
+	Backends []StorageConfig `mapstructure:"backends"`
+	// JournalPath is the file that records writes and deletes that failed on some backends.
+	JournalPath              string `mapstructure:"journal_path"`
+	ReconcileIntervalSeconds int    `mapstructure:"reconcile_interval_seconds"`
+	// UnhealthyCooldownSeconds is how long a failing backend is tried last.
+	UnhealthyCooldownSeconds int `mapstructure:"unhealthy_cooldown_seconds"`
+}
+
+// EncryptionConfig configures encryption of file contents before they are stored in the backend.
+type EncryptionConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	KeyringPath string `mapstructure:"keyring_path"`
+	// AllowUnencryptedReads serves files that were stored before encryption was enabled.
+	AllowUnencryptedReads bool `mapstructure:"allow_unencrypted_reads"`
+}
+
+// ContentAddressedConfig configures storing file contents once per sha256, with references at the file paths.
+type ContentAddressedConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// GCIntervalSeconds is how often unreferenced blobs are collected. Zero disables collection.
+	GCIntervalSeconds int `mapstructure:"gc_interval_seconds"`
+	// GCGracePeriodSeconds is how long a blob must be unreferenced before it is deleted.
+	GCGracePeriodSeconds int `mapstructure:"gc_grace_period_seconds"`
+}
+
+// ResilienceConfig configures per-operation timeouts, retries and a circuit breaker around the storage backend.
+type ResilienceConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TimeoutSeconds bounds listings, deletes and opening files for reading. Zero disables it.
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+	// WriteTimeoutSeconds bounds writes, including streaming the content. Zero disables it.
+	WriteTimeoutSeconds int `mapstructure:"write_timeout_seconds"`
+	// MaxRetries is how many times a failed operation is retried. Writes are only retried
+	// when their content can be rewound.
+	MaxRetries                 int `mapstructure:"max_retries"`
+	InitialBackoffMilliseconds int `mapstructure:"initial_backoff_milliseconds"`
+	MaxBackoffMilliseconds     int `mapstructure:"max_backoff_milliseconds"`
+	// BreakerThreshold is the number of consecutive failures that open the circuit. Zero disables it.
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+	// BreakerCooldownSeconds is how long the circuit stays open before an operation is let through again.
+	BreakerCooldownSeconds int `mapstructure:"breaker_cooldown_seconds"`
+}
+
+// FaultConfig configures the faults injected into one kind of storage operation.
+type FaultConfig struct {
+	LatencyMilliseconds int `mapstructure:"latency_milliseconds"`
+	// ErrorRate is the chance, from 0 to 1, that the operation fails without reaching the backend.
+	ErrorRate float64 `mapstructure:"error_rate"`
+	// TruncateRate is the chance that a read ends early with an error. Only used for reads.
+	TruncateRate float64 `mapstructure:"truncate_rate"`
+	// PartialWriteRate is the chance that only part of the content is stored before the write fails.
+	// Only used for writes.
+	PartialWriteRate float64 `mapstructure:"partial_write_rate"`
+}
+
+// FaultyConfig configures a storage that injects faults into another backend, for resilience testing.
+type FaultyConfig struct {
+	Backend *StorageConfig `mapstructure:"backend"`
+	// Seed makes the injected faults repeatable for the same sequence of operations.
+	Seed uint64 `mapstructure:"seed"`
+
+	ListPackages     FaultConfig `mapstructure:"list_packages"`
+	ListPackageFiles FaultConfig `mapstructure:"list_package_files"`
+	ReadFile         FaultConfig `mapstructure:"read_file"`
+	WriteFile        FaultConfig `mapstructure:"write_file"`
+	DeleteFile       FaultConfig `mapstructure:"delete_file"`
+}
+
 type StorageConfig struct {
 	Kind string `mapstructure:"kind"`
 
-	Local LocalConfig `mapstructure:"local"`
-	S3    S3Config    `mapstructure:"s3"`
+	Local  LocalConfig  `mapstructure:"local"`
+	S3     S3Config     `mapstructure:"s3"`
+	GCS    GCSConfig    `mapstructure:"gcs"`
+	Azure  AzureConfig  `mapstructure:"azure"`
+	Mirror MirrorConfig `mapstructure:"mirror"`
+	Faulty FaultyConfig `mapstructure:"faulty"`
+
+	Resilience       ResilienceConfig       `mapstructure:"resilience"`
+	Cache            CacheConfig            `mapstructure:"cache"`
+	Encryption       EncryptionConfig       `mapstructure:"encryption"`
+	ContentAddressed ContentAddressedConfig `mapstructure:"content_addressed"`
+}
+
+// IngestConfig configures registering files that were written to the storage without being uploaded.
+type IngestConfig struct {
+	OnStartup bool `mapstructure:"on_startup"`
+	// IntervalSeconds is how often the whole storage is scanned. Zero disables scanning.
+	IntervalSeconds int `mapstructure:"interval_seconds"`
+	// Watch registers new files as soon as they are written, if the storage supports it.
+	Watch bool `mapstructure:"watch"`
+	// WatchDebounceMilliseconds is how long a package must be left unchanged before its files are registered.
+	WatchDebounceMilliseconds int `mapstructure:"watch_debounce_milliseconds"`
+	// Owner is recorded as the uploader of registered files.
+	Owner string `mapstructure:"owner"`
+}
+
+// QuotaLimit bounds the storage used by a project or an uploader. Zero means unlimited.
+type QuotaLimit struct {
+	MaxBytes int64 `mapstructure:"max_bytes"`
+	MaxFiles int   `mapstructure:"max_files"`
+}
+
+// QuotaConfig configures the limits enforced on uploads.
+type QuotaConfig struct {
+	DefaultProject QuotaLimit `mapstructure:"default_project"`
+	DefaultUser    QuotaLimit `mapstructure:"default_user"`
+	// Projects overrides the default for normalized project names.
+	Projects map[string]QuotaLimit `mapstructure:"projects"`
+	// Users overrides the default for uploaders. Names are matched case-insensitively.
+	Users map[string]QuotaLimit `mapstructure:"users"`
+}
+
+// RetentionRule configures which dev, pre- and post-releases of a project are deleted. Zero keeps them.
+type RetentionRule struct {
+	// KeepDevReleases is how many of the newest dev releases are kept.
+	KeepDevReleases int `mapstructure:"keep_dev_releases"`
+	// PreReleaseMaxAgeDays is how long pre-releases are kept once a newer final release exists.
+	PreReleaseMaxAgeDays int `mapstructure:"pre_release_max_age_days"`
+	// KeepPostReleases is how many of the newest post-releases of each release are kept.
+	KeepPostReleases int `mapstructure:"keep_post_releases"`
+}
+
+// RetentionConfig configures deleting old files of projects.
+type RetentionConfig struct {
+	// IntervalSeconds is how often the rules are applied. Zero disables applying them in the background.
+	IntervalSeconds int           `mapstructure:"interval_seconds"`
+	Default         RetentionRule `mapstructure:"default"`
+	// Projects overrides the default for normalized project names.
+	Projects map[string]RetentionRule `mapstructure:"projects"`
+}
+
+// UploadPolicyRule configures which uploads are accepted. The zero value accepts every upload.
+type UploadPolicyRule struct {
+	// ForbiddenPlatforms are platform tags that wheels may not be built for. Patterns such as linux_* are
+	// matched with path.Match.
+	ForbiddenPlatforms []string `mapstructure:"forbidden_platforms"`
+	// AllowedPlatforms, if set, are the only platform tags that wheels may be built for, besides pure
+	// Python wheels. Patterns are matched like ForbiddenPlatforms.
+	AllowedPlatforms []string `mapstructure:"allowed_platforms"`
+	// ForbidLocalVersions rejects PEP 440 local versions, such as 1.0+local.
+	ForbidLocalVersions bool `mapstructure:"forbid_local_versions"`
+	// RequireRequiresPython rejects uploads without Requires-Python metadata.
+	RequireRequiresPython bool `mapstructure:"require_requires_python"`
+	// RequireSdist rejects wheels of versions whose sdist hasn't been uploaded yet. twine uploads wheels
+	// first, so the sdist has to be uploaded with a command of its own.
+	RequireSdist bool `mapstructure:"require_sdist"`
+	// ForbidPreReleases rejects pre- and dev releases.
+	ForbidPreReleases bool `mapstructure:"forbid_pre_releases"`
+}
+
+// UploadPolicyConfig configures the rules uploads must follow.
+type UploadPolicyConfig struct {
+	Default UploadPolicyRule `mapstructure:"default"`
+	// Projects overrides the default for normalized project names.
+	Projects map[string]UploadPolicyRule `mapstructure:"projects"`
 }
 
 type Config struct {
-	Server  ServerConfig  `mapstructure:"server"`
-	Storage StorageConfig `mapstructure:"storage"`
+	Server       ServerConfig       `mapstructure:"server"`
+	Storage      StorageConfig      `mapstructure:"storage"`
+	Ingest       IngestConfig       `mapstructure:"ingest"`
+	Quotas       QuotaConfig        `mapstructure:"quotas"`
+	Retention    RetentionConfig    `mapstructure:"retention"`
+	UploadPolicy UploadPolicyConfig `mapstructure:"upload_policy"`
 
 	LogLevel string `mapstructure:"log_level"`
 	HTPasswd string `mapstructure:"htpasswd"`
+	// AdminUsers may use the /admin/ endpoints.
+	AdminUsers []string `mapstructure:"admin_users"`
 }
 
 func MustInit(configFilePath *string) *Config {
@@ -51,8 +248,12 @@ func MustInit(configFilePath *string) *Config {
 	viper.SetDefault("server.read_header_timeout_seconds", 5)
 	viper.SetDefault("server.graceful_shutdown_seconds", 10)
 	viper.SetDefault("server.enable_access_logger", true)
-	viper.SetDefault("storage.kind", "local")
-	viper.SetDefault("storage.local.path", "./data")
+	setStorageDefaults(viper.GetViper())
+	viper.SetDefault("ingest.on_startup", true)
+	viper.SetDefault("ingest.interval_seconds", 3600)
+	viper.SetDefault("ingest.watch", true)
+	viper.SetDefault("ingest.watch_debounce_milliseconds", 2000)
+	viper.SetDefault("retention.interval_seconds", 86400)
 	viper.SetDefault("htpasswd", "./htpasswd")
 
 	viper.AutomaticEnv()
@@ -73,3 +274,44 @@ func MustInit(configFilePath *string) *Config {
 
 	return cfg
 }
+
+// LoadStorageConfig reads only the storage section of a config file. It is used by commands that
+// work with more than one storage at once, such as migrations.
+func LoadStorageConfig(configFilePath string) (*StorageConfig, error) {
+	v := viper.New()
+	setStorageDefaults(v)
+	v.SetConfigFile(configFilePath)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, err
+	}
+	return &cfg.Storage, nil
+}
+
+func setStorageDefaults(v *viper.Viper) {
+	v.SetDefault("storage.kind", "local")
+	v.SetDefault("storage.local.path", "./data")
+	v.SetDefault("storage.local.layout", "nested")
+	v.SetDefault("storage.azure.upload_block_size", 8*1024*1024)
+	v.SetDefault("storage.azure.upload_concurrency", 4)
+	v.SetDefault("storage.mirror.journal_path", "./mirror-journal.jsonl")
+	v.SetDefault("storage.mirror.reconcile_interval_seconds", 60)
+	v.SetDefault("storage.mirror.unhealthy_cooldown_seconds", 30)
+	v.SetDefault("storage.encryption.keyring_path", "./keyring.json")
+	v.SetDefault("storage.content_addressed.gc_interval_seconds", 3600)
+	v.SetDefault("storage.content_addressed.gc_grace_period_seconds", 3600)
+	v.SetDefault("storage.resilience.timeout_seconds", 30)
+	v.SetDefault("storage.resilience.write_timeout_seconds", 600)
+	v.SetDefault("storage.resilience.max_retries", 3)
+	v.SetDefault("storage.resilience.initial_backoff_milliseconds", 100)
+	v.SetDefault("storage.resilience.max_backoff_milliseconds", 2000)
+	v.SetDefault("storage.resilience.breaker_threshold", 5)
+	v.SetDefault("storage.resilience.breaker_cooldown_seconds", 30)
+	v.SetDefault("storage.cache.path", "./cache")
+	v.SetDefault("storage.cache.max_size_bytes", 10*1024*1024*1024)
+	v.SetDefault("storage.cache.listing_ttl_seconds", 30)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -30,9 +31,14 @@ func GetUserInfo(ctx context.Context) *AuthInfo {
 	return nil
 }
 
-func Authorizer(authFile *htpasswd.File) echo.MiddlewareFunc {
+// Authorizer requires basic authentication for every path except publicPaths.
+func Authorizer(authFile *htpasswd.File, publicPaths ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if slices.Contains(publicPaths, c.Request().URL.Path) {
+				return next(c)
+			}
+
 			authorization := c.Request().Header.Get("Authorization")
 			if authorization == "" {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"message": "No Authorization header"})
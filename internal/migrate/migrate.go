@@ -0,0 +1,223 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+type Options struct {
+	// Concurrency is the maximum number of files copied at the same time.
+	Concurrency int
+	// DryRun only reports what would be copied.
+	DryRun bool
+	// VerifyExisting compares checksums of files that already exist in the destination
+	// and copies them again if they differ. Otherwise, existing files are skipped.
+	VerifyExisting bool
+}
+
+type Report struct {
+	Copied  []string
+	Skipped []string
+	Failed  map[string]error
+	Bytes   int64
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("copied: %d, skipped: %d, failed: %d, bytes: %d", len(r.Copied), len(r.Skipped), len(r.Failed), r.Bytes)
+}
+
+// Run copies every file of every project from src to dst.
+//
+// Files that already exist in dst are skipped, so an interrupted run can simply be started again.
+// Each copied file is read back from dst and its sha256 compared with the source.
+// Failures of single files don't stop the run, they are collected in the report.
+func Run(ctx context.Context, src, dst storage.Storage, opts Options) (*Report, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	packages, err := src.ListPackages(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list packages of the source")
+	}
+
+	report := &Report{Failed: map[string]error{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.Concurrency)
+
+	for _, pkg := range packages {
+		srcFiles, err := src.ListPackageFiles(ctx, pkg)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to list files of %s in the source", pkg)
+		}
+		dstFiles, err := dst.ListPackageFiles(ctx, pkg)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to list files of %s in the destination", pkg)
+		}
+		existing := make(map[string]struct{}, len(dstFiles))
+		for _, f := range dstFiles {
+			existing[f] = struct{}{}
+		}
+
+		for _, f := range srcFiles {
+			filePath := path.Join(pkg, f)
+			_, exists := existing[f]
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return report, ctx.Err()
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				copied, n, err := syncFile(ctx, src, dst, filePath, exists, opts)
+
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err != nil:
+					log.Ctx(ctx).Error().Err(err).Str("path", filePath).Msg("Failed to copy file")
+					report.Failed[filePath] = err
+				case copied:
+					log.Ctx(ctx).Debug().Str("path", filePath).Bool("dry_run", opts.DryRun).Msg("Copied file")
+					report.Copied = append(report.Copied, filePath)
+					report.Bytes += n
+				default:
+					report.Skipped = append(report.Skipped, filePath)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	slices.Sort(report.Copied)
+	slices.Sort(report.Skipped)
+	return report, nil
+}
+
+// Replicate runs a migration every interval until the context is done, so that new uploads
+// to src are mirrored to dst.
+func Replicate(ctx context.Context, src, dst storage.Storage, opts Options, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := Run(ctx, src, dst, opts)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			log.Ctx(ctx).Error().Err(err).Msg("Replication failed")
+		case len(report.Copied) > 0 || len(report.Failed) > 0:
+			log.Ctx(ctx).Info().Stringer("report", report).Msg("Replicated files")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncFile copies a single file if needed and reports whether it was (or would be) copied.
+func syncFile(ctx context.Context, src, dst storage.Storage, filePath string, exists bool, opts Options) (bool, int64, error) {
+	if exists {
+		if !opts.VerifyExisting {
+			return false, 0, nil
+		}
+
+		srcSum, _, err := checksum(ctx, src, filePath)
+		if err != nil {
+			return false, 0, errors.Wrap(err, "failed to read source")
+		}
+		dstSum, _, err := checksum(ctx, dst, filePath)
+		if err != nil {
+			return false, 0, errors.Wrap(err, "failed to read destination")
+		}
+		if srcSum == dstSum {
+			return false, 0, nil
+		}
+		log.Ctx(ctx).Warn().Str("path", filePath).Msg("Checksum of existing file differs, copying again")
+	}
+
+	if opts.DryRun {
+		return true, 0, nil
+	}
+
+	n, err := copyFile(ctx, src, dst, filePath)
+	if err != nil {
+		return false, 0, err
+	}
+	return true, n, nil
+}
+
+func copyFile(ctx context.Context, src, dst storage.Storage, filePath string) (int64, error) {
+	rc, err := src.ReadFile(ctx, filePath)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read source")
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	counter := &countingWriter{}
+	if err := dst.WriteFile(ctx, filePath, io.TeeReader(rc, io.MultiWriter(h, counter))); err != nil {
+		return 0, errors.Wrap(err, "failed to write destination")
+	}
+	srcSum := hex.EncodeToString(h.Sum(nil))
+
+	dstSum, _, err := checksum(ctx, dst, filePath)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read back destination")
+	}
+	if srcSum != dstSum {
+		if err := dst.DeleteFile(ctx, filePath); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("path", filePath).Msg("Failed to delete corrupted copy")
+		}
+		return 0, errors.Errorf("checksum mismatch: source %s, destination %s", srcSum, dstSum)
+	}
+
+	return counter.n, nil
+}
+
+func checksum(ctx context.Context, strg storage.Storage, filePath string) (string, int64, error) {
+	rc, err := strg.ReadFile(ctx, filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
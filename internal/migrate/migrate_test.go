@@ -0,0 +1,170 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func newSource(t *testing.T) *storage.MemoryStorage {
+	t.Helper()
+
+	src := storage.NewMemoryStorage()
+	for path, content := range map[string]string{
+		"foo/foo-1.0.tar.gz":             "foo 1.0",
+		"foo/foo-1.1.tar.gz":             "foo 1.1",
+		"bar/bar-0.1-py3-none-any.whl":   "bar 0.1",
+		"baz/baz-2.0.0-py3-none-any.whl": "baz 2.0.0",
+		"baz/baz-2.0.0.tar.gz":           "baz 2.0.0 sdist",
+	} {
+		require.NoError(t, src.WriteFile(t.Context(), path, bytes.NewBufferString(content)))
+	}
+	return src
+}
+
+func readString(t *testing.T, s storage.Storage, path string) string {
+	t.Helper()
+
+	rc, err := s.ReadFile(t.Context(), path)
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestRun(t *testing.T) {
+	src := newSource(t)
+	dst := storage.NewMemoryStorage()
+
+	report, err := Run(t.Context(), src, dst, Options{Concurrency: 2})
+	require.NoError(t, err)
+	assert.Len(t, report.Copied, 5)
+	assert.Empty(t, report.Skipped)
+	assert.Empty(t, report.Failed)
+	assert.Equal(t, int64(len("foo 1.0foo 1.1bar 0.1baz 2.0.0baz 2.0.0 sdist")), report.Bytes)
+
+	assert.Equal(t, "foo 1.1", readString(t, dst, "foo/foo-1.1.tar.gz"))
+	assert.Equal(t, "baz 2.0.0 sdist", readString(t, dst, "baz/baz-2.0.0.tar.gz"))
+}
+
+func TestRunResumes(t *testing.T) {
+	src := newSource(t)
+	dst := storage.NewMemoryStorage()
+	require.NoError(t, dst.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo 1.0")))
+
+	report, err := Run(t.Context(), src, dst, Options{Concurrency: 4})
+	require.NoError(t, err)
+	assert.Len(t, report.Copied, 4)
+	assert.Equal(t, []string{"foo/foo-1.0.tar.gz"}, report.Skipped)
+
+	report, err = Run(t.Context(), src, dst, Options{Concurrency: 4})
+	require.NoError(t, err)
+	assert.Empty(t, report.Copied)
+	assert.Len(t, report.Skipped, 5)
+}
+
+func TestRunDryRun(t *testing.T) {
+	src := newSource(t)
+	dst := storage.NewMemoryStorage()
+
+	report, err := Run(t.Context(), src, dst, Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"bar/bar-0.1-py3-none-any.whl",
+		"baz/baz-2.0.0-py3-none-any.whl",
+		"baz/baz-2.0.0.tar.gz",
+		"foo/foo-1.0.tar.gz",
+		"foo/foo-1.1.tar.gz",
+	}, report.Copied)
+
+	packages, err := dst.ListPackages(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, packages)
+}
+
+func TestRunVerifyExisting(t *testing.T) {
+	src := newSource(t)
+	dst := storage.NewMemoryStorage()
+	require.NoError(t, dst.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("corrupted")))
+	require.NoError(t, dst.WriteFile(t.Context(), "foo/foo-1.1.tar.gz", bytes.NewBufferString("foo 1.1")))
+
+	report, err := Run(t.Context(), src, dst, Options{VerifyExisting: true})
+	require.NoError(t, err)
+	assert.Contains(t, report.Copied, "foo/foo-1.0.tar.gz")
+	assert.Equal(t, []string{"foo/foo-1.1.tar.gz"}, report.Skipped)
+	assert.Equal(t, "foo 1.0", readString(t, dst, "foo/foo-1.0.tar.gz"))
+}
+
+// corruptingStorage flips the first byte of every file written to it.
+type corruptingStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s corruptingStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		b[0] ^= 0xff
+	}
+	return s.MemoryStorage.WriteFile(ctx, filePath, bytes.NewReader(b))
+}
+
+func TestRunChecksumMismatch(t *testing.T) {
+	src := newSource(t)
+	dst := corruptingStorage{storage.NewMemoryStorage()}
+
+	report, err := Run(t.Context(), src, dst, Options{Concurrency: 2})
+	require.NoError(t, err)
+	assert.Empty(t, report.Copied)
+	assert.Len(t, report.Failed, 5)
+	assert.ErrorContains(t, report.Failed["foo/foo-1.0.tar.gz"], "checksum mismatch")
+
+	// Corrupted copies are removed, so that the next run copies them again.
+	files, err := dst.ListPackageFiles(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err := Run(ctx, storage.NewMemoryStorage(), storage.NewMemoryStorage(), Options{})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReplicate(t *testing.T) {
+	src := newSource(t)
+	dst := storage.NewMemoryStorage()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- Replicate(ctx, src, dst, Options{}, 10*time.Millisecond) }()
+
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		files, err := dst.ListPackageFiles(t.Context(), "foo")
+		assert.NoError(c, err)
+		assert.Len(c, files, 2)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// New uploads to the source show up in the destination.
+	require.NoError(t, src.WriteFile(t.Context(), "foo/foo-1.2.tar.gz", bytes.NewBufferString("foo 1.2")))
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		files, err := dst.ListPackageFiles(t.Context(), "foo")
+		assert.NoError(c, err)
+		assert.Len(c, files, 3)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,113 @@
+package packageindex
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func TestIndexUploadFile_StorageFaults(t *testing.T) {
+	tests := map[string]config.FaultConfig{
+		"error":         {ErrorRate: 1},
+		"partial write": {PartialWriteRate: 1},
+	}
+
+	for name, fault := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := storage.NewMemoryStorage()
+			index := NewIndex(storage.NewFaultyStorage(backend, &config.FaultyConfig{Seed: 1, WriteFile: fault}))
+
+			err := index.UploadFile(ctx, &UploadFileRequest{
+				PackageName: "foo",
+				Version:     "1.0",
+				FileName:    "foo-1.0.tar.gz",
+				FileType:    "sdist",
+			}, strings.NewReader(strings.Repeat("x", 10000)))
+			require.ErrorIs(t, err, storage.ErrInjectedFault)
+
+			// A failed upload is never recorded, and whatever part of it reached the storage is deleted.
+			records, err := NewIndex(backend).ListFileRecords(ctx, "foo")
+			require.NoError(t, err)
+			assert.Empty(t, records)
+
+			files, err := backend.ListPackageFiles(ctx, "foo")
+			require.NoError(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
+
+func TestIndexReadPaths_StorageFaults(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	require.NoError(t, NewIndex(backend).UploadFile(ctx, &UploadFileRequest{
+		PackageName: "foo",
+		Version:     "1.0",
+		FileName:    "foo-1.0.tar.gz",
+		FileType:    "sdist",
+	}, strings.NewReader(strings.Repeat("x", 10000))))
+
+	index := NewIndex(storage.NewFaultyStorage(backend, &config.FaultyConfig{
+		Seed:             1,
+		ListPackages:     config.FaultConfig{ErrorRate: 1},
+		ListPackageFiles: config.FaultConfig{ErrorRate: 1},
+		ReadFile:         config.FaultConfig{TruncateRate: 1},
+	}))
+
+	_, err := index.ListPackages(ctx)
+	require.ErrorIs(t, err, storage.ErrInjectedFault)
+	_, err = index.ListPackageFiles(ctx, "foo")
+	require.ErrorIs(t, err, storage.ErrInjectedFault)
+
+	// Truncated downloads fail instead of ending early.
+	rc, err := index.DownloadFile(ctx, "foo", "foo-1.0.tar.gz")
+	require.NoError(t, err)
+	defer rc.Close()
+	_, err = io.ReadAll(rc)
+	require.ErrorIs(t, err, storage.ErrInjectedFault)
+}
+
+func TestIndexReconcile_StorageFaults(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	wheel := buildWheel(t)
+	require.NoError(t, backend.WriteFile(ctx, "foo-bar/foo_bar-1.0-py3-none-any.whl", bytes.NewReader(wheel)))
+
+	// Files that can't be read completely are reported, and never recorded with wrong digests.
+	faulty := NewIndex(storage.NewFaultyStorage(backend, &config.FaultyConfig{
+		Seed:     1,
+		ReadFile: config.FaultConfig{TruncateRate: 1},
+	}))
+	report, err := faulty.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Ingested)
+	assert.Contains(t, report.Failed, "foo-bar/foo_bar-1.0-py3-none-any.whl")
+
+	// Listing failures fail the whole run.
+	faulty = NewIndex(storage.NewFaultyStorage(backend, &config.FaultyConfig{
+		Seed:         1,
+		ListPackages: config.FaultConfig{ErrorRate: 1},
+	}))
+	_, err = faulty.Reconcile(ctx)
+	require.ErrorIs(t, err, storage.ErrInjectedFault)
+
+	// The file is registered once the storage recovers.
+	index := NewIndex(backend)
+	report, err = index.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo-bar/foo_bar-1.0-py3-none-any.whl"}, report.Ingested)
+
+	records, err := index.ListFileRecords(ctx, "foo-bar")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, sha256Hex(wheel), records[0].SHA256)
+}
@@ -0,0 +1,407 @@
+package packageindex
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// ErrReleaseNotFound is returned for versions that have no recorded files.
+var ErrReleaseNotFound = errors.Wrap(os.ErrNotExist, "release not found")
+
+// Dependency is a requirement of a release, from its Requires-Dist metadata.
+type Dependency struct {
+	// Package is the normalized name of the required project.
+	Package     string   `json:"package"`
+	Requirement string   `json:"requirement"`
+	Specifiers  string   `json:"specifiers,omitempty"`
+	Extras      []string `json:"extras,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Marker      string   `json:"marker,omitempty"`
+}
+
+// ReverseDependency is a release that requires a project.
+type ReverseDependency struct {
+	Package     string `json:"package"`
+	Version     string `json:"version"`
+	Requirement string `json:"requirement"`
+	Specifiers  string `json:"specifiers,omitempty"`
+}
+
+// DependencyClosure is every release reachable from a release through its requirements.
+type DependencyClosure struct {
+	Root string `json:"root"`
+	// Releases are the reachable releases as <package>==<version>, with the packages they require.
+	Releases map[string][]string `json:"releases"`
+	// Missing are requirements that no recorded release satisfies, by the release that has them.
+	Missing map[string][]string `json:"missing,omitempty"`
+	// Cycles are the dependency cycles found, each starting and ending with the same release.
+	Cycles [][]string `json:"cycles,omitempty"`
+}
+
+// dependencyGraphTTL bounds how long the graph misses records changed by other processes sharing
+// the storage, such as admin commands run next to the server.
+const dependencyGraphTTL = time.Minute
+
+// dependencyGraph holds the requirements of every recorded release. It is read from the records
+// on first use, updated whenever the records of a project are saved, and read again once it is
+// older than its TTL.
+type dependencyGraph struct {
+	mu  sync.RWMutex
+	ttl time.Duration
+	// loadedAt is zero until the graph is read from the records.
+	loadedAt time.Time
+	// releases maps normalized projects to their versions and requirements.
+	releases map[string]map[string][]*utils.Requirement
+}
+
+func newDependencyGraph() *dependencyGraph {
+	return &dependencyGraph{ttl: dependencyGraphTTL, releases: map[string]map[string][]*utils.Requirement{}}
+}
+
+// fresh reports whether the graph was read from the records within its TTL. Callers must hold g.mu.
+func (g *dependencyGraph) fresh() bool {
+	return !g.loadedAt.IsZero() && time.Since(g.loadedAt) < g.ttl
+}
+
+// update replaces the releases of a project. Callers must hold i.mu, so that updates are applied
+// in the order the records are saved.
+func (g *dependencyGraph) update(packageName string, records *projectRecords) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.loadedAt.IsZero() {
+		return
+	}
+	if len(records.Files) == 0 {
+		delete(g.releases, packageName)
+		return
+	}
+	g.releases[packageName] = releaseRequirements(packageName, records)
+}
+
+// releaseRequirements collects the requirements of each version. Files of the same version can
+// declare different requirements, such as platform wheels, so they are merged.
+func releaseRequirements(packageName string, records *projectRecords) map[string][]*utils.Requirement {
+	releases := map[string][]*utils.Requirement{}
+	seen := map[string]map[string]struct{}{}
+	for _, record := range sortedRecords(records) {
+		version := record.Version
+		if version == "" {
+			version = record.Metadata.Version
+		}
+		if _, ok := releases[version]; !ok {
+			releases[version] = []*utils.Requirement{}
+			seen[version] = map[string]struct{}{}
+		}
+
+		for _, requiresDist := range record.Metadata.RequiresDist {
+			r, err := utils.ParseRequirement(requiresDist)
+			if err != nil {
+				// Files written to the storage directly aren't validated.
+				log.Debug().Err(err).Str("package", packageName).Str("file", record.FileName).Msg("Ignoring invalid Requires-Dist")
+				continue
+			}
+			if _, ok := seen[version][r.String()]; ok {
+				continue
+			}
+			seen[version][r.String()] = struct{}{}
+			releases[version] = append(releases[version], r)
+		}
+	}
+	return releases
+}
+
+// dependencyGraph returns the graph, reading every project's records the first time and
+// whenever the graph expired.
+func (i *index) dependencyGraph(ctx context.Context) (*dependencyGraph, error) {
+	i.graph.mu.RLock()
+	fresh := i.graph.fresh()
+	i.graph.mu.RUnlock()
+	if fresh {
+		return i.graph, nil
+	}
+
+	// Records can't change while they are read, so no update is missed.
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.graph.mu.RLock()
+	fresh = i.graph.fresh()
+	i.graph.mu.RUnlock()
+	if fresh {
+		return i.graph, nil
+	}
+
+	packages, err := i.recordedPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	releases := map[string]map[string][]*utils.Requirement{}
+	for _, pkg := range packages {
+		records, err := i.loadRecords(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
+		releases[pkg] = releaseRequirements(pkg, records)
+	}
+
+	i.graph.mu.Lock()
+	defer i.graph.mu.Unlock()
+	i.graph.releases = releases
+	i.graph.loadedAt = time.Now()
+	return i.graph, nil
+}
+
+// lookupRelease finds a version of a project, which may be written differently than recorded,
+// like 1.0 for 1.0.0. Callers must hold g.mu.
+func (g *dependencyGraph) lookupRelease(packageName, version string) (string, []*utils.Requirement, bool) {
+	versions := g.releases[packageName]
+	if requirements, ok := versions[version]; ok {
+		return version, requirements, true
+	}
+	want, err := utils.ParseVersion(version)
+	if err != nil {
+		return "", nil, false
+	}
+	for recorded, requirements := range versions {
+		if v, err := utils.ParseVersion(recorded); err == nil && v.Compare(want) == 0 {
+			return recorded, requirements, true
+		}
+	}
+	return "", nil, false
+}
+
+func newDependency(r *utils.Requirement) *Dependency {
+	d := &Dependency{
+		Package:     utils.NormalizePackageName(r.Name),
+		Requirement: r.String(),
+		Specifiers:  r.Specifiers.String(),
+		Extras:      r.Extras,
+		URL:         r.URL,
+	}
+	if r.Marker != nil {
+		d.Marker = r.Marker.String()
+	}
+	return d
+}
+
+func (i *index) Dependencies(ctx context.Context, packageName, version string) ([]*Dependency, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+	g, err := i.dependencyGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, requirements, ok := g.lookupRelease(utils.NormalizePackageName(packageName), version)
+	if !ok {
+		return nil, errors.Wrapf(ErrReleaseNotFound, "%s %s", packageName, version)
+	}
+
+	dependencies := make([]*Dependency, 0, len(requirements))
+	for _, r := range requirements {
+		dependencies = append(dependencies, newDependency(r))
+	}
+	return dependencies, nil
+}
+
+func (i *index) ReverseDependencies(ctx context.Context, packageName string) ([]*ReverseDependency, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+	g, err := i.dependencyGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	packageName = utils.NormalizePackageName(packageName)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	dependents := []*ReverseDependency{}
+	for pkg, versions := range g.releases {
+		for version, requirements := range versions {
+			for _, r := range requirements {
+				if utils.NormalizePackageName(r.Name) != packageName {
+					continue
+				}
+				dependents = append(dependents, &ReverseDependency{
+					Package:     pkg,
+					Version:     version,
+					Requirement: r.String(),
+					Specifiers:  r.Specifiers.String(),
+				})
+			}
+		}
+	}
+	sort.Slice(dependents, func(a, b int) bool {
+		if dependents[a].Package != dependents[b].Package {
+			return dependents[a].Package < dependents[b].Package
+		}
+		if dependents[a].Version != dependents[b].Version {
+			return compareVersionStrings(dependents[a].Version, dependents[b].Version) > 0
+		}
+		return dependents[a].Requirement < dependents[b].Requirement
+	})
+	return dependents, nil
+}
+
+// compareVersionStrings orders versions by precedence, and unparseable ones after them by name.
+func compareVersionStrings(a, b string) int {
+	va, errA := utils.ParseVersion(a)
+	vb, errB := utils.ParseVersion(b)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	return strings.Compare(b, a)
+}
+
+// DependencyClosure follows the requirements of a release, picking the newest recorded version
+// that satisfies each. Requirements whose marker doesn't hold in env are skipped. Without an
+// environment, markers aren't evaluated, except that requirements of extras are always skipped.
+func (i *index) DependencyClosure(ctx context.Context, packageName, version string, env utils.MarkerEnvironment) (*DependencyClosure, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+	g, err := i.dependencyGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	packageName = utils.NormalizePackageName(packageName)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	version, _, ok := g.lookupRelease(packageName, version)
+	if !ok {
+		return nil, errors.Wrapf(ErrReleaseNotFound, "%s %s", packageName, version)
+	}
+
+	w := &closureWalker{
+		graph:   g,
+		env:     env,
+		closure: &DependencyClosure{Root: releaseKey(packageName, version), Releases: map[string][]string{}},
+		state:   map[string]int{},
+	}
+	w.visit(packageName, version)
+	return w.closure, nil
+}
+
+func releaseKey(packageName, version string) string {
+	return packageName + "==" + version
+}
+
+// closureWalker is a depth-first search that reports back edges as cycles.
+type closureWalker struct {
+	graph   *dependencyGraph
+	env     utils.MarkerEnvironment
+	closure *DependencyClosure
+
+	// state is 1 while a release is on the stack, and 2 once it is done.
+	state map[string]int
+	stack []string
+}
+
+func (w *closureWalker) visit(packageName, version string) {
+	key := releaseKey(packageName, version)
+	w.state[key] = 1
+	w.stack = append(w.stack, key)
+	defer func() {
+		w.stack = w.stack[:len(w.stack)-1]
+		w.state[key] = 2
+	}()
+
+	required := []string{}
+	for _, r := range w.graph.releases[packageName][version] {
+		if !w.follows(r) {
+			continue
+		}
+		dep := utils.NormalizePackageName(r.Name)
+		depVersion, ok := w.pick(dep, r)
+		if !ok {
+			if w.closure.Missing == nil {
+				w.closure.Missing = map[string][]string{}
+			}
+			w.closure.Missing[key] = append(w.closure.Missing[key], r.String())
+			continue
+		}
+
+		depKey := releaseKey(dep, depVersion)
+		required = append(required, depKey)
+		switch w.state[depKey] {
+		case 0:
+			w.visit(dep, depVersion)
+		case 1:
+			start := len(w.stack) - 1
+			for w.stack[start] != depKey {
+				start--
+			}
+			cycle := append(append([]string{}, w.stack[start:]...), depKey)
+			w.closure.Cycles = append(w.closure.Cycles, cycle)
+		}
+	}
+	w.closure.Releases[key] = required
+}
+
+func (w *closureWalker) follows(r *utils.Requirement) bool {
+	if r.Marker == nil {
+		return true
+	}
+	if w.env != nil {
+		return r.Marker.Evaluate(w.env)
+	}
+	return !markerUsesVariable(r.Marker, "extra")
+}
+
+// pick returns the newest recorded version that satisfies the requirement.
+func (w *closureWalker) pick(packageName string, r *utils.Requirement) (string, bool) {
+	if r.URL != "" {
+		return "", false
+	}
+
+	byVersion := map[*utils.Version]string{}
+	var versions []*utils.Version
+	for recorded := range w.graph.releases[packageName] {
+		v, err := utils.ParseVersion(recorded)
+		if err != nil {
+			continue
+		}
+		byVersion[v] = recorded
+		versions = append(versions, v)
+	}
+
+	var newest *utils.Version
+	for _, v := range r.Specifiers.Filter(versions) {
+		if newest == nil || v.Compare(newest) > 0 {
+			newest = v
+		}
+	}
+	if newest == nil {
+		return "", false
+	}
+	return byVersion[newest], true
+}
+
+func markerUsesVariable(m utils.Marker, variable string) bool {
+	switch m := m.(type) {
+	case *utils.MarkerOperation:
+		return markerUsesVariable(m.Left, variable) || markerUsesVariable(m.Right, variable)
+	case *utils.MarkerComparison:
+		return m.Left.Variable == variable || m.Right.Variable == variable
+	}
+	return false
+}
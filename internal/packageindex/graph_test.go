@@ -0,0 +1,142 @@
+package packageindex
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func uploadRelease(ctx context.Context, t *testing.T, index Index, pkg, version string, requiresDist ...string) {
+	t.Helper()
+
+	require.NoError(t, index.UploadFile(ctx, &UploadFileRequest{
+		PackageName:  pkg,
+		Version:      version,
+		FileName:     strings.ReplaceAll(pkg, "-", "_") + "-" + version + ".tar.gz",
+		FileType:     "sdist",
+		RequiresDist: requiresDist,
+	}, strings.NewReader("content")))
+}
+
+func TestIndexDependencies(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadRelease(ctx, t, index, "app", "1.0", "Lib>=1.0", `tomli; python_version < "3.11"`, `pytest; extra == "test"`)
+	uploadRelease(ctx, t, index, "other", "2.0", "lib==1.*")
+
+	deps, err := index.Dependencies(ctx, "App", "1.0.0")
+	require.NoError(t, err)
+	require.Len(t, deps, 3)
+	assert.Equal(t, &Dependency{Package: "lib", Requirement: "Lib>=1.0", Specifiers: ">=1.0"}, deps[0])
+	assert.Equal(t, `python_version < "3.11"`, deps[1].Marker)
+
+	_, err = index.Dependencies(ctx, "app", "2.0")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = index.Dependencies(ctx, "-invalid", "1.0")
+	assert.ErrorIs(t, err, ErrInvalidPackageName)
+
+	dependents, err := index.ReverseDependencies(ctx, "LIB")
+	require.NoError(t, err)
+	assert.Equal(t, []*ReverseDependency{
+		{Package: "app", Version: "1.0", Requirement: "Lib>=1.0", Specifiers: ">=1.0"},
+		{Package: "other", Version: "2.0", Requirement: "lib==1.*", Specifiers: "==1.*"},
+	}, dependents)
+}
+
+func TestIndexDependencies_Incremental(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	uploadRelease(ctx, t, NewIndex(strg), "app", "1.0", "lib")
+
+	// The graph is read from the records written before.
+	index := NewIndex(strg)
+	dependents, err := index.ReverseDependencies(ctx, "lib")
+	require.NoError(t, err)
+	assert.Len(t, dependents, 1)
+
+	// And follows uploads and deletes afterwards.
+	uploadRelease(ctx, t, index, "app", "2.0", "lib>=2")
+	dependents, err = index.ReverseDependencies(ctx, "lib")
+	require.NoError(t, err)
+	require.Len(t, dependents, 2)
+	assert.Equal(t, "2.0", dependents[0].Version)
+
+	require.NoError(t, index.DeleteFile(ctx, "app", "app-1.0.tar.gz"))
+	require.NoError(t, index.DeleteFile(ctx, "app", "app-2.0.tar.gz"))
+	dependents, err = index.ReverseDependencies(ctx, "lib")
+	require.NoError(t, err)
+	assert.Empty(t, dependents)
+	_, err = index.Dependencies(ctx, "app", "2.0")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestIndexDependencies_OtherProcesses(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	idx, ok := NewIndex(strg).(*index)
+	require.True(t, ok)
+	uploadRelease(ctx, t, idx, "app", "1.0.dev1", "lib")
+	uploadRelease(ctx, t, idx, "app", "1.0.dev2", "lib")
+
+	dependents, err := idx.ReverseDependencies(ctx, "lib")
+	require.NoError(t, err)
+	assert.Len(t, dependents, 2)
+
+	// Retention run by another process sharing the storage.
+	other := NewIndex(strg, WithRetention(&config.RetentionConfig{Default: config.RetentionRule{KeepDevReleases: 1}}))
+	report, err := other.ApplyRetention(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Deleted, 1)
+
+	// The deletion shows up once the graph expires.
+	idx.graph.mu.Lock()
+	idx.graph.loadedAt = idx.graph.loadedAt.Add(-dependencyGraphTTL)
+	idx.graph.mu.Unlock()
+	dependents, err = idx.ReverseDependencies(ctx, "lib")
+	require.NoError(t, err)
+	require.Len(t, dependents, 1)
+	assert.Equal(t, "1.0.dev2", dependents[0].Version)
+}
+
+func TestIndexDependencyClosure(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadRelease(ctx, t, index, "app", "1.0", "a>=1", `tomli; python_version < "3.11"`, `pytest; extra == "test"`, "vendored @ https://example.com/v.whl")
+	uploadRelease(ctx, t, index, "a", "1.0", "b")
+	uploadRelease(ctx, t, index, "a", "1.5", "b<2")
+	uploadRelease(ctx, t, index, "a", "2.0a1", "b")
+	uploadRelease(ctx, t, index, "b", "1.0", "a")
+	uploadRelease(ctx, t, index, "b", "2.0")
+	uploadRelease(ctx, t, index, "tomli", "2.0")
+
+	closure, err := index.DependencyClosure(ctx, "app", "1.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "app==1.0", closure.Root)
+	assert.Equal(t, map[string][]string{
+		"app==1.0":   {"a==1.5", "tomli==2.0"},
+		"a==1.5":     {"b==1.0"},
+		"b==1.0":     {"a==1.5"},
+		"tomli==2.0": {},
+	}, closure.Releases)
+	assert.Equal(t, map[string][]string{"app==1.0": {"vendored @ https://example.com/v.whl"}}, closure.Missing)
+	assert.Equal(t, [][]string{{"a==1.5", "b==1.0", "a==1.5"}}, closure.Cycles)
+
+	// Markers are evaluated when an environment is given.
+	closure, err = index.DependencyClosure(ctx, "app", "1.0", utils.DefaultMarkerEnvironment("3.12").WithExtra("test"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a==1.5"}, closure.Releases["app==1.0"])
+	assert.Equal(t, []string{`pytest; extra == "test"`, "vendored @ https://example.com/v.whl"}, closure.Missing["app==1.0"])
+
+	_, err = index.DependencyClosure(ctx, "app", "3.0", nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -3,11 +3,15 @@ package packageindex
 import (
 	"context"
 	"io"
+	"os"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 
+	"github.com/jeongukjae/pypi-server/internal/config"
 	"github.com/jeongukjae/pypi-server/internal/storage"
 	"github.com/jeongukjae/pypi-server/internal/utils"
 )
@@ -46,6 +50,11 @@ type UploadFileRequest struct {
 	Md5Digest              *string
 	Sha256Digest           *string
 	Blake2256Digest        *string
+
+	// Uploader is the authenticated user, recorded as the owner of the file.
+	Uploader string
+	// Size is the size of the uploaded content, used to check quotas before storing it.
+	Size int64
 }
 
 //go:generate go tool go.uber.org/mock/mockgen -source=index.go -destination=./index_mock.go -package=packageindex Index
@@ -56,23 +65,125 @@ type Index interface {
 	ListPackageFiles(ctx context.Context, packageName string) ([]string, error)
 	DownloadFile(ctx context.Context, packageName, fileName string) (io.ReadCloser, error)
 	UploadFile(ctx context.Context, req *UploadFileRequest, content io.Reader) error
+	// DeleteFile deletes a file and its record.
+	DeleteFile(ctx context.Context, packageName, fileName string) error
+	// YankFile marks a recorded file as yanked with the given reason, or un-yanks it if the reason is nil.
+	YankFile(ctx context.Context, packageName, fileName string, reason *string) error
+
+	// ListFileRecords returns the records of a package's files, sorted by file name.
+	ListFileRecords(ctx context.Context, packageName string) ([]*FileRecord, error)
+	// Reconcile registers files that were written to the storage directly, and drops
+	// records of files that are gone.
+	Reconcile(ctx context.Context) (*ReconcileReport, error)
+	// ReconcilePackage is Reconcile for a single package.
+	ReconcilePackage(ctx context.Context, packageName string) (*ReconcileReport, error)
+
+	// Usage returns the storage used per project and per uploader.
+	Usage(ctx context.Context) (*Usage, error)
+	// RecalculateUsage rebuilds the usage counters from the storage.
+	RecalculateUsage(ctx context.Context) (*Usage, error)
+
+	// ApplyRetention deletes the files that the retention rules don't keep. A dry run only
+	// reports them.
+	ApplyRetention(ctx context.Context, dryRun bool) (*RetentionReport, error)
+	// ApplyRetentionPackage is ApplyRetention for a single package.
+	ApplyRetentionPackage(ctx context.Context, packageName string, dryRun bool) (*RetentionReport, error)
+
+	// Dependencies returns the requirements of a release, from the Requires-Dist of its files.
+	Dependencies(ctx context.Context, packageName, version string) ([]*Dependency, error)
+	// ReverseDependencies returns the releases that require a project, with their specifiers.
+	ReverseDependencies(ctx context.Context, packageName string) ([]*ReverseDependency, error)
+	// DependencyClosure returns the releases reachable from a release, and the cycles among them.
+	// A nil environment doesn't evaluate markers.
+	DependencyClosure(ctx context.Context, packageName, version string, env utils.MarkerEnvironment) (*DependencyClosure, error)
+	// Resolve pins a file for every project needed by the requirements. If they can't be satisfied,
+	// the error is a *ResolutionConflict.
+	Resolve(ctx context.Context, req *ResolveRequest) (*Resolution, error)
+	// VerifyLock checks the files of a pylock.toml lock file against the index, whose files are
+	// served under indexURL. An empty indexURL only checks the paths of file URLs.
+	VerifyLock(ctx context.Context, lock io.Reader, indexURL string) (*LockReport, error)
+	// HashPins returns the files of pinned releases, in the order of the pins, for writing
+	// hash-checking requirements files.
+	HashPins(ctx context.Context, req *HashPinRequest) ([]*HashPin, error)
+	// BestWheels returns the wheel of each version of a package that the target prefers, newest
+	// version first, with the platforms of the target that each version has no wheel for.
+	BestWheels(ctx context.Context, packageName string, target *WheelTarget) ([]*VersionWheels, error)
+}
+
+type IndexOption func(*index)
+
+// WithIngestOwner sets the uploader recorded for files registered by Reconcile.
+func WithIngestOwner(owner string) IndexOption {
+	return func(i *index) {
+		i.ingestOwner = owner
+	}
+}
+
+// WithQuotas enforces quotas on uploads.
+func WithQuotas(quotas *config.QuotaConfig) IndexOption {
+	return func(i *index) {
+		i.quotas = *quotas
+	}
+}
+
+// WithRetention sets the rules applied by ApplyRetention.
+func WithRetention(retention *config.RetentionConfig) IndexOption {
+	return func(i *index) {
+		i.retention = *retention
+	}
+}
+
+// WithUploadPolicy sets the rules that UploadFile enforces.
+func WithUploadPolicy(policy *config.UploadPolicyConfig) IndexOption {
+	return func(i *index) {
+		i.policy = *policy
+	}
 }
 
-func NewIndex(strg storage.Storage) Index {
-	return &index{
-		strg: strg,
+func NewIndex(strg storage.Storage, opts ...IndexOption) Index {
+	i := &index{
+		strg:  strg,
+		graph: newDependencyGraph(),
 	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
 }
 
 type index struct {
-	strg storage.Storage
+	strg        storage.Storage
+	ingestOwner string
+	quotas      config.QuotaConfig
+	retention   config.RetentionConfig
+	policy      config.UploadPolicyConfig
+
+	// mu serializes updates of file records.
+	mu sync.Mutex
+	// graph follows the records as they are saved.
+	graph *dependencyGraph
 }
 
 func (i *index) ListPackages(ctx context.Context) ([]string, error) {
-	return i.strg.ListPackages(ctx)
+	packages, err := i.strg.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Storage may contain entries that were not created through the index, don't expose them.
+	valid := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		if ValidatePackageName(pkg) == nil {
+			valid = append(valid, pkg)
+		}
+	}
+	return valid, nil
 }
 
 func (i *index) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
 	packageName = utils.NormalizePackageName(packageName)
 
 	files, err := i.strg.ListPackageFiles(ctx, packageName)
@@ -81,19 +192,158 @@ func (i *index) ListPackageFiles(ctx context.Context, packageName string) ([]str
 		return nil, errors.Wrap(err, "failed to list package files from storage")
 	}
 
-	return files, nil
+	valid := make([]string, 0, len(files))
+	for _, file := range files {
+		if ValidateFileName(file) == nil {
+			valid = append(valid, file)
+		}
+	}
+	return valid, nil
 }
 
 func (i *index) DownloadFile(ctx context.Context, packageName, fileName string) (io.ReadCloser, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+	if err := ValidateFileName(fileName); err != nil {
+		return nil, err
+	}
+
 	packageName = utils.NormalizePackageName(packageName)
 	return i.strg.ReadFile(ctx, path.Join(packageName, fileName))
 }
 
 func (i *index) UploadFile(ctx context.Context, req *UploadFileRequest, content io.Reader) error {
-	filepath := path.Join(req.PackageName, req.FileName)
+	if err := ValidatePackageName(req.PackageName); err != nil {
+		return err
+	}
+	if err := ValidateFileName(req.FileName); err != nil {
+		return err
+	}
+	if req.RequiresPython != nil {
+		if err := ValidateRequiresPython(*req.RequiresPython); err != nil {
+			return err
+		}
+	}
+	if err := ValidateRequiresDist(req.RequiresDist); err != nil {
+		return err
+	}
+
+	packageName := utils.NormalizePackageName(req.PackageName)
+	if err := i.checkUploadPolicy(ctx, packageName, req); err != nil {
+		return err
+	}
+	if err := i.checkQuota(ctx, packageName, req); err != nil {
+		return err
+	}
+
+	filepath := path.Join(packageName, req.FileName)
+	hasher := newFileHasher()
+	content = io.TeeReader(content, hasher)
 	if err := i.strg.WriteFile(ctx, filepath, content); err != nil {
 		log.Ctx(ctx).Error().Err(err).Msg("failed to write file to storage")
+		i.discardUnrecordedFile(ctx, packageName, req.FileName)
 		return errors.Wrap(err, "failed to write file to storage")
 	}
+	// Backends read the content to the end, but make sure the digests cover all of it.
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		i.discardUnrecordedFile(ctx, packageName, req.FileName)
+		return errors.Wrap(err, "failed to read uploaded file")
+	}
+
+	record := &FileRecord{
+		FileName: req.FileName,
+		Version:  req.Version,
+		FileType: req.FileType,
+		Metadata: Metadata{
+			MetadataVersion: req.MetadataVersion,
+			Name:            req.PackageName,
+			Version:         req.Version,
+			Summary:         utils.Deref(req.Summary),
+			RequiresPython:  utils.Deref(req.RequiresPython),
+			RequiresDist:    req.RequiresDist,
+		},
+		UploadedBy: req.Uploader,
+		UploadedAt: time.Now().UTC(),
+		Source:     SourceUpload,
+	}
+	hasher.record(record)
+	err := i.putRecord(ctx, packageName, record, func(records *projectRecords) error {
+		return i.uploadPolicyViolation(packageName, req, records)
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to record uploaded file")
+		i.discardUnrecordedFile(ctx, packageName, req.FileName)
+		return err
+	}
 	return nil
 }
+
+// discardUnrecordedFile deletes what a failed upload left in the storage, since a file without a
+// record is invisible to quotas and hashes. A file that is already recorded is left alone, since
+// deleting it would lose the previous upload.
+func (i *index) discardUnrecordedFile(ctx context.Context, packageName, fileName string) {
+	ctx = context.WithoutCancel(ctx)
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to check records of a failed upload")
+		return
+	}
+	if _, ok := records.Files[fileName]; ok {
+		return
+	}
+
+	filePath := path.Join(packageName, fileName)
+	if err := i.strg.DeleteFile(ctx, filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Ctx(ctx).Error().Err(err).Str("path", filePath).Msg("failed to delete unrecorded file")
+	}
+}
+
+func (i *index) DeleteFile(ctx context.Context, packageName, fileName string) error {
+	if err := ValidatePackageName(packageName); err != nil {
+		return err
+	}
+	if err := ValidateFileName(fileName); err != nil {
+		return err
+	}
+
+	packageName = utils.NormalizePackageName(packageName)
+	err := i.strg.DeleteFile(ctx, path.Join(packageName, fileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to delete file from storage")
+		return errors.Wrap(err, "failed to delete file from storage")
+	}
+
+	// The record goes even if the file was already gone.
+	removed, rerr := i.removeRecord(ctx, packageName, fileName)
+	if rerr != nil {
+		return rerr
+	}
+	if err != nil && !removed {
+		return err
+	}
+	return nil
+}
+
+func (i *index) YankFile(ctx context.Context, packageName, fileName string, reason *string) error {
+	if err := ValidatePackageName(packageName); err != nil {
+		return err
+	}
+	if err := ValidateFileName(fileName); err != nil {
+		return err
+	}
+
+	return i.setYanked(ctx, utils.NormalizePackageName(packageName), fileName, reason)
+}
+
+func (i *index) ListFileRecords(ctx context.Context, packageName string) ([]*FileRecord, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+
+	records, err := i.loadRecords(ctx, utils.NormalizePackageName(packageName))
+	if err != nil {
+		return nil, err
+	}
+	return sortedRecords(records), nil
+}
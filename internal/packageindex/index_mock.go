@@ -14,6 +14,7 @@ import (
 	io "io"
 	reflect "reflect"
 
+	utils "github.com/jeongukjae/pypi-server/internal/utils"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -41,6 +42,95 @@ func (m *MockIndex) EXPECT() *MockIndexMockRecorder {
 	return m.recorder
 }
 
+// ApplyRetention mocks base method.
+func (m *MockIndex) ApplyRetention(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyRetention", ctx, dryRun)
+	ret0, _ := ret[0].(*RetentionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyRetention indicates an expected call of ApplyRetention.
+func (mr *MockIndexMockRecorder) ApplyRetention(ctx, dryRun any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyRetention", reflect.TypeOf((*MockIndex)(nil).ApplyRetention), ctx, dryRun)
+}
+
+// ApplyRetentionPackage mocks base method.
+func (m *MockIndex) ApplyRetentionPackage(ctx context.Context, packageName string, dryRun bool) (*RetentionReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyRetentionPackage", ctx, packageName, dryRun)
+	ret0, _ := ret[0].(*RetentionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyRetentionPackage indicates an expected call of ApplyRetentionPackage.
+func (mr *MockIndexMockRecorder) ApplyRetentionPackage(ctx, packageName, dryRun any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyRetentionPackage", reflect.TypeOf((*MockIndex)(nil).ApplyRetentionPackage), ctx, packageName, dryRun)
+}
+
+// BestWheels mocks base method.
+func (m *MockIndex) BestWheels(ctx context.Context, packageName string, target *WheelTarget) ([]*VersionWheels, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BestWheels", ctx, packageName, target)
+	ret0, _ := ret[0].([]*VersionWheels)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BestWheels indicates an expected call of BestWheels.
+func (mr *MockIndexMockRecorder) BestWheels(ctx, packageName, target any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BestWheels", reflect.TypeOf((*MockIndex)(nil).BestWheels), ctx, packageName, target)
+}
+
+// DeleteFile mocks base method.
+func (m *MockIndex) DeleteFile(ctx context.Context, packageName, fileName string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFile", ctx, packageName, fileName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteFile indicates an expected call of DeleteFile.
+func (mr *MockIndexMockRecorder) DeleteFile(ctx, packageName, fileName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFile", reflect.TypeOf((*MockIndex)(nil).DeleteFile), ctx, packageName, fileName)
+}
+
+// Dependencies mocks base method.
+func (m *MockIndex) Dependencies(ctx context.Context, packageName, version string) ([]*Dependency, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Dependencies", ctx, packageName, version)
+	ret0, _ := ret[0].([]*Dependency)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Dependencies indicates an expected call of Dependencies.
+func (mr *MockIndexMockRecorder) Dependencies(ctx, packageName, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dependencies", reflect.TypeOf((*MockIndex)(nil).Dependencies), ctx, packageName, version)
+}
+
+// DependencyClosure mocks base method.
+func (m *MockIndex) DependencyClosure(ctx context.Context, packageName, version string, env utils.MarkerEnvironment) (*DependencyClosure, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DependencyClosure", ctx, packageName, version, env)
+	ret0, _ := ret[0].(*DependencyClosure)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DependencyClosure indicates an expected call of DependencyClosure.
+func (mr *MockIndexMockRecorder) DependencyClosure(ctx, packageName, version, env any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DependencyClosure", reflect.TypeOf((*MockIndex)(nil).DependencyClosure), ctx, packageName, version, env)
+}
+
 // DownloadFile mocks base method.
 func (m *MockIndex) DownloadFile(ctx context.Context, packageName, fileName string) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()
@@ -56,6 +146,36 @@ func (mr *MockIndexMockRecorder) DownloadFile(ctx, packageName, fileName any) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadFile", reflect.TypeOf((*MockIndex)(nil).DownloadFile), ctx, packageName, fileName)
 }
 
+// HashPins mocks base method.
+func (m *MockIndex) HashPins(ctx context.Context, req *HashPinRequest) ([]*HashPin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HashPins", ctx, req)
+	ret0, _ := ret[0].([]*HashPin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HashPins indicates an expected call of HashPins.
+func (mr *MockIndexMockRecorder) HashPins(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HashPins", reflect.TypeOf((*MockIndex)(nil).HashPins), ctx, req)
+}
+
+// ListFileRecords mocks base method.
+func (m *MockIndex) ListFileRecords(ctx context.Context, packageName string) ([]*FileRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListFileRecords", ctx, packageName)
+	ret0, _ := ret[0].([]*FileRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFileRecords indicates an expected call of ListFileRecords.
+func (mr *MockIndexMockRecorder) ListFileRecords(ctx, packageName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFileRecords", reflect.TypeOf((*MockIndex)(nil).ListFileRecords), ctx, packageName)
+}
+
 // ListPackageFiles mocks base method.
 func (m *MockIndex) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
 	m.ctrl.T.Helper()
@@ -86,16 +206,135 @@ func (mr *MockIndexMockRecorder) ListPackages(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPackages", reflect.TypeOf((*MockIndex)(nil).ListPackages), ctx)
 }
 
+// RecalculateUsage mocks base method.
+func (m *MockIndex) RecalculateUsage(ctx context.Context) (*Usage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecalculateUsage", ctx)
+	ret0, _ := ret[0].(*Usage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecalculateUsage indicates an expected call of RecalculateUsage.
+func (mr *MockIndexMockRecorder) RecalculateUsage(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecalculateUsage", reflect.TypeOf((*MockIndex)(nil).RecalculateUsage), ctx)
+}
+
+// Reconcile mocks base method.
+func (m *MockIndex) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reconcile", ctx)
+	ret0, _ := ret[0].(*ReconcileReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconcile indicates an expected call of Reconcile.
+func (mr *MockIndexMockRecorder) Reconcile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconcile", reflect.TypeOf((*MockIndex)(nil).Reconcile), ctx)
+}
+
+// ReconcilePackage mocks base method.
+func (m *MockIndex) ReconcilePackage(ctx context.Context, packageName string) (*ReconcileReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcilePackage", ctx, packageName)
+	ret0, _ := ret[0].(*ReconcileReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcilePackage indicates an expected call of ReconcilePackage.
+func (mr *MockIndexMockRecorder) ReconcilePackage(ctx, packageName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcilePackage", reflect.TypeOf((*MockIndex)(nil).ReconcilePackage), ctx, packageName)
+}
+
+// Resolve mocks base method.
+func (m *MockIndex) Resolve(ctx context.Context, req *ResolveRequest) (*Resolution, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Resolve", ctx, req)
+	ret0, _ := ret[0].(*Resolution)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Resolve indicates an expected call of Resolve.
+func (mr *MockIndexMockRecorder) Resolve(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resolve", reflect.TypeOf((*MockIndex)(nil).Resolve), ctx, req)
+}
+
+// ReverseDependencies mocks base method.
+func (m *MockIndex) ReverseDependencies(ctx context.Context, packageName string) ([]*ReverseDependency, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseDependencies", ctx, packageName)
+	ret0, _ := ret[0].([]*ReverseDependency)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseDependencies indicates an expected call of ReverseDependencies.
+func (mr *MockIndexMockRecorder) ReverseDependencies(ctx, packageName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseDependencies", reflect.TypeOf((*MockIndex)(nil).ReverseDependencies), ctx, packageName)
+}
+
 // UploadFile mocks base method.
-func (m *MockIndex) UploadFile(ctx context.Context, auth Authorization, req UploadFileRequest, content io.Reader) error {
+func (m *MockIndex) UploadFile(ctx context.Context, req *UploadFileRequest, content io.Reader) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UploadFile", ctx, auth, req, content)
+	ret := m.ctrl.Call(m, "UploadFile", ctx, req, content)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UploadFile indicates an expected call of UploadFile.
-func (mr *MockIndexMockRecorder) UploadFile(ctx, auth, req, content any) *gomock.Call {
+func (mr *MockIndexMockRecorder) UploadFile(ctx, req, content any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UploadFile", reflect.TypeOf((*MockIndex)(nil).UploadFile), ctx, req, content)
+}
+
+// Usage mocks base method.
+func (m *MockIndex) Usage(ctx context.Context) (*Usage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Usage", ctx)
+	ret0, _ := ret[0].(*Usage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Usage indicates an expected call of Usage.
+func (mr *MockIndexMockRecorder) Usage(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Usage", reflect.TypeOf((*MockIndex)(nil).Usage), ctx)
+}
+
+// VerifyLock mocks base method.
+func (m *MockIndex) VerifyLock(ctx context.Context, lock io.Reader, indexURL string) (*LockReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyLock", ctx, lock, indexURL)
+	ret0, _ := ret[0].(*LockReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyLock indicates an expected call of VerifyLock.
+func (mr *MockIndexMockRecorder) VerifyLock(ctx, lock, indexURL any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyLock", reflect.TypeOf((*MockIndex)(nil).VerifyLock), ctx, lock, indexURL)
+}
+
+// YankFile mocks base method.
+func (m *MockIndex) YankFile(ctx context.Context, packageName, fileName string, reason *string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "YankFile", ctx, packageName, fileName, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// YankFile indicates an expected call of YankFile.
+func (mr *MockIndexMockRecorder) YankFile(ctx, packageName, fileName, reason any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UploadFile", reflect.TypeOf((*MockIndex)(nil).UploadFile), ctx, auth, req, content)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "YankFile", reflect.TypeOf((*MockIndex)(nil).YankFile), ctx, packageName, fileName, reason)
 }
@@ -0,0 +1,237 @@
+package packageindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func TestIndexUploadAndDownload(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	err := index.UploadFile(ctx, &UploadFileRequest{
+		PackageName: "foo-bar",
+		Version:     "1.0.0",
+		FileName:    "foo_bar-1.0.0-py3-none-any.whl",
+		FileType:    "bdist_wheel",
+	}, strings.NewReader("wheel content"))
+	require.NoError(t, err)
+
+	packages, err := index.ListPackages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo-bar"}, packages)
+
+	files, err := index.ListPackageFiles(ctx, "Foo_Bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo_bar-1.0.0-py3-none-any.whl"}, files)
+
+	rc, err := index.DownloadFile(ctx, "FOO.bar", "foo_bar-1.0.0-py3-none-any.whl")
+	require.NoError(t, err)
+	defer rc.Close()
+
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "wheel content", string(content))
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func uploadTestFile(t *testing.T, index Index, uploader string) {
+	t.Helper()
+
+	err := index.UploadFile(context.Background(), &UploadFileRequest{
+		PackageName:     "foo-bar",
+		Version:         "1.0.0",
+		FileName:        "foo_bar-1.0.0-py3-none-any.whl",
+		FileType:        "bdist_wheel",
+		MetadataVersion: "2.1",
+		RequiresPython:  utils.Pointer(">=3.9"),
+		RequiresDist:    []string{"requests"},
+		Uploader:        uploader,
+	}, strings.NewReader("wheel content"))
+	require.NoError(t, err)
+}
+
+func TestIndexUploadRecordsFile(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadTestFile(t, index, "alice")
+
+	records, err := index.ListFileRecords(ctx, "Foo_Bar")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	record := records[0]
+	assert.Equal(t, "foo_bar-1.0.0-py3-none-any.whl", record.FileName)
+	assert.Equal(t, "1.0.0", record.Version)
+	assert.Equal(t, int64(len("wheel content")), record.Size)
+	assert.Equal(t, sha256Hex([]byte("wheel content")), record.SHA256)
+	assert.Equal(t, "1f984f368f52d42e386983854d0a5f6d", record.MD5)
+	assert.Len(t, record.Blake2b256, 64)
+	assert.Equal(t, ">=3.9", record.Metadata.RequiresPython)
+	assert.Equal(t, []string{"requests"}, record.Metadata.RequiresDist)
+	assert.Equal(t, "alice", record.UploadedBy)
+	assert.Equal(t, SourceUpload, record.Source)
+
+	// Records are internal and never show up as a package.
+	packages, err := index.ListPackages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo-bar"}, packages)
+}
+
+// recordFailingStorage fails every write of file records.
+type recordFailingStorage struct {
+	storage.Storage
+}
+
+func (s *recordFailingStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	if strings.HasPrefix(filePath, recordsDir+"/") {
+		return errors.New("records are read-only")
+	}
+	return s.Storage.WriteFile(ctx, filePath, content)
+}
+
+func TestIndexUploadRecordFailure(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	index := NewIndex(&recordFailingStorage{Storage: backend})
+
+	err := index.UploadFile(ctx, &UploadFileRequest{
+		PackageName: "foo-bar",
+		Version:     "1.0.0",
+		FileName:    "foo_bar-1.0.0-py3-none-any.whl",
+		FileType:    "bdist_wheel",
+	}, strings.NewReader("wheel content"))
+	require.Error(t, err)
+
+	// The stored file is removed again, instead of being served without a record.
+	_, err = backend.ReadFile(ctx, "foo-bar/foo_bar-1.0.0-py3-none-any.whl")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestIndexUploadRecordFailure_Recorded(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	uploadTestFile(t, NewIndex(backend), "alice")
+
+	err := NewIndex(&recordFailingStorage{Storage: backend}).UploadFile(ctx, &UploadFileRequest{
+		PackageName: "foo-bar",
+		Version:     "1.0.0",
+		FileName:    "foo_bar-1.0.0-py3-none-any.whl",
+		FileType:    "bdist_wheel",
+	}, strings.NewReader("new wheel content"))
+	require.Error(t, err)
+
+	// The file is still recorded, so it is kept.
+	_, err = backend.ReadFile(ctx, "foo-bar/foo_bar-1.0.0-py3-none-any.whl")
+	require.NoError(t, err)
+}
+
+// shortWriteStorage stores only the first bytes of every file and reports success.
+type shortWriteStorage struct {
+	storage.Storage
+}
+
+func (s *shortWriteStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	return s.Storage.WriteFile(ctx, filePath, io.LimitReader(content, 4))
+}
+
+func TestIndexUploadReadFailure(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	index := NewIndex(&shortWriteStorage{Storage: backend})
+
+	err := index.UploadFile(ctx, &UploadFileRequest{
+		PackageName: "foo-bar",
+		Version:     "1.0.0",
+		FileName:    "foo_bar-1.0.0-py3-none-any.whl",
+		FileType:    "bdist_wheel",
+	}, io.MultiReader(strings.NewReader("wheel content"), iotest.ErrReader(errors.New("connection reset"))))
+	require.Error(t, err)
+
+	// The stored part of the file is removed, since it was never recorded.
+	_, err = backend.ReadFile(ctx, "foo-bar/foo_bar-1.0.0-py3-none-any.whl")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// flakyStorage fails the first write of every file after reading part of its content.
+type flakyStorage struct {
+	storage.Storage
+
+	mu      sync.Mutex
+	written map[string]bool
+}
+
+func (s *flakyStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	s.mu.Lock()
+	failed := s.written[filePath]
+	s.written[filePath] = true
+	s.mu.Unlock()
+
+	if !failed {
+		_, _ = io.CopyN(io.Discard, content, 4)
+		return errors.New("connection reset")
+	}
+	return s.Storage.WriteFile(ctx, filePath, content)
+}
+
+func TestIndexUploadRetries(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	index := NewIndex(storage.NewResilientStorage(&flakyStorage{Storage: backend, written: map[string]bool{}}, &config.ResilienceConfig{
+		TimeoutSeconds:             5,
+		WriteTimeoutSeconds:        5,
+		MaxRetries:                 1,
+		InitialBackoffMilliseconds: 1,
+		MaxBackoffMilliseconds:     1,
+	}))
+
+	uploadTestFile(t, index, "alice")
+
+	// The whole upload is sent again, and hashed once.
+	rc, err := backend.ReadFile(ctx, "foo-bar/foo_bar-1.0.0-py3-none-any.whl")
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "wheel content", string(content))
+
+	records, err := index.ListFileRecords(ctx, "foo-bar")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, int64(len("wheel content")), records[0].Size)
+	assert.Equal(t, sha256Hex([]byte("wheel content")), records[0].SHA256)
+}
+
+func TestIndexDeleteFile(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+	uploadTestFile(t, index, "alice")
+
+	require.NoError(t, index.DeleteFile(ctx, "foo-bar", "foo_bar-1.0.0-py3-none-any.whl"))
+	records, err := index.ListFileRecords(ctx, "foo-bar")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	require.ErrorIs(t, index.DeleteFile(ctx, "foo-bar", "foo_bar-1.0.0-py3-none-any.whl"), os.ErrNotExist)
+	require.ErrorIs(t, index.DeleteFile(ctx, "foo-bar", "../x"), ErrInvalidFileName)
+}
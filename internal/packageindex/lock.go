@@ -0,0 +1,206 @@
+package packageindex
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+var ErrInvalidLockFile = errors.New("invalid lock file")
+
+// pylock is the part of a PEP 751 lock file that refers to index files.
+//
+// https://packaging.python.org/en/latest/specifications/pylock-toml/
+type pylock struct {
+	LockVersion string          `toml:"lock-version"`
+	Packages    []pylockPackage `toml:"packages"`
+}
+
+type pylockPackage struct {
+	Name    string       `toml:"name"`
+	Version string       `toml:"version"`
+	Index   string       `toml:"index"`
+	Sdist   *pylockFile  `toml:"sdist"`
+	Wheels  []pylockFile `toml:"wheels"`
+}
+
+type pylockFile struct {
+	Name   string            `toml:"name"`
+	URL    string            `toml:"url"`
+	Path   string            `toml:"path"`
+	Size   *int64            `toml:"size"`
+	Hashes map[string]string `toml:"hashes"`
+}
+
+// LockReport is the result of checking a lock file against the index.
+type LockReport struct {
+	Packages int `json:"packages"`
+	Files    int `json:"files"`
+
+	// Missing are files the index doesn't have, or has no record of.
+	Missing []*LockIssue `json:"missing"`
+	Yanked  []*LockIssue `json:"yanked"`
+	// HashMismatches are files whose hashes or size differ from the index, usually because they
+	// were uploaded again.
+	HashMismatches []*LockIssue `json:"hash_mismatches"`
+	// Overridden are packages or files that the lock file takes from somewhere else than the index.
+	Overridden []*LockIssue `json:"overridden"`
+	// Unchecked are packages without index files, such as VCS or directory sources.
+	Unchecked []*LockIssue `json:"unchecked"`
+}
+
+type LockIssue struct {
+	Package  string `json:"package"`
+	Version  string `json:"version,omitempty"`
+	FileName string `json:"filename,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+// OK reports whether every file of the lock file is served by the index as locked.
+func (r *LockReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Yanked) == 0 && len(r.HashMismatches) == 0 && len(r.Overridden) == 0
+}
+
+// recordedHash returns the digest the index records for a hash name of lock files.
+func recordedHash(record *FileRecord, name string) (string, bool) {
+	switch name {
+	case "sha256":
+		return record.SHA256, true
+	case "md5":
+		return record.MD5, true
+	case "blake2b_256":
+		return record.Blake2b256, true
+	default:
+		return "", false
+	}
+}
+
+func (i *index) VerifyLock(ctx context.Context, lock io.Reader, indexURL string) (*LockReport, error) {
+	var l pylock
+	if err := toml.NewDecoder(lock).Decode(&l); err != nil {
+		return nil, errors.Wrap(ErrInvalidLockFile, err.Error())
+	}
+	if major, _, _ := strings.Cut(l.LockVersion, "."); major != "1" {
+		return nil, errors.Wrapf(ErrInvalidLockFile, "unsupported lock-version %q", l.LockVersion)
+	}
+
+	report := &LockReport{
+		Missing:        []*LockIssue{},
+		Yanked:         []*LockIssue{},
+		HashMismatches: []*LockIssue{},
+		Overridden:     []*LockIssue{},
+		Unchecked:      []*LockIssue{},
+	}
+	indexURL = strings.TrimSuffix(indexURL, "/")
+	for _, pkg := range l.Packages {
+		report.Packages++
+		issue := func(fileName, format string, args ...any) *LockIssue {
+			return &LockIssue{Package: pkg.Name, Version: pkg.Version, FileName: fileName, Detail: fmt.Sprintf(format, args...)}
+		}
+
+		files := pkg.Wheels
+		if pkg.Sdist != nil {
+			files = append([]pylockFile{*pkg.Sdist}, files...)
+		}
+		if len(files) == 0 {
+			report.Unchecked = append(report.Unchecked, issue("", "not installed from an index"))
+			continue
+		}
+		if ValidatePackageName(pkg.Name) != nil {
+			report.Missing = append(report.Missing, issue("", "invalid project name"))
+			continue
+		}
+		if pkg.Index != "" && indexURL != "" && strings.TrimSuffix(pkg.Index, "/") != indexURL+"/simple" {
+			report.Overridden = append(report.Overridden, issue("", "locked from %s", pkg.Index))
+			continue
+		}
+
+		packageName := utils.NormalizePackageName(pkg.Name)
+		records, err := i.loadRecords(ctx, packageName)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			report.Files++
+			fileName := lockFileName(f)
+			if f.URL == "" {
+				report.Overridden = append(report.Overridden, issue(fileName, "installed from %s", f.Path))
+				continue
+			}
+			if !servedByIndex(f.URL, indexURL, packageName, fileName) {
+				report.Overridden = append(report.Overridden, issue(fileName, "downloaded from %s", f.URL))
+				continue
+			}
+
+			record, ok := records.Files[fileName]
+			if !ok {
+				report.Missing = append(report.Missing, issue(fileName, "not in the index"))
+				continue
+			}
+			if detail := compareLockHashes(f, record); detail != "" {
+				report.HashMismatches = append(report.HashMismatches, issue(fileName, "%s", detail))
+				continue
+			}
+			if record.Yanked != nil {
+				report.Yanked = append(report.Yanked, issue(fileName, "yanked: %s", *record.Yanked))
+			}
+		}
+	}
+	return report, nil
+}
+
+// lockFileName is the name of a locked file, which is optional and else the end of its URL or path.
+func lockFileName(f pylockFile) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	if u, err := url.Parse(f.URL); err == nil && f.URL != "" {
+		return path.Base(u.Path)
+	}
+	return path.Base(f.Path)
+}
+
+// servedByIndex reports whether a URL is the download URL of a file of the index. Without an
+// index URL, only the path is checked.
+func servedByIndex(rawURL, indexURL, packageName, fileName string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	u.Fragment = ""
+	u.RawQuery = ""
+	if indexURL == "" {
+		return u.Path == "/simple/"+packageName+"/"+fileName
+	}
+	return u.String() == indexURL+"/simple/"+packageName+"/"+fileName
+}
+
+// compareLockHashes describes how the hashes and size of a locked file differ from its record.
+func compareLockHashes(f pylockFile, record *FileRecord) string {
+	if f.Size != nil && *f.Size != record.Size {
+		return fmt.Sprintf("size is %d in the lock file and %d in the index", *f.Size, record.Size)
+	}
+	compared := false
+	for name, digest := range f.Hashes {
+		recorded, ok := recordedHash(record, name)
+		if !ok {
+			continue
+		}
+		compared = true
+		if !strings.EqualFold(digest, recorded) {
+			return fmt.Sprintf("%s is %s in the lock file and %s in the index", name, digest, recorded)
+		}
+	}
+	if !compared {
+		return "no sha256, md5 or blake2b_256 hash to compare"
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package packageindex
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func TestIndexVerifyLock(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "foo-1.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "foo-1.0.tar.gz", "")
+	uploadFile(ctx, t, index, "bar-2.0-py3-none-any.whl", "")
+	require.NoError(t, index.YankFile(ctx, "bar", "bar-2.0-py3-none-any.whl", utils.Pointer("broken")))
+	records, err := index.ListFileRecords(ctx, "foo")
+	require.NoError(t, err)
+	wheel, sdist := records[0], records[1]
+
+	lock := fmt.Sprintf(`
+lock-version = "1.0"
+created-by = "test"
+
+[[packages]]
+name = "Foo"
+version = "1.0"
+index = "https://pypi.example.com/simple/"
+sdist = { url = "https://pypi.example.com/simple/foo/foo-1.0.tar.gz", size = %d, hashes = { sha256 = %q } }
+wheels = [
+  { name = "foo-1.0-py3-none-any.whl", url = "https://pypi.example.com/simple/foo/foo-1.0-py3-none-any.whl#sha256=x", hashes = { md5 = %q, blake2b_256 = %q } },
+  { url = "https://pypi.example.com/simple/foo/foo-1.0-cp312-cp312-linux_x86_64.whl", hashes = { sha256 = "00" } },
+]
+
+[[packages]]
+name = "bar"
+version = "2.0"
+wheels = [{ url = "https://pypi.example.com/simple/bar/bar-2.0-py3-none-any.whl", hashes = { sha256 = "00" } }]
+
+[[packages]]
+name = "baz"
+version = "1.0"
+wheels = [{ url = "https://files.example.org/baz-1.0-py3-none-any.whl", hashes = { sha256 = "00" } }]
+
+[[packages]]
+name = "qux"
+version = "1.0"
+index = "https://other.example.org/simple"
+wheels = [{ url = "https://other.example.org/qux-1.0-py3-none-any.whl", hashes = { sha256 = "00" } }]
+
+[[packages]]
+name = "local"
+directory = { path = "." }
+`, sdist.Size, sdist.SHA256, wheel.MD5, strings.ToUpper(wheel.Blake2b256))
+
+	report, err := index.VerifyLock(ctx, strings.NewReader(lock), "https://pypi.example.com/")
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 5, report.Packages)
+	assert.Equal(t, 5, report.Files)
+	assert.Equal(t, []*LockIssue{
+		{Package: "Foo", Version: "1.0", FileName: "foo-1.0-cp312-cp312-linux_x86_64.whl", Detail: "not in the index"},
+	}, report.Missing)
+	assert.Empty(t, report.Yanked) // the hash mismatch is reported first
+	require.Len(t, report.HashMismatches, 1)
+	assert.Equal(t, "bar-2.0-py3-none-any.whl", report.HashMismatches[0].FileName)
+	assert.Equal(t, []*LockIssue{
+		{Package: "baz", Version: "1.0", FileName: "baz-1.0-py3-none-any.whl", Detail: "downloaded from https://files.example.org/baz-1.0-py3-none-any.whl"},
+		{Package: "qux", Version: "1.0", Detail: "locked from https://other.example.org/simple"},
+	}, report.Overridden)
+	assert.Equal(t, []*LockIssue{{Package: "local", Detail: "not installed from an index"}}, report.Unchecked)
+
+	// Without the index URL, only the paths are compared.
+	bar, err := index.ListFileRecords(ctx, "bar")
+	require.NoError(t, err)
+	lock = fmt.Sprintf(`
+lock-version = "1.0"
+[[packages]]
+name = "bar"
+version = "2.0"
+wheels = [{ url = "http://localhost:3000/simple/bar/bar-2.0-py3-none-any.whl", hashes = { sha256 = %q } }]
+`, bar[0].SHA256)
+	report, err = index.VerifyLock(ctx, strings.NewReader(lock), "")
+	require.NoError(t, err)
+	assert.Equal(t, []*LockIssue{{Package: "bar", Version: "2.0", FileName: "bar-2.0-py3-none-any.whl", Detail: "yanked: broken"}}, report.Yanked)
+
+	require.NoError(t, index.YankFile(ctx, "bar", "bar-2.0-py3-none-any.whl", nil))
+	report, err = index.VerifyLock(ctx, strings.NewReader(lock), "")
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+}
+
+func TestIndexVerifyLock_Invalid(t *testing.T) {
+	index := NewIndex(storage.NewMemoryStorage())
+
+	for _, lock := range []string{"not toml", `lock-version = "2.0"`, `packages = []`} {
+		_, err := index.VerifyLock(context.Background(), strings.NewReader(lock), "")
+		assert.ErrorIs(t, err, ErrInvalidLockFile, lock)
+	}
+}
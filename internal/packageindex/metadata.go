@@ -0,0 +1,122 @@
+package packageindex
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"net/mail"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// ErrNoMetadata is returned when a distribution file has no metadata that the index can read.
+var ErrNoMetadata = errors.New("no metadata found in distribution")
+
+// maxMetadataSize bounds the metadata file read from an archive. Long descriptions are the only large part.
+const maxMetadataSize = 16 * 1024 * 1024
+
+// Metadata holds the core metadata fields that the index uses.
+//
+// https://packaging.python.org/en/latest/specifications/core-metadata/
+type Metadata struct {
+	MetadataVersion string   `json:"metadata_version,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	Summary         string   `json:"summary,omitempty"`
+	RequiresPython  string   `json:"requires_python,omitempty"`
+	RequiresDist    []string `json:"requires_dist,omitempty"`
+}
+
+// ParseMetadata parses a METADATA or PKG-INFO file.
+func ParseMetadata(r io.Reader) (*Metadata, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(io.LimitReader(r, maxMetadataSize)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse metadata")
+	}
+
+	return &Metadata{
+		MetadataVersion: msg.Header.Get("Metadata-Version"),
+		Name:            msg.Header.Get("Name"),
+		Version:         msg.Header.Get("Version"),
+		Summary:         msg.Header.Get("Summary"),
+		RequiresPython:  msg.Header.Get("Requires-Python"),
+		RequiresDist:    msg.Header["Requires-Dist"],
+	}, nil
+}
+
+// ReadDistributionMetadata extracts the metadata from a wheel, egg or sdist archive.
+func ReadDistributionMetadata(dist *utils.DistributionFile, fileName string, r io.ReaderAt, size int64) (*Metadata, error) {
+	lower := strings.ToLower(fileName)
+	switch {
+	case dist.Type == utils.DistributionWheel:
+		return readZipMetadata(r, size, func(name string) bool {
+			dir, file := path.Split(name)
+			return file == "METADATA" && strings.HasSuffix(dir, ".dist-info/") && strings.Count(dir, "/") == 1
+		})
+	case dist.Type == utils.DistributionEgg:
+		return readZipMetadata(r, size, func(name string) bool { return name == "EGG-INFO/PKG-INFO" })
+	case strings.HasSuffix(lower, ".zip"):
+		return readZipMetadata(r, size, isSdistPKGInfo)
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read sdist")
+		}
+		defer gz.Close()
+		return readTarMetadata(gz)
+	case strings.HasSuffix(lower, ".tar.bz2"):
+		return readTarMetadata(bzip2.NewReader(io.NewSectionReader(r, 0, size)))
+	case strings.HasSuffix(lower, ".tar"):
+		return readTarMetadata(io.NewSectionReader(r, 0, size))
+	default:
+		return nil, errors.Wrapf(ErrNoMetadata, "unsupported archive %q", fileName)
+	}
+}
+
+// isSdistPKGInfo matches {name}-{version}/PKG-INFO, and not the PKG-INFO files of egg-info directories.
+func isSdistPKGInfo(name string) bool {
+	dir, file := path.Split(name)
+	return file == "PKG-INFO" && strings.Count(dir, "/") == 1
+}
+
+func readZipMetadata(r io.ReaderAt, size int64, match func(string) bool) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read zip archive")
+	}
+	for _, f := range zr.File {
+		if !match(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open metadata")
+		}
+		defer rc.Close()
+		return ParseMetadata(rc)
+	}
+	return nil, ErrNoMetadata
+}
+
+func readTarMetadata(r io.Reader) (*Metadata, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoMetadata
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tar archive")
+		}
+		if hdr.Typeflag == tar.TypeReg && isSdistPKGInfo(strings.TrimPrefix(hdr.Name, "./")) {
+			return ParseMetadata(tr)
+		}
+	}
+}
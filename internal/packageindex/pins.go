@@ -0,0 +1,159 @@
+package packageindex
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+var (
+	ErrInvalidPins = errors.New("invalid pins")
+	// ErrPinNotFound is returned for pins without any matching file in the index.
+	ErrPinNotFound = errors.New("pinned release not found")
+)
+
+// HashPinRequest lists pinned releases, like foo==1.0, to write hashes for.
+type HashPinRequest struct {
+	Pins []string `json:"pins"`
+	// Platforms restricts the files to wheels built for one of these platform tags, or pure Python
+	// wheels. All files are used if empty.
+	Platforms []string `json:"platforms,omitempty"`
+	// PythonVersion restricts the files to those that support this Python version.
+	PythonVersion string `json:"python_version,omitempty"`
+}
+
+// HashPin is a pinned release, with the files that can be installed for it.
+type HashPin struct {
+	// Requirement is the pin as requested, with its extras and marker.
+	Requirement *utils.Requirement
+	Files       []*FileRecord
+}
+
+// RequirementsLine formats the pin for a requirements file, with one --hash option per file.
+func (p *HashPin) RequirementsLine() string {
+	var b strings.Builder
+	b.WriteString(p.Requirement.String())
+	for _, f := range p.Files {
+		b.WriteString(" \\\n    --hash=sha256:" + f.SHA256)
+	}
+	return b.String()
+}
+
+func (i *index) HashPins(ctx context.Context, req *HashPinRequest) ([]*HashPin, error) {
+	var python *utils.Version
+	if req.PythonVersion != "" {
+		v, err := utils.ParseVersion(req.PythonVersion)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidPins, "invalid python_version %q", req.PythonVersion)
+		}
+		python = v
+	}
+	match, err := newPinMatcher(python, req.Platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	requirements := make([]*utils.Requirement, 0, len(req.Pins))
+	for _, pin := range req.Pins {
+		r, err := utils.ParseRequirement(pin)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidPins, err.Error())
+		}
+		if len(r.Specifiers) != 1 || r.Specifiers[0].Operator != "==" || r.Specifiers[0].Wildcard {
+			return nil, errors.Wrapf(ErrInvalidPins, "%s isn't pinned to one version with ==", pin)
+		}
+		if ValidatePackageName(r.Name) != nil {
+			return nil, errors.Wrapf(ErrInvalidPins, "invalid project name in %s", pin)
+		}
+		requirements = append(requirements, r)
+	}
+
+	pins := make([]*HashPin, 0, len(requirements))
+	var missing []string
+	for _, r := range requirements {
+		records, err := i.loadRecords(ctx, utils.NormalizePackageName(r.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		pin := &HashPin{Requirement: r}
+		for _, record := range sortedRecords(records) {
+			if match.matches(record, r) {
+				pin.Files = append(pin.Files, record)
+			}
+		}
+		if len(pin.Files) == 0 {
+			missing = append(missing, r.String())
+			continue
+		}
+		pins = append(pins, pin)
+	}
+	if len(missing) > 0 {
+		return nil, errors.Wrap(ErrPinNotFound, fmt.Sprintf("no matching files for %s", strings.Join(missing, ", ")))
+	}
+	return pins, nil
+}
+
+// pinMatcher picks the files of pinned versions that can be installed on the platforms and
+// Python version of a request, if they are given.
+type pinMatcher struct {
+	python *utils.Version
+	// target is set if both the platforms and the Python version are given, compatible if only
+	// the platforms are.
+	target     *wheelTarget
+	compatible map[string]struct{}
+}
+
+func newPinMatcher(python *utils.Version, platforms []string) (*pinMatcher, error) {
+	m := &pinMatcher{python: python}
+	if len(platforms) == 0 {
+		return m, nil
+	}
+	var err error
+	if python != nil {
+		m.target, err = newWheelTarget("cp", python, platforms)
+	} else {
+		m.compatible, err = compatiblePlatforms(platforms)
+	}
+	return m, err
+}
+
+// matches reports whether a file is of the pinned version, and can be installed on the target.
+func (m *pinMatcher) matches(record *FileRecord, r *utils.Requirement) bool {
+	dist, err := utils.ParseDistributionFileName(record.FileName)
+	if err != nil {
+		return false
+	}
+	version, err := utils.ParseVersion(dist.Version)
+	if err != nil || !r.Specifiers.Contains(version, true) {
+		return false
+	}
+
+	if m.python != nil {
+		if record.Metadata.RequiresPython != "" {
+			set, err := utils.ParseSpecifierSet(record.Metadata.RequiresPython)
+			if err != nil || !set.Contains(m.python, true) {
+				return false
+			}
+		}
+		if dist.Type == utils.DistributionWheel && !wheelForPython(dist, m.python) {
+			return false
+		}
+	}
+
+	switch {
+	case m.target != nil:
+		if dist.Type != utils.DistributionWheel {
+			return false
+		}
+		_, ok := m.target.rank(dist)
+		return ok
+	case m.compatible != nil:
+		return dist.Type == utils.DistributionWheel && wheelForPlatforms(dist, m.compatible)
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package packageindex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func TestIndexHashPins(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "foo-1.0.tar.gz", "")
+	uploadFile(ctx, t, index, "foo-1.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "foo-2.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "bar-1.0-cp312-cp312-manylinux_2_17_x86_64.whl", "")
+	uploadFile(ctx, t, index, "bar-1.0-cp312-cp312-macosx_11_0_arm64.whl", "")
+	uploadFile(ctx, t, index, "bar-1.0-cp311-cp311-manylinux_2_17_x86_64.whl", "")
+	uploadFile(ctx, t, index, "baz-1.0.tar.gz", ">=3.13")
+
+	fileNames := func(pins []*HashPin) [][]string {
+		names := [][]string{}
+		for _, p := range pins {
+			files := []string{}
+			for _, f := range p.Files {
+				files = append(files, f.FileName)
+			}
+			names = append(names, files)
+		}
+		return names
+	}
+
+	pins, err := index.HashPins(ctx, &HashPinRequest{Pins: []string{"Foo[extra]==1.0.0", `bar==1.0; python_version >= "3"`}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"foo-1.0-py3-none-any.whl", "foo-1.0.tar.gz"},
+		{"bar-1.0-cp311-cp311-manylinux_2_17_x86_64.whl", "bar-1.0-cp312-cp312-macosx_11_0_arm64.whl", "bar-1.0-cp312-cp312-manylinux_2_17_x86_64.whl"},
+	}, fileNames(pins))
+	assert.Equal(t, "Foo[extra]==1.0.0 \\\n    --hash=sha256:"+pins[0].Files[0].SHA256+" \\\n    --hash=sha256:"+pins[0].Files[1].SHA256, pins[0].RequirementsLine())
+
+	pins, err = index.HashPins(ctx, &HashPinRequest{
+		Pins:          []string{"foo==1.0", "bar==1.0"},
+		Platforms:     []string{"manylinux_2_17_x86_64"},
+		PythonVersion: "3.12",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"foo-1.0-py3-none-any.whl"},
+		{"bar-1.0-cp312-cp312-manylinux_2_17_x86_64.whl"},
+	}, fileNames(pins))
+
+	_, err = index.HashPins(ctx, &HashPinRequest{Pins: []string{"foo==3.0", "baz==1.0"}, PythonVersion: "3.12"})
+	assert.ErrorIs(t, err, ErrPinNotFound)
+	assert.ErrorContains(t, err, "foo==3.0, baz==1.0")
+
+	for _, pin := range []string{"foo", "foo>=1.0", "foo==1.*", "foo @ https://example.com/foo.whl", "foo==1.0,==1.0"} {
+		_, err = index.HashPins(ctx, &HashPinRequest{Pins: []string{pin}})
+		assert.ErrorIs(t, err, ErrInvalidPins, pin)
+	}
+	_, err = index.HashPins(ctx, &HashPinRequest{Pins: []string{"foo==1.0"}, PythonVersion: "three"})
+	assert.ErrorIs(t, err, ErrInvalidPins)
+
+	// Targets that would expand into millions of tags are rejected.
+	_, err = index.HashPins(ctx, &HashPinRequest{Pins: []string{"foo==1.0"}, Platforms: []string{"manylinux_2_3000000_x86_64"}})
+	assert.ErrorIs(t, err, ErrInvalidWheelTarget)
+	_, err = index.HashPins(ctx, &HashPinRequest{Pins: []string{"foo==1.0"}, PythonVersion: "3.999999999", Platforms: []string{"any"}})
+	assert.ErrorIs(t, err, ErrInvalidWheelTarget)
+}
@@ -0,0 +1,160 @@
+package packageindex
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// ErrPolicyViolation is returned for uploads that the upload policy rejects.
+var ErrPolicyViolation = errors.New("upload policy violation")
+
+// PolicyViolation lists every rule of the upload policy that an upload breaks.
+type PolicyViolation struct {
+	FileName string
+	// Reasons describe the broken rules, and how to follow them.
+	Reasons []string
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("%s was rejected by the upload policy: %s", v.FileName, strings.Join(v.Reasons, "; "))
+}
+
+func (v *PolicyViolation) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+func (i *index) uploadPolicyRule(packageName string) config.UploadPolicyRule {
+	if rule, ok := i.policy.Projects[packageName]; ok {
+		return rule
+	}
+	return i.policy.Default
+}
+
+// checkUploadPolicy checks an upload of a normalized package against its policy rule, before its
+// content is stored.
+func (i *index) checkUploadPolicy(ctx context.Context, packageName string, req *UploadFileRequest) error {
+	records := &projectRecords{Files: map[string]*FileRecord{}}
+	if i.uploadPolicyRule(packageName).RequireSdist {
+		i.mu.Lock()
+		var err error
+		records, err = i.loadRecords(ctx, packageName)
+		i.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return i.uploadPolicyViolation(packageName, req, records)
+}
+
+// uploadPolicyViolation checks an upload of a normalized package against its policy rule and the
+// records of the package. putRecord checks it again under i.mu, so that an sdist deleted while the
+// wheel is stored can't let the wheel through.
+func (i *index) uploadPolicyViolation(packageName string, req *UploadFileRequest, records *projectRecords) error {
+	rule := i.uploadPolicyRule(packageName)
+	var reasons []string
+
+	// The version in the file name is the one installers see, the form field is only a fallback.
+	rawVersion := req.Version
+	dist, err := utils.ParseDistributionFileName(req.FileName)
+	if err == nil {
+		rawVersion = dist.Version
+	} else {
+		dist = nil
+	}
+	version, err := utils.ParseVersion(rawVersion)
+	if err != nil {
+		version = nil
+		// Rules on versions can't tell whether an invalid version follows them, so it never does.
+		if rule.ForbidLocalVersions || rule.ForbidPreReleases || (rule.RequireSdist && dist != nil && dist.Type == utils.DistributionWheel) {
+			reasons = append(reasons, fmt.Sprintf("%q isn't a valid version, use a PEP 440 version such as 1.0", rawVersion))
+		}
+	}
+	if version != nil && rule.ForbidLocalVersions && version.Local != nil {
+		reasons = append(reasons, fmt.Sprintf("local versions such as %s can't be uploaded, upload %s instead", version, version.Public()))
+	}
+	if version != nil && rule.ForbidPreReleases && version.IsPreRelease() {
+		reasons = append(reasons, fmt.Sprintf("pre-releases such as %s can't be uploaded to this index", version))
+	}
+	if rule.RequireRequiresPython && strings.TrimSpace(utils.Deref(req.RequiresPython)) == "" {
+		reasons = append(reasons, "Requires-Python metadata is required, set requires-python in pyproject.toml")
+	}
+
+	if dist != nil && dist.Type == utils.DistributionWheel {
+		reasons = append(reasons, platformReasons(dist, rule.ForbiddenPlatforms, rule.AllowedPlatforms)...)
+		if rule.RequireSdist && version != nil && !hasSdist(records, version) {
+			// twine uploads wheels before sdists, so the sdist needs an upload of its own.
+			reasons = append(reasons, fmt.Sprintf("wheels are only accepted once the sdist of %s %s is uploaded, upload it first with twine upload dist/*.tar.gz", req.PackageName, version))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &PolicyViolation{FileName: req.FileName, Reasons: reasons}
+	}
+	return nil
+}
+
+// platformReasons describes the platform tags of a wheel that match forbidden patterns, or that
+// match none of the allowed patterns if there are any. Pure Python wheels are always allowed.
+func platformReasons(dist *utils.DistributionFile, forbidden, allowed []string) []string {
+	if len(forbidden) == 0 && len(allowed) == 0 {
+		return nil
+	}
+	tags, err := dist.Tags()
+	if err != nil {
+		return []string{fmt.Sprintf("the wheel tags %s-%s-%s can't be parsed", dist.PythonTag, dist.ABITag, dist.PlatformTag)}
+	}
+
+	var reasons []string
+	seen := map[string]struct{}{}
+	for _, tag := range tags {
+		if _, ok := seen[tag.Platform]; ok {
+			continue
+		}
+		seen[tag.Platform] = struct{}{}
+
+		var reason string
+		switch {
+		case matchesPlatform(forbidden, tag.Platform):
+			reason = fmt.Sprintf("wheels for the %s platform can't be uploaded", tag.Platform)
+		case len(allowed) > 0 && tag.Platform != "any" && !matchesPlatform(allowed, tag.Platform):
+			reason = fmt.Sprintf("wheels for the %s platform can't be uploaded, only %s are accepted", tag.Platform, strings.Join(allowed, ", "))
+		default:
+			continue
+		}
+		if strings.HasPrefix(tag.Platform, "linux_") {
+			reason += ", repair them into manylinux or musllinux wheels with auditwheel"
+		}
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+func matchesPlatform(patterns []string, platform string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), platform); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSdist reports whether the records have an sdist of a version.
+func hasSdist(records *projectRecords, version *utils.Version) bool {
+	for name := range records.Files {
+		dist, err := utils.ParseDistributionFileName(name)
+		if err != nil || dist.Type != utils.DistributionSdist {
+			continue
+		}
+		if v, err := utils.ParseVersion(dist.Version); err == nil && v.Compare(version) == 0 {
+			return true
+		}
+	}
+	return false
+}
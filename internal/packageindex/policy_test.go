@@ -0,0 +1,206 @@
+package packageindex
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func uploadForPolicy(ctx context.Context, index Index, fileName, requiresPython string) error {
+	version := strings.SplitN(fileName, "-", 3)[1]
+	req := &UploadFileRequest{
+		PackageName: "foo",
+		Version:     strings.TrimSuffix(version, ".tar.gz"),
+		FileName:    fileName,
+	}
+	if requiresPython != "" {
+		req.RequiresPython = &requiresPython
+	}
+	return index.UploadFile(ctx, req, strings.NewReader(fileName))
+}
+
+func TestIndexUploadFile_Policy(t *testing.T) {
+	tests := map[string]struct {
+		rule           config.UploadPolicyRule
+		fileName       string
+		requiresPython string
+		wantReasons    []string
+	}{
+		"no rules": {
+			fileName: "foo-1.0+local-cp312-cp312-linux_x86_64.whl",
+		},
+		"forbidden platform": {
+			rule:     config.UploadPolicyRule{ForbiddenPlatforms: []string{"linux_*"}},
+			fileName: "foo-1.0-cp312-cp312-linux_x86_64.whl",
+			wantReasons: []string{
+				"wheels for the linux_x86_64 platform can't be uploaded, repair them into manylinux or musllinux wheels with auditwheel",
+			},
+		},
+		"allowed platform": {
+			rule:     config.UploadPolicyRule{ForbiddenPlatforms: []string{"linux_*"}},
+			fileName: "foo-1.0-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl",
+		},
+		"platform outside the allowed ones": {
+			rule:     config.UploadPolicyRule{AllowedPlatforms: []string{"manylinux_*", "musllinux_*"}},
+			fileName: "foo-1.0-cp312-cp312-manylinux_2_17_x86_64.linux_x86_64.whl",
+			wantReasons: []string{
+				"wheels for the linux_x86_64 platform can't be uploaded, only manylinux_*, musllinux_* are accepted, repair them into manylinux or musllinux wheels with auditwheel",
+			},
+		},
+		"allowed platforms": {
+			rule:     config.UploadPolicyRule{AllowedPlatforms: []string{"manylinux_*", "musllinux_*"}},
+			fileName: "foo-1.0-cp312-cp312-musllinux_1_2_x86_64.whl",
+		},
+		"pure python wheel with allowed platforms": {
+			rule:     config.UploadPolicyRule{AllowedPlatforms: []string{"manylinux_*"}},
+			fileName: "foo-1.0-py3-none-any.whl",
+		},
+		"compressed tag set": {
+			rule:     config.UploadPolicyRule{ForbiddenPlatforms: []string{"win32"}},
+			fileName: "foo-1.0-py3-none-win32.win_amd64.whl",
+			wantReasons: []string{
+				"wheels for the win32 platform can't be uploaded",
+			},
+		},
+		"local version": {
+			rule:     config.UploadPolicyRule{ForbidLocalVersions: true},
+			fileName: "foo-1.0+cu121.tar.gz",
+			wantReasons: []string{
+				"local versions such as 1.0+cu121 can't be uploaded, upload 1.0 instead",
+			},
+		},
+		"pre-release": {
+			rule:     config.UploadPolicyRule{ForbidPreReleases: true},
+			fileName: "foo-1.0.dev3.tar.gz",
+			wantReasons: []string{
+				"pre-releases such as 1.0.dev3 can't be uploaded to this index",
+			},
+		},
+		"invalid version": {
+			rule:     config.UploadPolicyRule{ForbidPreReleases: true},
+			fileName: "foo-latest.tar.gz",
+			wantReasons: []string{
+				`"latest" isn't a valid version, use a PEP 440 version such as 1.0`,
+			},
+		},
+		"invalid version without version rules": {
+			rule:           config.UploadPolicyRule{RequireRequiresPython: true},
+			fileName:       "foo-latest.tar.gz",
+			requiresPython: ">=3.9",
+		},
+		"post-release": {
+			rule:     config.UploadPolicyRule{ForbidPreReleases: true},
+			fileName: "foo-1.0.post1.tar.gz",
+		},
+		"missing requires-python": {
+			rule:     config.UploadPolicyRule{RequireRequiresPython: true},
+			fileName: "foo-1.0.tar.gz",
+			wantReasons: []string{
+				"Requires-Python metadata is required, set requires-python in pyproject.toml",
+			},
+		},
+		"requires-python": {
+			rule:           config.UploadPolicyRule{RequireRequiresPython: true},
+			fileName:       "foo-1.0.tar.gz",
+			requiresPython: ">=3.9",
+		},
+		"every violation": {
+			rule: config.UploadPolicyRule{
+				ForbiddenPlatforms:  []string{"linux_x86_64"},
+				ForbidLocalVersions: true,
+				ForbidPreReleases:   true,
+				RequireSdist:        true,
+			},
+			fileName: "foo-2.0rc1+local-py3-none-linux_x86_64.whl",
+			wantReasons: []string{
+				"local versions such as 2.0rc1+local can't be uploaded, upload 2.0rc1 instead",
+				"pre-releases such as 2.0rc1+local can't be uploaded to this index",
+				"wheels for the linux_x86_64 platform can't be uploaded, repair them into manylinux or musllinux wheels with auditwheel",
+				"wheels are only accepted once the sdist of foo 2.0rc1+local is uploaded, upload it first with twine upload dist/*.tar.gz",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			index := NewIndex(storage.NewMemoryStorage(), WithUploadPolicy(&config.UploadPolicyConfig{Default: tt.rule}))
+
+			err := uploadForPolicy(ctx, index, tt.fileName, tt.requiresPython)
+			if tt.wantReasons == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrPolicyViolation)
+			var violation *PolicyViolation
+			require.ErrorAs(t, err, &violation)
+			assert.Equal(t, tt.fileName, violation.FileName)
+			assert.Equal(t, tt.wantReasons, violation.Reasons)
+
+			files, err := index.ListPackageFiles(ctx, "foo")
+			require.NoError(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
+
+func TestIndexUploadFile_PolicyRequireSdist(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage(), WithUploadPolicy(&config.UploadPolicyConfig{
+		Default:  config.UploadPolicyRule{RequireSdist: true},
+		Projects: map[string]config.UploadPolicyRule{"bar": {}},
+	}))
+
+	require.ErrorIs(t, uploadForPolicy(ctx, index, "foo-1.0-py3-none-any.whl", ""), ErrPolicyViolation)
+	require.NoError(t, uploadForPolicy(ctx, index, "foo-1.0.tar.gz", ""))
+	// The sdist version only has to be equal, not spelled the same.
+	require.NoError(t, uploadForPolicy(ctx, index, "foo-1.0.0-py3-none-any.whl", ""))
+	require.ErrorIs(t, uploadForPolicy(ctx, index, "foo-1.1-py3-none-any.whl", ""), ErrPolicyViolation)
+
+	// Projects can override the default rule.
+	require.NoError(t, index.UploadFile(ctx, &UploadFileRequest{
+		PackageName: "bar",
+		Version:     "1.0",
+		FileName:    "bar-1.0-py3-none-any.whl",
+	}, strings.NewReader("wheel")))
+}
+
+// sdistDeletingStorage deletes the sdist of foo 1.0 through the index while a wheel is stored.
+type sdistDeletingStorage struct {
+	storage.Storage
+
+	index Index
+}
+
+func (s *sdistDeletingStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	if strings.HasSuffix(filePath, ".whl") {
+		if err := s.index.DeleteFile(ctx, "foo", "foo-1.0.tar.gz"); err != nil {
+			return err
+		}
+	}
+	return s.Storage.WriteFile(ctx, filePath, content)
+}
+
+func TestIndexUploadFile_PolicyRequireSdistDeleted(t *testing.T) {
+	ctx := context.Background()
+	backend := &sdistDeletingStorage{Storage: storage.NewMemoryStorage()}
+	index := NewIndex(backend, WithUploadPolicy(&config.UploadPolicyConfig{
+		Default: config.UploadPolicyRule{RequireSdist: true},
+	}))
+	backend.index = index
+
+	require.NoError(t, uploadForPolicy(ctx, index, "foo-1.0.tar.gz", ""))
+	// The sdist is gone by the time the wheel is recorded, so the wheel is rejected after all.
+	require.ErrorIs(t, uploadForPolicy(ctx, index, "foo-1.0-py3-none-any.whl", ""), ErrPolicyViolation)
+
+	files, err := index.ListPackageFiles(ctx, "foo")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
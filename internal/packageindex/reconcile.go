@@ -0,0 +1,201 @@
+package packageindex
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// ReconcileReport lists the files whose records changed, as <package>/<file> paths.
+type ReconcileReport struct {
+	Ingested []string          `json:"ingested"`
+	Pruned   []string          `json:"pruned"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+func newReconcileReport() *ReconcileReport {
+	return &ReconcileReport{Ingested: []string{}, Pruned: []string{}}
+}
+
+func (r *ReconcileReport) fail(filePath string, err error) {
+	if r.Failed == nil {
+		r.Failed = map[string]string{}
+	}
+	r.Failed[filePath] = err.Error()
+}
+
+func (i *index) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	packages, err := i.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Packages that are gone from the storage still have records to prune.
+	recorded, err := i.recordedPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	report := newReconcileReport()
+	for _, pkg := range append(packages, recorded...) {
+		pkg = utils.NormalizePackageName(pkg)
+		if _, ok := seen[pkg]; ok {
+			continue
+		}
+		seen[pkg] = struct{}{}
+
+		if err := i.reconcilePackage(ctx, pkg, report); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			report.fail(pkg, err)
+		}
+	}
+	sort.Strings(report.Ingested)
+	sort.Strings(report.Pruned)
+	return report, nil
+}
+
+func (i *index) ReconcilePackage(ctx context.Context, packageName string) (*ReconcileReport, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+
+	report := newReconcileReport()
+	if err := i.reconcilePackage(ctx, utils.NormalizePackageName(packageName), report); err != nil {
+		return nil, err
+	}
+	sort.Strings(report.Ingested)
+	sort.Strings(report.Pruned)
+	return report, nil
+}
+
+// reconcilePackage registers the unrecorded files of a normalized package. Files are read without
+// holding the lock, so records written by uploads in the meantime take precedence, and records
+// newer than the listing are never pruned.
+func (i *index) reconcilePackage(ctx context.Context, packageName string, report *ReconcileReport) error {
+	start := time.Now()
+	files, err := i.ListPackageFiles(ctx, packageName)
+	if err != nil {
+		return err
+	}
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		return err
+	}
+
+	present := map[string]struct{}{}
+	ingested := map[string]*FileRecord{}
+	for _, file := range files {
+		present[file] = struct{}{}
+		if _, ok := records.Files[file]; ok {
+			continue
+		}
+
+		record, err := i.ingest(ctx, packageName, file)
+		if errors.Is(err, errNotDistribution) {
+			log.Ctx(ctx).Debug().Str("package", packageName).Str("file", file).Msg("Skipping file that is not a distribution")
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Ctx(ctx).Warn().Err(err).Str("package", packageName).Str("file", file).Msg("Failed to ingest file")
+			report.fail(path.Join(packageName, file), err)
+			continue
+		}
+		ingested[file] = record
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	records, err = i.loadRecords(ctx, packageName)
+	if err != nil {
+		return err
+	}
+	var added, removed []*FileRecord
+	for file, record := range ingested {
+		if _, ok := records.Files[file]; !ok {
+			records.Files[file] = record
+			added = append(added, record)
+			report.Ingested = append(report.Ingested, path.Join(packageName, file))
+		}
+	}
+	for file, record := range records.Files {
+		if _, ok := present[file]; !ok && record.UploadedAt.Before(start) {
+			delete(records.Files, file)
+			removed = append(removed, record)
+			report.Pruned = append(report.Pruned, path.Join(packageName, file))
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	for _, record := range added {
+		log.Ctx(ctx).Info().Str("package", packageName).Str("file", record.FileName).Msg("Registered file written to the storage directly")
+	}
+	if err := i.saveRecords(ctx, packageName, records); err != nil {
+		return err
+	}
+	i.updateUsage(ctx, packageName, removed, added)
+	return nil
+}
+
+var errNotDistribution = errors.New("not a distribution file")
+
+// ingest reads a stored file and builds its record.
+func (i *index) ingest(ctx context.Context, packageName, fileName string) (*FileRecord, error) {
+	dist, err := utils.ParseDistributionFileName(fileName)
+	if err != nil {
+		return nil, errors.Wrap(errNotDistribution, err.Error())
+	}
+
+	rc, err := i.strg.ReadFile(ctx, path.Join(packageName, fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// Zip archives need random access, so the file is copied to a temporary file while it is hashed.
+	tmp, err := os.CreateTemp("", "pypi-server-ingest-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Best effort cleanup.
+	defer tmp.Close()
+
+	hasher := newFileHasher()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), rc); err != nil {
+		return nil, errors.Wrap(err, "failed to read file")
+	}
+
+	record := &FileRecord{
+		FileName:   fileName,
+		Version:    dist.Version,
+		FileType:   string(dist.Type),
+		UploadedBy: i.ingestOwner,
+		UploadedAt: time.Now().UTC(),
+		Source:     SourceIngest,
+	}
+	hasher.record(record)
+
+	metadata, err := ReadDistributionMetadata(dist, fileName, tmp, hasher.size)
+	if err != nil {
+		// The file is still served, so it is registered with its digests only.
+		log.Ctx(ctx).Warn().Err(err).Str("package", packageName).Str("file", fileName).Msg("Failed to read distribution metadata")
+		metadata = &Metadata{Name: dist.Name, Version: dist.Version}
+	}
+	record.Metadata = *metadata
+	return record, nil
+}
@@ -0,0 +1,142 @@
+package packageindex
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+const testMetadata = "Metadata-Version: 2.1\r\nName: foo-bar\r\nVersion: 1.0\r\nSummary: A test package\r\n" +
+	"Requires-Python: >=3.8\r\nRequires-Dist: requests>=2\r\nRequires-Dist: click; extra == \"cli\"\r\n\r\nLong description.\r\n"
+
+func buildWheel(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"foo_bar/__init__.py":                 "",
+		"foo_bar-1.0.dist-info/METADATA":      testMetadata,
+		"foo_bar-1.0.dist-info/WHEEL":         "Wheel-Version: 1.0\n",
+		"foo_bar-1.0.dist-info/top_level.txt": "foo_bar\n",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func buildSdist(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string]string{
+		"foo_bar-1.0/foo_bar.egg-info/PKG-INFO": "Metadata-Version: 2.1\nName: wrong\n",
+		"foo_bar-1.0/PKG-INFO":                  testMetadata,
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestIndexReconcile(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	index := NewIndex(strg, WithIngestOwner("ci"))
+
+	wheel := buildWheel(t)
+	require.NoError(t, strg.WriteFile(ctx, "foo-bar/foo_bar-1.0-py3-none-any.whl", bytes.NewReader(wheel)))
+	require.NoError(t, strg.WriteFile(ctx, "foo-bar/foo_bar-1.0.tar.gz", bytes.NewReader(buildSdist(t))))
+	require.NoError(t, strg.WriteFile(ctx, "foo-bar/README.txt", bytes.NewBufferString("not a distribution")))
+	require.NoError(t, strg.WriteFile(ctx, "baz/baz-1.0.tar.gz", bytes.NewBufferString("not an archive")))
+
+	report, err := index.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz/baz-1.0.tar.gz", "foo-bar/foo_bar-1.0-py3-none-any.whl", "foo-bar/foo_bar-1.0.tar.gz"}, report.Ingested)
+	assert.Empty(t, report.Pruned)
+	assert.Empty(t, report.Failed)
+
+	records, err := index.ListFileRecords(ctx, "Foo.Bar")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	wheelRecord := records[0]
+	assert.Equal(t, "foo_bar-1.0-py3-none-any.whl", wheelRecord.FileName)
+	assert.Equal(t, "1.0", wheelRecord.Version)
+	assert.Equal(t, "bdist_wheel", wheelRecord.FileType)
+	assert.Equal(t, int64(len(wheel)), wheelRecord.Size)
+	assert.Equal(t, sha256Hex(wheel), wheelRecord.SHA256)
+	assert.Equal(t, "ci", wheelRecord.UploadedBy)
+	assert.Equal(t, SourceIngest, wheelRecord.Source)
+	want := Metadata{
+		MetadataVersion: "2.1",
+		Name:            "foo-bar",
+		Version:         "1.0",
+		Summary:         "A test package",
+		RequiresPython:  ">=3.8",
+		RequiresDist:    []string{"requests>=2", `click; extra == "cli"`},
+	}
+	assert.Equal(t, want, wheelRecord.Metadata)
+	assert.Equal(t, want, records[1].Metadata)
+
+	// Files without readable metadata are registered with their digests.
+	records, err = index.ListFileRecords(ctx, "baz")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, Metadata{Name: "baz", Version: "1.0"}, records[0].Metadata)
+	assert.Equal(t, sha256Hex([]byte("not an archive")), records[0].SHA256)
+
+	// Nothing changes on the next run.
+	report, err = index.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Ingested)
+	assert.Empty(t, report.Pruned)
+
+	// Records of deleted files are pruned, along with the record file of an empty package.
+	require.NoError(t, strg.DeleteFile(ctx, "baz/baz-1.0.tar.gz"))
+	report, err = index.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz/baz-1.0.tar.gz"}, report.Pruned)
+
+	recorded, err := strg.ListPackageFiles(ctx, recordsDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo-bar.json"}, recorded)
+}
+
+func TestIndexReconcilePackage_KeepsUploadedRecords(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	index := NewIndex(strg)
+
+	uploadTestFile(t, index, "alice")
+
+	report, err := index.ReconcilePackage(ctx, "foo-bar")
+	require.NoError(t, err)
+	assert.Empty(t, report.Ingested)
+
+	records, err := index.ListFileRecords(ctx, "foo-bar")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, SourceUpload, records[0].Source)
+	assert.Equal(t, "alice", records[0].UploadedBy)
+
+	_, err = index.ReconcilePackage(ctx, "../foo")
+	require.ErrorIs(t, err, ErrInvalidPackageName)
+}
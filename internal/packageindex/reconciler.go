@@ -0,0 +1,132 @@
+package packageindex
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+// Reconciler runs Reconcile in the background: on startup, on a schedule, and for packages
+// that the storage reports as changed.
+type Reconciler struct {
+	index Index
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewReconciler(index Index, strg storage.Storage, cfg *config.IngestConfig) *Reconciler {
+	ctx, stop := context.WithCancel(context.Background())
+	r := &Reconciler{
+		index: index,
+		stop:  stop,
+		done:  make(chan struct{}),
+	}
+
+	var changes chan string
+	if w, ok := strg.(storage.Watcher); ok && cfg.Watch {
+		changes = make(chan string, 64)
+		go func() {
+			err := w.Watch(ctx, func(packageName string) {
+				select {
+				case changes <- packageName:
+				case <-ctx.Done():
+				}
+			})
+			if errors.Is(err, storage.ErrWatchUnsupported) {
+				log.Info().Msg("Storage can't be watched, out of band files are registered on schedule only")
+			} else if err != nil {
+				log.Error().Err(err).Msg("Stopped watching storage")
+			}
+		}()
+	}
+
+	go r.loop(ctx, cfg, changes)
+	return r
+}
+
+func (r *Reconciler) Close() {
+	r.stop()
+	<-r.done
+}
+
+func (r *Reconciler) loop(ctx context.Context, cfg *config.IngestConfig, changes <-chan string) {
+	defer close(r.done)
+
+	if cfg.OnStartup {
+		r.reconcile(ctx)
+	}
+
+	var tick <-chan time.Time
+	if cfg.IntervalSeconds > 0 {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// Files being copied into the storage change for a while, so packages are only reconciled
+	// once they have been quiet for the debounce period.
+	debounce := time.Duration(cfg.WatchDebounceMilliseconds) * time.Millisecond
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	pending := map[string]struct{}{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-tick:
+			r.reconcile(ctx)
+		case packageName := <-changes:
+			pending[packageName] = struct{}{}
+			timer.Reset(debounce)
+		case <-timer.C:
+			for packageName := range pending {
+				r.reconcilePackage(ctx, packageName)
+			}
+			clear(pending)
+		}
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) {
+	report, err := r.index.Reconcile(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to reconcile index with storage")
+		}
+		return
+	}
+	logReport(report)
+}
+
+func (r *Reconciler) reconcilePackage(ctx context.Context, packageName string) {
+	if ValidatePackageName(packageName) != nil {
+		return
+	}
+	report, err := r.index.ReconcilePackage(ctx, packageName)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Str("package", packageName).Msg("Failed to reconcile package with storage")
+		}
+		return
+	}
+	logReport(report)
+}
+
+func logReport(report *ReconcileReport) {
+	if len(report.Ingested) == 0 && len(report.Pruned) == 0 && len(report.Failed) == 0 {
+		return
+	}
+	log.Info().
+		Int("ingested", len(report.Ingested)).
+		Int("pruned", len(report.Pruned)).
+		Int("failed", len(report.Failed)).
+		Msg("Reconciled index with storage")
+}
@@ -0,0 +1,52 @@
+package packageindex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func TestReconciler_LocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "foo-bar"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo-bar", "foo_bar-1.0.tar.gz"), buildSdist(t), 0600))
+
+	strg, err := storage.NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+	index := NewIndex(strg)
+
+	reconciler := NewReconciler(index, strg, &config.IngestConfig{OnStartup: true, Watch: true, WatchDebounceMilliseconds: 50})
+	defer reconciler.Close()
+
+	recorded := func(names ...string) func() bool {
+		return func() bool {
+			records, err := index.ListFileRecords(context.Background(), "foo-bar")
+			if err != nil || len(records) != len(names) {
+				return false
+			}
+			for i, r := range records {
+				if r.FileName != names[i] {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	// Existing files are registered on startup.
+	assert.Eventually(t, recorded("foo_bar-1.0.tar.gz"), 5*time.Second, 20*time.Millisecond)
+
+	// New files are registered as soon as they are written.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo-bar", "foo_bar-1.0-py3-none-any.whl"), buildWheel(t), 0600))
+	assert.Eventually(t, recorded("foo_bar-1.0-py3-none-any.whl", "foo_bar-1.0.tar.gz"), 5*time.Second, 20*time.Millisecond)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "foo-bar", "foo_bar-1.0.tar.gz")))
+	assert.Eventually(t, recorded("foo_bar-1.0-py3-none-any.whl"), 5*time.Second, 20*time.Millisecond)
+}
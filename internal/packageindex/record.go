@@ -0,0 +1,230 @@
+package packageindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // MD5 is only reported to clients that still ask for it.
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+type RecordSource string
+
+const (
+	// SourceUpload marks files uploaded through the index.
+	SourceUpload RecordSource = "upload"
+	// SourceIngest marks files that were written to the storage directly and registered afterwards.
+	SourceIngest RecordSource = "ingest"
+)
+
+// recordsDir holds one JSON file per project with the records of its files. It is dot-prefixed,
+// so that it is never listed as a package, and it travels with the files when storage is migrated.
+const recordsDir = ".index"
+
+// FileRecord is what the index knows about a stored distribution file.
+type FileRecord struct {
+	FileName string `json:"filename"`
+	Version  string `json:"version,omitempty"`
+	FileType string `json:"filetype,omitempty"`
+
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	MD5        string `json:"md5"`
+	Blake2b256 string `json:"blake2b_256"`
+
+	Metadata Metadata `json:"metadata"`
+
+	// Yanked is the reason the file was yanked (PEP 592), which may be empty. It is nil for files
+	// that aren't yanked.
+	Yanked *string `json:"yanked,omitempty"`
+
+	UploadedBy string       `json:"uploaded_by,omitempty"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+	Source     RecordSource `json:"source"`
+}
+
+type projectRecords struct {
+	Files map[string]*FileRecord `json:"files"`
+}
+
+func recordsPath(packageName string) string {
+	return path.Join(recordsDir, packageName+".json")
+}
+
+// loadRecords reads the records of a normalized project. A project without records has an empty set.
+func (i *index) loadRecords(ctx context.Context, packageName string) (*projectRecords, error) {
+	records := &projectRecords{Files: map[string]*FileRecord{}}
+
+	rc, err := i.strg.ReadFile(ctx, recordsPath(packageName))
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read file records")
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(records); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode file records of %s", packageName)
+	}
+	if records.Files == nil {
+		records.Files = map[string]*FileRecord{}
+	}
+	return records, nil
+}
+
+// saveRecords writes the records of a normalized project, or deletes them once no file is left.
+// Callers must hold i.mu, since records are read, modified and written back as a whole. The lock
+// is local to the process, so only a single server may update the records of a storage.
+func (i *index) saveRecords(ctx context.Context, packageName string, records *projectRecords) error {
+	if len(records.Files) == 0 {
+		err := i.strg.DeleteFile(ctx, recordsPath(packageName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrap(err, "failed to delete file records")
+		}
+		i.graph.update(packageName, records)
+		return nil
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := i.strg.WriteFile(ctx, recordsPath(packageName), bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "failed to write file records")
+	}
+	i.graph.update(packageName, records)
+	return nil
+}
+
+// putRecord adds or replaces the record of a file, unless check rejects the current records.
+func (i *index) putRecord(ctx context.Context, packageName string, record *FileRecord, check func(*projectRecords) error) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		return err
+	}
+	if err := check(records); err != nil {
+		return err
+	}
+	var replaced []*FileRecord
+	if old, ok := records.Files[record.FileName]; ok {
+		replaced = append(replaced, old)
+	}
+	records.Files[record.FileName] = record
+	if err := i.saveRecords(ctx, packageName, records); err != nil {
+		return err
+	}
+
+	i.updateUsage(ctx, packageName, replaced, []*FileRecord{record})
+	return nil
+}
+
+// removeRecord drops the record of a file, and reports whether there was one.
+func (i *index) removeRecord(ctx context.Context, packageName, fileName string) (bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		return false, err
+	}
+	old, ok := records.Files[fileName]
+	if !ok {
+		return false, nil
+	}
+	delete(records.Files, fileName)
+	if err := i.saveRecords(ctx, packageName, records); err != nil {
+		return false, err
+	}
+
+	i.updateUsage(ctx, packageName, []*FileRecord{old}, nil)
+	return true, nil
+}
+
+// setYanked yanks a file with the given reason, or un-yanks it if the reason is nil.
+func (i *index) setYanked(ctx context.Context, packageName, fileName string, reason *string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		return err
+	}
+	record, ok := records.Files[fileName]
+	if !ok {
+		return errors.Wrapf(os.ErrNotExist, "no record of %s", fileName)
+	}
+	record.Yanked = reason
+	return i.saveRecords(ctx, packageName, records)
+}
+
+// recordedPackages lists the normalized projects that have records.
+func (i *index) recordedPackages(ctx context.Context) ([]string, error) {
+	files, err := i.strg.ListPackageFiles(ctx, recordsDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list file records")
+	}
+
+	packages := make([]string, 0, len(files))
+	for _, f := range files {
+		if name, ok := strings.CutSuffix(f, ".json"); ok && ValidatePackageName(name) == nil {
+			packages = append(packages, name)
+		}
+	}
+	return packages, nil
+}
+
+func sortedRecords(records *projectRecords) []*FileRecord {
+	sorted := make([]*FileRecord, 0, len(records.Files))
+	for _, r := range records.Files {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].FileName < sorted[b].FileName })
+	return sorted
+}
+
+// fileHasher computes every digest the index records while a file is streamed through it.
+type fileHasher struct {
+	size       int64
+	sha256     hash.Hash
+	md5        hash.Hash
+	blake2b256 hash.Hash
+}
+
+func newFileHasher() *fileHasher {
+	b, _ := blake2b.New256(nil) // Only fails for keys longer than 64 bytes.
+	return &fileHasher{
+		sha256:     sha256.New(),
+		md5:        md5.New(), //nolint:gosec // See the import.
+		blake2b256: b,
+	}
+}
+
+func (h *fileHasher) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	_, _ = h.sha256.Write(p)
+	_, _ = h.md5.Write(p)
+	_, _ = h.blake2b256.Write(p)
+	return len(p), nil
+}
+
+// record fills in the size and digests of a record.
+func (h *fileHasher) record(r *FileRecord) {
+	r.Size = h.size
+	r.SHA256 = hex.EncodeToString(h.sha256.Sum(nil))
+	r.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	r.Blake2b256 = hex.EncodeToString(h.blake2b256.Sum(nil))
+}
@@ -0,0 +1,400 @@
+package packageindex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+var (
+	ErrInvalidResolveRequest = errors.New("invalid resolve request")
+	// ErrResolutionTooComplex is returned when the resolver gives up backtracking.
+	ErrResolutionTooComplex = errors.New("resolution is too complex")
+)
+
+// maxResolveSteps bounds how many requirements the resolver looks at, including backtracking.
+const maxResolveSteps = 10000
+
+// ResolveRequest is a set of requirements to pin, and the environment to install them in.
+type ResolveRequest struct {
+	Requirements []string `json:"requirements"`
+	// PythonVersion is the target Python version, such as 3.12 or 3.12.1.
+	PythonVersion string `json:"python_version"`
+	// Platforms are the platform tags of the target, such as manylinux_2_28_x86_64, which also
+	// accept wheels for older glibc, musl or macOS versions. Pure Python wheels are always accepted.
+	Platforms []string `json:"platforms,omitempty"`
+	// Environment overrides marker variables, which default to CPython on x86-64 Linux.
+	Environment map[string]string `json:"environment,omitempty"`
+	// AllowSdist picks sdists for releases without a compatible wheel.
+	AllowSdist bool `json:"allow_sdist,omitempty"`
+}
+
+// ResolvedFile is the file picked for a project.
+type ResolvedFile struct {
+	Package  string
+	Version  string
+	FileName string
+	SHA256   string
+}
+
+type Resolution struct {
+	// Files are sorted by package.
+	Files []*ResolvedFile
+}
+
+// ResolutionConflict explains why no set of releases satisfies the requirements.
+type ResolutionConflict struct {
+	Package      string                 `json:"package"`
+	Requirements []*ConflictRequirement `json:"requirements"`
+	// Versions are the versions of the package that have a file for the target environment.
+	Versions []string `json:"versions"`
+	Message  string   `json:"message"`
+}
+
+type ConflictRequirement struct {
+	Requirement string `json:"requirement"`
+	// RequiredBy is the release with the requirement, or empty for the request itself.
+	RequiredBy string `json:"required_by,omitempty"`
+}
+
+func (c *ResolutionConflict) Error() string {
+	return c.Message
+}
+
+func (i *index) Resolve(ctx context.Context, req *ResolveRequest) (*Resolution, error) {
+	python, err := utils.ParseVersion(req.PythonVersion)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidResolveRequest, "invalid python_version %q", req.PythonVersion)
+	}
+	env := utils.DefaultMarkerEnvironment(req.PythonVersion)
+	for k, v := range req.Environment {
+		env[k] = v
+	}
+
+	var pending []*constraint
+	for _, r := range req.Requirements {
+		parsed, err := utils.ParseRequirement(r)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidResolveRequest, err.Error())
+		}
+		if parsed.Applies(env) {
+			pending = append(pending, &constraint{requirement: parsed})
+		}
+	}
+
+	wheels, err := newWheelTarget("cp", python, req.Platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &resolver{
+		index:      i,
+		ctx:        ctx,
+		env:        env,
+		python:     python,
+		wheels:     wheels,
+		allowSdist: req.AllowSdist,
+		projects:   map[string]*resolverProject{},
+	}
+	state, ok := r.resolve(&resolveState{pins: map[string]*pin{}, constraints: map[string][]*constraint{}}, pending)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !ok {
+		return nil, r.conflict
+	}
+
+	resolution := &Resolution{Files: []*ResolvedFile{}}
+	for pkg, p := range state.pins {
+		resolution.Files = append(resolution.Files, &ResolvedFile{
+			Package:  pkg,
+			Version:  p.candidate.version,
+			FileName: p.candidate.file.FileName,
+			SHA256:   p.candidate.file.SHA256,
+		})
+	}
+	sort.Slice(resolution.Files, func(a, b int) bool { return resolution.Files[a].Package < resolution.Files[b].Package })
+	return resolution, nil
+}
+
+// resolver is a backtracking resolver: it pins the newest version of each project that satisfies
+// the requirements so far, and tries older ones when a later requirement conflicts.
+type resolver struct {
+	index      *index
+	ctx        context.Context
+	env        utils.MarkerEnvironment
+	python     *utils.Version
+	wheels     *wheelTarget
+	allowSdist bool
+
+	projects map[string]*resolverProject
+	steps    int
+	err      error
+
+	// conflict is the conflict found with the most pins, which is the closest to a resolution.
+	conflict     *ResolutionConflict
+	conflictPins int
+}
+
+type resolverProject struct {
+	// candidates are the versions with a file for the target environment.
+	candidates []*resolverCandidate
+	// recorded reports whether the project has any file.
+	recorded bool
+}
+
+type resolverCandidate struct {
+	version      string
+	parsed       *utils.Version
+	file         *FileRecord
+	requirements []*utils.Requirement
+}
+
+type constraint struct {
+	requirement *utils.Requirement
+	requiredBy  string
+}
+
+type pin struct {
+	candidate *resolverCandidate
+	extras    []string
+}
+
+type resolveState struct {
+	pins        map[string]*pin
+	constraints map[string][]*constraint
+}
+
+func (s *resolveState) with(packageName string, p *pin, constraints []*constraint) *resolveState {
+	next := &resolveState{pins: make(map[string]*pin, len(s.pins)+1), constraints: make(map[string][]*constraint, len(s.constraints)+1)}
+	for k, v := range s.pins {
+		next.pins[k] = v
+	}
+	for k, v := range s.constraints {
+		next.constraints[k] = v
+	}
+	next.pins[packageName] = p
+	next.constraints[packageName] = constraints
+	return next
+}
+
+func (r *resolver) resolve(state *resolveState, pending []*constraint) (*resolveState, bool) {
+	if len(pending) == 0 {
+		return state, true
+	}
+	r.steps++
+	if r.steps > maxResolveSteps {
+		r.err = errors.Wrapf(ErrResolutionTooComplex, "gave up after %d steps", maxResolveSteps)
+		return nil, false
+	}
+
+	c, rest := pending[0], pending[1:]
+	packageName := utils.NormalizePackageName(c.requirement.Name)
+	constraints := append(append([]*constraint{}, state.constraints[packageName]...), c)
+	if c.requirement.URL != "" {
+		r.fail(state, packageName, constraints, nil, fmt.Sprintf("%s is required from a URL, which the index can't serve", packageName))
+		return nil, false
+	}
+
+	project, err := r.project(packageName)
+	if err != nil {
+		r.err = err
+		return nil, false
+	}
+
+	if p, ok := state.pins[packageName]; ok {
+		if !c.requirement.Specifiers.Contains(p.candidate.parsed, true) {
+			r.fail(state, packageName, constraints, project, "")
+			return nil, false
+		}
+		extras := addedExtras(p.extras, c.requirement.Extras)
+		if len(extras) == 0 {
+			return r.resolve(state.with(packageName, p, constraints), rest)
+		}
+		// Requirements of the new extras, that didn't apply before.
+		next := append([]*constraint{}, rest...)
+		for _, req := range p.candidate.requirements {
+			if req.Applies(r.env, extras...) && !req.Applies(r.env, p.extras...) {
+				next = append(next, &constraint{requirement: req, requiredBy: releaseKey(packageName, p.candidate.version)})
+			}
+		}
+		extended := &pin{candidate: p.candidate, extras: append(append([]string{}, p.extras...), extras...)}
+		return r.resolve(state.with(packageName, extended, constraints), next)
+	}
+
+	for _, candidate := range r.matching(project, constraints) {
+		p := &pin{candidate: candidate, extras: c.requirement.Extras}
+		next := append([]*constraint{}, rest...)
+		for _, req := range candidate.requirements {
+			if req.Applies(r.env, p.extras...) {
+				next = append(next, &constraint{requirement: req, requiredBy: releaseKey(packageName, candidate.version)})
+			}
+		}
+		if resolved, ok := r.resolve(state.with(packageName, p, constraints), next); ok || r.err != nil {
+			return resolved, ok
+		}
+	}
+	r.fail(state, packageName, constraints, project, "")
+	return nil, false
+}
+
+// matching returns the candidates that satisfy every constraint, newest first. Pre-releases are
+// only used if a constraint names one, or if no final release matches.
+func (r *resolver) matching(project *resolverProject, constraints []*constraint) []*resolverCandidate {
+	var set utils.SpecifierSet
+	for _, c := range constraints {
+		set = append(set, c.requirement.Specifiers...)
+	}
+	versions := make([]*utils.Version, 0, len(project.candidates))
+	for _, c := range project.candidates {
+		versions = append(versions, c.parsed)
+	}
+	allowed := map[*utils.Version]struct{}{}
+	for _, v := range set.Filter(versions) {
+		allowed[v] = struct{}{}
+	}
+
+	var matching []*resolverCandidate
+	for _, c := range project.candidates {
+		if _, ok := allowed[c.parsed]; ok {
+			matching = append(matching, c)
+		}
+	}
+	return matching
+}
+
+func addedExtras(have, want []string) []string {
+	var added []string
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if utils.NormalizePackageName(h) == utils.NormalizePackageName(w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, w)
+		}
+	}
+	return added
+}
+
+func (r *resolver) fail(state *resolveState, packageName string, constraints []*constraint, project *resolverProject, message string) {
+	if r.conflict != nil && len(state.pins) <= r.conflictPins {
+		return
+	}
+
+	conflict := &ResolutionConflict{Package: packageName, Requirements: []*ConflictRequirement{}, Versions: []string{}}
+	described := make([]string, 0, len(constraints))
+	for _, c := range constraints {
+		conflict.Requirements = append(conflict.Requirements, &ConflictRequirement{Requirement: c.requirement.String(), RequiredBy: c.requiredBy})
+		requiredBy := c.requiredBy
+		if requiredBy == "" {
+			requiredBy = "the request"
+		}
+		described = append(described, fmt.Sprintf("%s (required by %s)", c.requirement, requiredBy))
+	}
+	if project != nil {
+		for _, c := range project.candidates {
+			conflict.Versions = append(conflict.Versions, c.version)
+		}
+	}
+
+	switch {
+	case message != "":
+		conflict.Message = message
+	case !project.recorded:
+		conflict.Message = fmt.Sprintf("%s isn't in the index", packageName)
+	case len(project.candidates) == 0:
+		conflict.Message = fmt.Sprintf("no release of %s has a file for the target environment", packageName)
+	default:
+		conflict.Message = fmt.Sprintf("no version of %s satisfies %s", packageName, strings.Join(described, ", "))
+	}
+	r.conflict = conflict
+	r.conflictPins = len(state.pins)
+}
+
+// project reads the candidates of a project once per resolution.
+func (r *resolver) project(packageName string) (*resolverProject, error) {
+	if p, ok := r.projects[packageName]; ok {
+		return p, nil
+	}
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+	records, err := r.index.loadRecords(r.ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &resolverProject{recorded: len(records.Files) > 0}
+	best := map[string]*resolverCandidate{}
+	ranks := map[string]int{}
+	for _, record := range sortedRecords(records) {
+		// Yanked files are never picked, like installers do for requirements that aren't pinned.
+		if record.Yanked != nil {
+			continue
+		}
+		dist, err := utils.ParseDistributionFileName(record.FileName)
+		if err != nil {
+			continue
+		}
+		version, err := utils.ParseVersion(dist.Version)
+		if err != nil {
+			continue
+		}
+		rank, ok := r.fileRank(dist, record)
+		if !ok {
+			continue
+		}
+
+		key := version.String()
+		if _, ok := best[key]; ok && ranks[key] <= rank {
+			continue
+		}
+		best[key] = &resolverCandidate{version: dist.Version, parsed: version, file: record, requirements: fileRequirements(record)}
+		ranks[key] = rank
+	}
+	for _, c := range best {
+		p.candidates = append(p.candidates, c)
+	}
+	sort.Slice(p.candidates, func(a, b int) bool { return p.candidates[a].parsed.Compare(p.candidates[b].parsed) > 0 })
+
+	r.projects[packageName] = p
+	return p, nil
+}
+
+func fileRequirements(record *FileRecord) []*utils.Requirement {
+	var requirements []*utils.Requirement
+	for _, requiresDist := range record.Metadata.RequiresDist {
+		if req, err := utils.ParseRequirement(requiresDist); err == nil {
+			requirements = append(requirements, req)
+		}
+	}
+	return requirements
+}
+
+// fileRank reports whether a file can be installed in the target environment, and how much it
+// is preferred, lower first: wheels by their best tag, like pip, then sdists.
+func (r *resolver) fileRank(dist *utils.DistributionFile, record *FileRecord) (int, bool) {
+	if record.Metadata.RequiresPython != "" {
+		set, err := utils.ParseSpecifierSet(record.Metadata.RequiresPython)
+		if err != nil || !set.Contains(r.python, true) {
+			return 0, false
+		}
+	}
+
+	switch dist.Type {
+	case utils.DistributionSdist:
+		return r.wheels.worst(), r.allowSdist
+	case utils.DistributionWheel:
+		return r.wheels.rank(dist)
+	}
+	return 0, false
+}
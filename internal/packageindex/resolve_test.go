@@ -0,0 +1,209 @@
+package packageindex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func uploadFile(ctx context.Context, t *testing.T, index Index, fileName, requiresPython string, requiresDist ...string) {
+	t.Helper()
+
+	dist := strings.SplitN(fileName, "-", 3)
+	version := strings.TrimSuffix(dist[1], ".tar.gz")
+	req := &UploadFileRequest{
+		PackageName:  dist[0],
+		Version:      version,
+		FileName:     fileName,
+		RequiresDist: requiresDist,
+	}
+	if requiresPython != "" {
+		req.RequiresPython = &requiresPython
+	}
+	require.NoError(t, index.UploadFile(ctx, req, strings.NewReader(fileName)))
+}
+
+func resolvedFiles(resolution *Resolution) []string {
+	files := []string{}
+	for _, f := range resolution.Files {
+		files = append(files, f.FileName)
+	}
+	return files
+}
+
+func TestIndexResolve(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "app-1.0-py3-none-any.whl", "", "a", "b", `tomli; python_version < "3.11"`, `extra-dep; extra == "extra"`)
+	uploadFile(ctx, t, index, "a-1.0-py3-none-any.whl", "", "c")
+	uploadFile(ctx, t, index, "a-2.0-py3-none-any.whl", "", "c<2")
+	uploadFile(ctx, t, index, "a-3.0-py3-none-any.whl", ">=3.13")
+	uploadFile(ctx, t, index, "b-1.0-py3-none-any.whl", "", "c>=2")
+	uploadFile(ctx, t, index, "c-1.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "c-2.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "c-2.1a1-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "tomli-2.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "extra_dep-1.0-py3-none-any.whl", "")
+
+	// a 3.0 doesn't support Python 3.12, and a 2.0 conflicts with b, so a 1.0 is picked.
+	resolution, err := index.Resolve(ctx, &ResolveRequest{Requirements: []string{"app"}, PythonVersion: "3.12"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"a-1.0-py3-none-any.whl",
+		"app-1.0-py3-none-any.whl",
+		"b-1.0-py3-none-any.whl",
+		"c-2.0-py3-none-any.whl",
+	}, resolvedFiles(resolution))
+	assert.Equal(t, &ResolvedFile{Package: "a", Version: "1.0", FileName: "a-1.0-py3-none-any.whl", SHA256: resolution.Files[0].SHA256}, resolution.Files[0])
+	assert.Len(t, resolution.Files[0].SHA256, 64)
+
+	// Markers and extras add requirements.
+	resolution, err = index.Resolve(ctx, &ResolveRequest{Requirements: []string{"app", "app[extra]"}, PythonVersion: "3.10"})
+	require.NoError(t, err)
+	assert.Contains(t, resolvedFiles(resolution), "tomli-2.0-py3-none-any.whl")
+	assert.Contains(t, resolvedFiles(resolution), "extra_dep-1.0-py3-none-any.whl")
+
+	// Pre-releases are only picked when asked for.
+	resolution, err = index.Resolve(ctx, &ResolveRequest{Requirements: []string{"c>=2.1a1"}, PythonVersion: "3.12"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c-2.1a1-py3-none-any.whl"}, resolvedFiles(resolution))
+}
+
+func TestIndexResolve_Platforms(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "lib-1.0.tar.gz", "")
+	uploadFile(ctx, t, index, "lib-1.0-cp312-cp312-manylinux_2_17_x86_64.whl", "")
+	uploadFile(ctx, t, index, "lib-1.0-cp312-cp312-macosx_11_0_arm64.whl", "")
+	uploadFile(ctx, t, index, "lib-1.0-cp310-abi3-manylinux_2_17_x86_64.manylinux2014_x86_64.whl", "")
+
+	resolve := func(python string, allowSdist bool, platforms ...string) ([]string, error) {
+		resolution, err := index.Resolve(ctx, &ResolveRequest{
+			Requirements:  []string{"lib"},
+			PythonVersion: python,
+			Platforms:     platforms,
+			AllowSdist:    allowSdist,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resolvedFiles(resolution), nil
+	}
+
+	// Wheels are picked like pip does, and manylinux2014 is manylinux_2_17.
+	files, err := resolve("3.12", false, "manylinux2014_x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lib-1.0-cp312-cp312-manylinux_2_17_x86_64.whl"}, files)
+
+	files, err = resolve("3.13", false, "manylinux_2_28_x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lib-1.0-cp310-abi3-manylinux_2_17_x86_64.manylinux2014_x86_64.whl"}, files)
+
+	files, err = resolve("3.12", false, "macosx_14_0_arm64")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lib-1.0-cp312-cp312-macosx_11_0_arm64.whl"}, files)
+
+	files, err = resolve("3.9", true, "manylinux_2_17_x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lib-1.0.tar.gz"}, files)
+
+	_, err = resolve("3.9", false, "manylinux_2_17_x86_64")
+	var conflict *ResolutionConflict
+	require.True(t, errors.As(err, &conflict))
+	assert.Equal(t, "no release of lib has a file for the target environment", conflict.Message)
+
+	_, err = resolve("3.12", false, "manylinux_2_3000000_x86_64")
+	require.ErrorIs(t, err, ErrInvalidWheelTarget)
+	_, err = resolve("3.999999999", false)
+	require.ErrorIs(t, err, ErrInvalidWheelTarget)
+}
+
+func TestIndexResolve_Conflicts(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "app-1.0-py3-none-any.whl", "", "lib>=2")
+	uploadFile(ctx, t, index, "lib-1.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "lib-2.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "url-1.0-py3-none-any.whl", "", "dep @ https://example.com/dep.whl")
+
+	tests := []struct {
+		requirements []string
+		want         *ResolutionConflict
+	}{
+		{
+			requirements: []string{"app", "lib<2"},
+			want: &ResolutionConflict{
+				Package: "lib",
+				Requirements: []*ConflictRequirement{
+					{Requirement: "lib<2"},
+					{Requirement: "lib>=2", RequiredBy: "app==1.0"},
+				},
+				Versions: []string{"2.0", "1.0"},
+				Message:  "no version of lib satisfies lib<2 (required by the request), lib>=2 (required by app==1.0)",
+			},
+		},
+		{
+			requirements: []string{"missing"},
+			want: &ResolutionConflict{
+				Package:      "missing",
+				Requirements: []*ConflictRequirement{{Requirement: "missing"}},
+				Versions:     []string{},
+				Message:      "missing isn't in the index",
+			},
+		},
+		{
+			requirements: []string{"url"},
+			want: &ResolutionConflict{
+				Package:      "dep",
+				Requirements: []*ConflictRequirement{{Requirement: "dep @ https://example.com/dep.whl", RequiredBy: "url==1.0"}},
+				Versions:     []string{},
+				Message:      "dep is required from a URL, which the index can't serve",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.requirements, " "), func(t *testing.T) {
+			_, err := index.Resolve(ctx, &ResolveRequest{Requirements: tt.requirements, PythonVersion: "3.12"})
+			var conflict *ResolutionConflict
+			require.True(t, errors.As(err, &conflict), err)
+			assert.Equal(t, tt.want, conflict)
+		})
+	}
+
+	_, err := index.Resolve(ctx, &ResolveRequest{Requirements: []string{"app"}, PythonVersion: "three"})
+	assert.ErrorIs(t, err, ErrInvalidResolveRequest)
+	_, err = index.Resolve(ctx, &ResolveRequest{Requirements: []string{"app>="}, PythonVersion: "3.12"})
+	assert.ErrorIs(t, err, ErrInvalidResolveRequest)
+}
+
+func TestIndexResolve_Yanked(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "c-1.0-py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "c-2.0-py3-none-any.whl", "")
+	require.NoError(t, index.YankFile(ctx, "c", "c-2.0-py3-none-any.whl", utils.Pointer("broken")))
+
+	resolution, err := index.Resolve(ctx, &ResolveRequest{Requirements: []string{"c"}, PythonVersion: "3.12"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c-1.0-py3-none-any.whl"}, resolvedFiles(resolution))
+
+	require.NoError(t, index.YankFile(ctx, "c", "c-2.0-py3-none-any.whl", nil))
+	resolution, err = index.Resolve(ctx, &ResolveRequest{Requirements: []string{"c"}, PythonVersion: "3.12"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c-2.0-py3-none-any.whl"}, resolvedFiles(resolution))
+
+	require.ErrorIs(t, index.YankFile(ctx, "c", "c-3.0-py3-none-any.whl", nil), os.ErrNotExist)
+}
@@ -0,0 +1,217 @@
+package packageindex
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// RetentionReport lists the files deleted by retention rules, or that would be on a dry run.
+type RetentionReport struct {
+	DryRun  bool                 `json:"dry_run"`
+	Deleted []*RetentionDeletion `json:"deleted"`
+	Failed  map[string]string    `json:"failed,omitempty"`
+}
+
+// RetentionDeletion is a file deleted by a retention rule.
+type RetentionDeletion struct {
+	Package  string `json:"package"`
+	FileName string `json:"filename"`
+	Version  string `json:"version"`
+	Reason   string `json:"reason"`
+}
+
+func newRetentionReport(dryRun bool) *RetentionReport {
+	return &RetentionReport{DryRun: dryRun, Deleted: []*RetentionDeletion{}}
+}
+
+func (r *RetentionReport) fail(filePath string, err error) {
+	if r.Failed == nil {
+		r.Failed = map[string]string{}
+	}
+	r.Failed[filePath] = err.Error()
+}
+
+func (r *RetentionReport) sort() {
+	sort.Slice(r.Deleted, func(a, b int) bool {
+		if r.Deleted[a].Package != r.Deleted[b].Package {
+			return r.Deleted[a].Package < r.Deleted[b].Package
+		}
+		return r.Deleted[a].FileName < r.Deleted[b].FileName
+	})
+}
+
+func (i *index) ApplyRetention(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	packages, err := i.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	report := newRetentionReport(dryRun)
+	for _, pkg := range packages {
+		pkg = utils.NormalizePackageName(pkg)
+		if _, ok := seen[pkg]; ok {
+			continue
+		}
+		seen[pkg] = struct{}{}
+
+		if err := i.applyRetention(ctx, pkg, report); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			report.fail(pkg, err)
+		}
+	}
+	report.sort()
+	return report, nil
+}
+
+func (i *index) ApplyRetentionPackage(ctx context.Context, packageName string, dryRun bool) (*RetentionReport, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+
+	report := newRetentionReport(dryRun)
+	if err := i.applyRetention(ctx, utils.NormalizePackageName(packageName), report); err != nil {
+		return nil, err
+	}
+	report.sort()
+	return report, nil
+}
+
+func (i *index) retentionRule(packageName string) config.RetentionRule {
+	if rule, ok := i.retention.Projects[packageName]; ok {
+		return rule
+	}
+	return i.retention.Default
+}
+
+// applyRetention deletes the files of a normalized package that its rule doesn't keep.
+func (i *index) applyRetention(ctx context.Context, packageName string, report *RetentionReport) error {
+	rule := i.retentionRule(packageName)
+	if rule == (config.RetentionRule{}) {
+		return nil
+	}
+
+	files, err := i.ListPackageFiles(ctx, packageName)
+	if err != nil {
+		return err
+	}
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		return err
+	}
+
+	for _, expired := range planRetention(rule, groupReleases(files, records), time.Now()) {
+		for _, file := range expired.release.Files {
+			deletion := &RetentionDeletion{
+				Package:  packageName,
+				FileName: file,
+				Version:  expired.release.Version.String(),
+				Reason:   expired.reason,
+			}
+			l := log.Ctx(ctx).Info().
+				Str("package", packageName).
+				Str("file", file).
+				Str("version", deletion.Version).
+				Str("reason", deletion.Reason)
+
+			if report.DryRun {
+				l.Msg("Would delete file by retention rule")
+				report.Deleted = append(report.Deleted, deletion)
+				continue
+			}
+			if err := i.DeleteFile(ctx, packageName, file); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Ctx(ctx).Warn().Err(err).Str("package", packageName).Str("file", file).Msg("Failed to delete file by retention rule")
+				report.fail(path.Join(packageName, file), err)
+				continue
+			}
+			l.Msg("Deleted file by retention rule")
+			report.Deleted = append(report.Deleted, deletion)
+		}
+	}
+	return nil
+}
+
+// release is a version of a package with its files.
+type release struct {
+	*VersionGroup
+	// uploadedAt is when the newest file was uploaded, or zero if none has a record.
+	uploadedAt time.Time
+}
+
+// groupReleases groups files by version, newest version first. Files whose version can't be
+// parsed are left out, so retention never deletes them.
+func groupReleases(files []string, records *projectRecords) []*release {
+	groups, _ := GroupFilesByVersion(files)
+
+	releases := make([]*release, 0, len(groups))
+	for _, g := range groups {
+		r := &release{VersionGroup: g}
+		for _, file := range g.Files {
+			if record, ok := records.Files[file]; ok && record.UploadedAt.After(r.uploadedAt) {
+				r.uploadedAt = record.UploadedAt
+			}
+		}
+		releases = append(releases, r)
+	}
+	return releases
+}
+
+type expiredRelease struct {
+	release *release
+	reason  string
+}
+
+// planRetention picks the releases that the rule doesn't keep, from releases sorted newest first.
+//
+// Dev releases (1.0.dev3, 1.0a1.dev1) are kept by count, pre-releases (1.0rc1) by age once a newer final
+// release exists, and post-releases (1.0.post2) by count per release.
+func planRetention(rule config.RetentionRule, releases []*release, now time.Time) []expiredRelease {
+	var expired []expiredRelease
+
+	devReleases := 0
+	postReleases := map[string]int{}
+	finalSeen := false
+	for _, r := range releases {
+		v := r.Version
+		switch {
+		case v.DevRelease != nil:
+			devReleases++
+			if rule.KeepDevReleases > 0 && devReleases > rule.KeepDevReleases {
+				expired = append(expired, expiredRelease{r, "only the newest " + strconv.Itoa(rule.KeepDevReleases) + " dev releases are kept"})
+			}
+
+		case v.PreRelease != nil:
+			maxAge := time.Duration(rule.PreReleaseMaxAgeDays) * 24 * time.Hour
+			// Pre-releases without records have an unknown age, and are kept.
+			if rule.PreReleaseMaxAgeDays > 0 && finalSeen && !r.uploadedAt.IsZero() && now.Sub(r.uploadedAt) > maxAge {
+				expired = append(expired, expiredRelease{r, "pre-release older than " + strconv.Itoa(rule.PreReleaseMaxAgeDays) + " days with a newer final release"})
+			}
+
+		default:
+			finalSeen = true
+			if v.PostRelease == nil {
+				continue
+			}
+			base := *v
+			base.PostRelease, base.Local = nil, nil
+			postReleases[base.String()]++
+			if rule.KeepPostReleases > 0 && postReleases[base.String()] > rule.KeepPostReleases {
+				expired = append(expired, expiredRelease{r, "only the newest " + strconv.Itoa(rule.KeepPostReleases) + " post-releases of " + base.String() + " are kept"})
+			}
+		}
+	}
+	return expired
+}
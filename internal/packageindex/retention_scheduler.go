@@ -0,0 +1,72 @@
+package packageindex
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// RetentionScheduler applies the retention rules in the background.
+type RetentionScheduler struct {
+	index Index
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewRetentionScheduler(index Index, cfg *config.RetentionConfig) *RetentionScheduler {
+	ctx, stop := context.WithCancel(context.Background())
+	s := &RetentionScheduler{
+		index: index,
+		stop:  stop,
+		done:  make(chan struct{}),
+	}
+
+	go s.loop(ctx, time.Duration(cfg.IntervalSeconds)*time.Second)
+	return s
+}
+
+func (s *RetentionScheduler) Close() {
+	s.stop()
+	<-s.done
+}
+
+func (s *RetentionScheduler) loop(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+
+	if interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.apply(ctx)
+		}
+	}
+}
+
+func (s *RetentionScheduler) apply(ctx context.Context) {
+	report, err := s.index.ApplyRetention(ctx, false)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to apply retention rules")
+		}
+		return
+	}
+	if len(report.Deleted) == 0 && len(report.Failed) == 0 {
+		return
+	}
+	log.Info().
+		Int("deleted", len(report.Deleted)).
+		Int("failed", len(report.Failed)).
+		Msg("Applied retention rules")
+}
@@ -0,0 +1,198 @@
+package packageindex
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func TestPlanRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-40 * 24 * time.Hour)
+	recent := now.Add(-10 * 24 * time.Hour)
+
+	tests := map[string]struct {
+		rule    config.RetentionRule
+		files   map[string]time.Time
+		expired []string
+	}{
+		"keep dev releases": {
+			rule: config.RetentionRule{KeepDevReleases: 2},
+			files: map[string]time.Time{
+				"foo-1.0.dev1.tar.gz": recent,
+				"foo-1.0.dev2.tar.gz": recent,
+				"foo-1.0.dev3.tar.gz": recent,
+				"foo-1.1.dev1.tar.gz": recent,
+				"foo-0.9.tar.gz":      recent,
+			},
+			expired: []string{"1.0.dev2", "1.0.dev1"},
+		},
+		"old pre-releases with a final release": {
+			rule: config.RetentionRule{PreReleaseMaxAgeDays: 30},
+			files: map[string]time.Time{
+				"foo-1.0a1.tar.gz":  old,
+				"foo-1.0rc1.tar.gz": recent,
+				"foo-1.0.tar.gz":    recent,
+				// Only final releases newer than a pre-release count.
+				"foo-2.0b1.tar.gz": old,
+			},
+			expired: []string{"1.0a1"},
+		},
+		"pre-releases without records": {
+			rule: config.RetentionRule{PreReleaseMaxAgeDays: 30},
+			files: map[string]time.Time{
+				"foo-1.0a1.tar.gz": {},
+				"foo-1.0.tar.gz":   recent,
+			},
+		},
+		"keep post-releases per release": {
+			rule: config.RetentionRule{KeepPostReleases: 1},
+			files: map[string]time.Time{
+				"foo-1.0.tar.gz":       recent,
+				"foo-1.0.post1.tar.gz": recent,
+				"foo-1.0.post2.tar.gz": recent,
+				"foo-1.1.post1.tar.gz": recent,
+			},
+			expired: []string{"1.0.post1"},
+		},
+		"dev, pre- and final releases of one version": {
+			rule: config.RetentionRule{KeepDevReleases: 2, PreReleaseMaxAgeDays: 30},
+			files: map[string]time.Time{
+				"foo-1.0.dev1.tar.gz":   recent,
+				"foo-1.0.dev2.tar.gz":   recent,
+				"foo-1.0a1.dev1.tar.gz": recent,
+				"foo-1.0a1.tar.gz":      old,
+				"foo-1.0.tar.gz":        recent,
+			},
+			// 1.0.dev1 and 1.0.dev2 come before the pre-releases of 1.0.
+			expired: []string{"1.0a1", "1.0.dev1"},
+		},
+		"no rules": {
+			files: map[string]time.Time{
+				"foo-1.0.dev1.tar.gz": old,
+				"foo-1.0a1.tar.gz":    old,
+				"foo-1.0.tar.gz":      old,
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			records := &projectRecords{Files: map[string]*FileRecord{}}
+			var files []string
+			for file, uploadedAt := range tt.files {
+				files = append(files, file)
+				if !uploadedAt.IsZero() {
+					records.Files[file] = &FileRecord{FileName: file, UploadedAt: uploadedAt}
+				}
+			}
+
+			var expired []string
+			for _, e := range planRetention(tt.rule, groupReleases(files, records), now) {
+				expired = append(expired, e.release.Version.String())
+			}
+			assert.Equal(t, tt.expired, expired)
+		})
+	}
+}
+
+func TestGroupReleases(t *testing.T) {
+	releases := groupReleases([]string{
+		"foo-1.0.0-py3-none-any.whl",
+		"foo-1.0.tar.gz",
+		"foo-2.0.tar.gz",
+		"foo-latest.tar.gz",
+		"README.txt",
+	}, &projectRecords{Files: map[string]*FileRecord{}})
+
+	require.Len(t, releases, 2)
+	assert.Equal(t, "2.0", releases[0].Version.String())
+	assert.Equal(t, []string{"foo-1.0.0-py3-none-any.whl", "foo-1.0.tar.gz"}, releases[1].Files)
+}
+
+func TestIndexApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage(), WithRetention(&config.RetentionConfig{
+		Default:  config.RetentionRule{KeepDevReleases: 1},
+		Projects: map[string]config.RetentionRule{"bar": {}},
+	}))
+
+	for _, pkg := range []string{"foo", "bar"} {
+		for _, version := range []string{"1.0.dev1", "1.0.dev2"} {
+			require.NoError(t, index.UploadFile(ctx, &UploadFileRequest{
+				PackageName: pkg,
+				Version:     version,
+				FileName:    pkg + "-" + version + ".tar.gz",
+				FileType:    "sdist",
+			}, strings.NewReader("sdist")))
+		}
+	}
+
+	report, err := index.ApplyRetention(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	require.Len(t, report.Deleted, 1)
+	assert.Equal(t, &RetentionDeletion{
+		Package:  "foo",
+		FileName: "foo-1.0.dev1.tar.gz",
+		Version:  "1.0.dev1",
+		Reason:   "only the newest 1 dev releases are kept",
+	}, report.Deleted[0])
+
+	// A dry run deletes nothing.
+	files, err := index.ListPackageFiles(ctx, "foo")
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	report, err = index.ApplyRetentionPackage(ctx, "Foo", false)
+	require.NoError(t, err)
+	assert.False(t, report.DryRun)
+	require.Len(t, report.Deleted, 1)
+
+	files, err = index.ListPackageFiles(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo-1.0.dev2.tar.gz"}, files)
+
+	records, err := index.ListFileRecords(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "foo-1.0.dev2.tar.gz", records[0].FileName)
+
+	usage, err := index.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, usage.Projects["foo"].Files)
+
+	// Projects can opt out of the default rule.
+	files, err = index.ListPackageFiles(ctx, "bar")
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestRetentionScheduler(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.RetentionConfig{IntervalSeconds: 1, Default: config.RetentionRule{KeepDevReleases: 1}}
+	index := NewIndex(storage.NewMemoryStorage(), WithRetention(cfg))
+	for _, version := range []string{"1.0.dev1", "1.0.dev2"} {
+		require.NoError(t, index.UploadFile(ctx, &UploadFileRequest{
+			PackageName: "foo",
+			Version:     version,
+			FileName:    "foo-" + version + ".tar.gz",
+			FileType:    "sdist",
+		}, strings.NewReader("sdist")))
+	}
+
+	scheduler := NewRetentionScheduler(index, cfg)
+	defer scheduler.Close()
+
+	assert.Eventually(t, func() bool {
+		files, err := index.ListPackageFiles(ctx, "foo")
+		return err == nil && len(files) == 1 && files[0] == "foo-1.0.dev2.tar.gz"
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,282 @@
+package packageindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+var (
+	// ErrProjectQuotaExceeded is returned when an upload would take a project over its quota.
+	ErrProjectQuotaExceeded = errors.New("project quota exceeded")
+	// ErrUserQuotaExceeded is returned when an upload would take the uploader over their quota.
+	ErrUserQuotaExceeded = errors.New("user quota exceeded")
+)
+
+// usagePath holds the usage counters. It is kept apart from recordsDir, where any file name
+// belongs to a project. Like records, it is only updated under i.mu, so a single server may
+// write it.
+const usagePath = ".usage/usage.json"
+
+// UsageEntry is the storage used by a project or an uploader.
+type UsageEntry struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// Usage is the storage used per project and per uploader. Files without a known uploader are
+// only counted for their project. Uploaders are keyed in lower case, since quotas match usernames
+// case-insensitively.
+type Usage struct {
+	Projects map[string]*UsageEntry `json:"projects"`
+	Users    map[string]*UsageEntry `json:"users"`
+}
+
+func newUsage() *Usage {
+	return &Usage{Projects: map[string]*UsageEntry{}, Users: map[string]*UsageEntry{}}
+}
+
+// add counts a file once, or removes it with sign -1.
+func (u *Usage) add(packageName string, record *FileRecord, sign int) {
+	addEntry(u.Projects, packageName, record.Size, sign)
+	if record.UploadedBy != "" {
+		addEntry(u.Users, userKey(record.UploadedBy), record.Size, sign)
+	}
+}
+
+func userKey(username string) string {
+	return strings.ToLower(username)
+}
+
+func addEntry(entries map[string]*UsageEntry, name string, size int64, sign int) {
+	e, ok := entries[name]
+	if !ok {
+		e = &UsageEntry{}
+		entries[name] = e
+	}
+	e.Bytes += int64(sign) * size
+	e.Files += sign
+	if e.Files <= 0 {
+		delete(entries, name)
+	}
+}
+
+func (i *index) Usage(ctx context.Context) (*Usage, error) {
+	return i.loadUsage(ctx)
+}
+
+func (i *index) loadUsage(ctx context.Context) (*Usage, error) {
+	usage := newUsage()
+
+	rc, err := i.strg.ReadFile(ctx, usagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read usage")
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(usage); err != nil {
+		return nil, errors.Wrap(err, "failed to decode usage")
+	}
+	if usage.Projects == nil {
+		usage.Projects = map[string]*UsageEntry{}
+	}
+	if usage.Users == nil {
+		usage.Users = map[string]*UsageEntry{}
+	}
+	return usage, nil
+}
+
+func (i *index) saveUsage(ctx context.Context, usage *Usage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	if err := i.strg.WriteFile(ctx, usagePath, bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "failed to write usage")
+	}
+	return nil
+}
+
+// updateUsage applies the given changes to the counters. Counters are derived data, so a failure
+// is logged instead of failing the operation, and RecalculateUsage repairs them.
+// Callers must hold i.mu.
+func (i *index) updateUsage(ctx context.Context, packageName string, removed, added []*FileRecord) {
+	if len(removed) == 0 && len(added) == 0 {
+		return
+	}
+
+	usage, err := i.loadUsage(ctx)
+	if err == nil {
+		for _, r := range removed {
+			usage.add(packageName, r, -1)
+		}
+		for _, r := range added {
+			usage.add(packageName, r, 1)
+		}
+		err = i.saveUsage(ctx, usage)
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("package", packageName).Msg("Failed to update usage, recalculate it to fix the counters")
+	}
+}
+
+// RecalculateUsage rebuilds the counters from the files in the storage. Files without a record
+// are read to find their size, and only counted for their project. Uploads and deletes wait for
+// it, so that none of their updates is overwritten.
+func (i *index) RecalculateUsage(ctx context.Context) (*Usage, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	packages, err := i.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := newUsage()
+	for _, pkg := range packages {
+		pkg = utils.NormalizePackageName(pkg)
+		files, err := i.ListPackageFiles(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
+		records, err := i.loadRecords(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			record, ok := records.Files[file]
+			if !ok {
+				size, err := i.fileSize(ctx, path.Join(pkg, file))
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				record = &FileRecord{FileName: file, Size: size}
+			}
+			usage.add(pkg, record, 1)
+		}
+	}
+
+	if err := i.saveUsage(ctx, usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (i *index) fileSize(ctx context.Context, filePath string) (int64, error) {
+	rc, err := i.strg.ReadFile(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(io.Discard, rc)
+}
+
+// checkQuota rejects an upload that would take the project or the uploader over their quota.
+// Replacing a file only counts the difference in size. The check happens before the content is
+// stored, so concurrent uploads can overshoot a quota by a little.
+func (i *index) checkQuota(ctx context.Context, packageName string, req *UploadFileRequest) error {
+	projectLimit, userLimit := i.quotas.DefaultProject, i.quotas.DefaultUser
+	if l, ok := i.quotas.Projects[packageName]; ok {
+		projectLimit = l
+	}
+	if req.Uploader != "" {
+		if l, ok := lookupUserQuota(i.quotas.Users, req.Uploader); ok {
+			userLimit = l
+		}
+	} else {
+		userLimit = config.QuotaLimit{}
+	}
+	if projectLimit == (config.QuotaLimit{}) && userLimit == (config.QuotaLimit{}) {
+		return nil
+	}
+
+	usage, err := i.loadUsage(ctx)
+	if err != nil {
+		return err
+	}
+	records, err := i.loadRecords(ctx, packageName)
+	if err != nil {
+		return err
+	}
+
+	// An overwritten file stops counting for its previous uploader.
+	var replacedBytes int64
+	replacedFiles, replacedByUploader := 0, false
+	if old, ok := records.Files[req.FileName]; ok {
+		replacedBytes, replacedFiles = old.Size, 1
+		replacedByUploader = strings.EqualFold(old.UploadedBy, req.Uploader)
+	}
+
+	project := entryOrZero(usage.Projects, packageName)
+	if err := exceeds(projectLimit, project.Bytes-replacedBytes+req.Size, project.Files-replacedFiles+1); err != nil {
+		return errors.Wrapf(ErrProjectQuotaExceeded, "project %s %s", packageName, err)
+	}
+
+	user := entryOrZero(usage.Users, userKey(req.Uploader))
+	if replacedByUploader {
+		user.Bytes -= replacedBytes
+		user.Files -= replacedFiles
+	}
+	if err := exceeds(userLimit, user.Bytes+req.Size, user.Files+1); err != nil {
+		return errors.Wrapf(ErrUserQuotaExceeded, "user %s %s", req.Uploader, err)
+	}
+	return nil
+}
+
+func lookupUserQuota(users map[string]config.QuotaLimit, username string) (config.QuotaLimit, bool) {
+	for name, l := range users {
+		if strings.EqualFold(name, username) {
+			return l, true
+		}
+	}
+	return config.QuotaLimit{}, false
+}
+
+func entryOrZero(entries map[string]*UsageEntry, name string) UsageEntry {
+	if e, ok := entries[name]; ok {
+		return *e
+	}
+	return UsageEntry{}
+}
+
+// exceeds describes how the limit would be exceeded, or returns nil.
+func exceeds(limit config.QuotaLimit, size int64, files int) error {
+	if limit.MaxBytes > 0 && size > limit.MaxBytes {
+		return errors.Errorf("would use %s of %s", formatBytes(size), formatBytes(limit.MaxBytes))
+	}
+	if limit.MaxFiles > 0 && files > limit.MaxFiles {
+		return errors.Errorf("would have %d of %d files", files, limit.MaxFiles)
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
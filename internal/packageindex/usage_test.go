@@ -0,0 +1,163 @@
+package packageindex
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func uploadSized(ctx context.Context, index Index, uploader, fileName string, size int) error {
+	return index.UploadFile(ctx, &UploadFileRequest{
+		PackageName: "foo",
+		Version:     "1.0",
+		FileName:    fileName,
+		FileType:    "sdist",
+		Uploader:    uploader,
+		Size:        int64(size),
+	}, strings.NewReader(strings.Repeat("x", size)))
+}
+
+func TestIndexUsage(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	index := NewIndex(strg, WithIngestOwner("ci"))
+
+	require.NoError(t, uploadSized(ctx, index, "alice", "foo-1.0.tar.gz", 10))
+	require.NoError(t, uploadSized(ctx, index, "bob", "foo-1.0.zip", 20))
+
+	usage, err := index.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &UsageEntry{Bytes: 30, Files: 2}, usage.Projects["foo"])
+	assert.Equal(t, &UsageEntry{Bytes: 10, Files: 1}, usage.Users["alice"])
+	assert.Equal(t, &UsageEntry{Bytes: 20, Files: 1}, usage.Users["bob"])
+
+	// Overwriting a file moves it to its new uploader.
+	require.NoError(t, uploadSized(ctx, index, "bob", "foo-1.0.tar.gz", 5))
+	usage, err = index.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &UsageEntry{Bytes: 25, Files: 2}, usage.Projects["foo"])
+	assert.NotContains(t, usage.Users, "alice")
+	assert.Equal(t, &UsageEntry{Bytes: 25, Files: 2}, usage.Users["bob"])
+
+	// Registered files count for the ingest owner, and pruned ones are removed.
+	wheel := buildWheel(t)
+	require.NoError(t, strg.WriteFile(ctx, "foo-bar/foo_bar-1.0-py3-none-any.whl", bytes.NewReader(wheel)))
+	require.NoError(t, strg.DeleteFile(ctx, "foo/foo-1.0.zip"))
+	_, err = index.Reconcile(ctx)
+	require.NoError(t, err)
+
+	usage, err = index.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &UsageEntry{Bytes: 5, Files: 1}, usage.Projects["foo"])
+	assert.Equal(t, &UsageEntry{Bytes: int64(len(wheel)), Files: 1}, usage.Projects["foo-bar"])
+	assert.Equal(t, &UsageEntry{Bytes: 5, Files: 1}, usage.Users["bob"])
+	assert.Equal(t, &UsageEntry{Bytes: int64(len(wheel)), Files: 1}, usage.Users["ci"])
+}
+
+func TestIndexRecalculateUsage(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	index := NewIndex(strg)
+
+	require.NoError(t, uploadSized(ctx, index, "alice", "foo-1.0.tar.gz", 10))
+	// Files deleted or written directly are only noticed by a recalculation.
+	require.NoError(t, strg.DeleteFile(ctx, "foo/foo-1.0.tar.gz"))
+	require.NoError(t, strg.WriteFile(ctx, "foo/foo-1.1.tar.gz", strings.NewReader("12345678")))
+
+	usage, err := index.RecalculateUsage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*UsageEntry{"foo": {Bytes: 8, Files: 1}}, usage.Projects)
+	assert.Empty(t, usage.Users)
+
+	stored, err := index.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, usage, stored)
+}
+
+func TestIndexUploadFile_Quotas(t *testing.T) {
+	tests := map[string]struct {
+		quotas   config.QuotaConfig
+		uploader string
+		size     int
+		wantErr  error
+	}{
+		"within quotas": {
+			quotas:   config.QuotaConfig{DefaultProject: config.QuotaLimit{MaxBytes: 100, MaxFiles: 2}},
+			uploader: "alice",
+			size:     50,
+		},
+		"project bytes": {
+			quotas:   config.QuotaConfig{DefaultProject: config.QuotaLimit{MaxBytes: 100}},
+			uploader: "alice",
+			size:     51,
+			wantErr:  ErrProjectQuotaExceeded,
+		},
+		"project files": {
+			quotas:   config.QuotaConfig{Projects: map[string]config.QuotaLimit{"foo": {MaxFiles: 1}}},
+			uploader: "alice",
+			size:     1,
+			wantErr:  ErrProjectQuotaExceeded,
+		},
+		"user bytes": {
+			quotas:   config.QuotaConfig{DefaultUser: config.QuotaLimit{MaxBytes: 60}},
+			uploader: "alice",
+			size:     20,
+			wantErr:  ErrUserQuotaExceeded,
+		},
+		"user bytes in another case": {
+			quotas:   config.QuotaConfig{DefaultUser: config.QuotaLimit{MaxBytes: 60}},
+			uploader: "ALICE",
+			size:     20,
+			wantErr:  ErrUserQuotaExceeded,
+		},
+		"user override is case insensitive": {
+			quotas: config.QuotaConfig{
+				DefaultUser: config.QuotaLimit{MaxBytes: 60},
+				Users:       map[string]config.QuotaLimit{"Alice": {}},
+			},
+			uploader: "alice",
+			size:     20,
+		},
+		"other user": {
+			quotas:   config.QuotaConfig{DefaultUser: config.QuotaLimit{MaxBytes: 60}},
+			uploader: "bob",
+			size:     20,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			index := NewIndex(storage.NewMemoryStorage(), WithQuotas(&tt.quotas))
+			require.NoError(t, uploadSized(ctx, index, "alice", "foo-1.0.tar.gz", 50))
+
+			err := uploadSized(ctx, index, tt.uploader, "foo-1.0.zip", tt.size)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				files, err := index.ListPackageFiles(ctx, "foo")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"foo-1.0.tar.gz"}, files)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestIndexUploadFile_QuotaOverwrite(t *testing.T) {
+	ctx := context.Background()
+	quotas := &config.QuotaConfig{DefaultProject: config.QuotaLimit{MaxBytes: 100, MaxFiles: 1}}
+	index := NewIndex(storage.NewMemoryStorage(), WithQuotas(quotas))
+
+	require.NoError(t, uploadSized(ctx, index, "alice", "foo-1.0.tar.gz", 80))
+	// Replacing the file only counts the difference.
+	require.NoError(t, uploadSized(ctx, index, "alice", "foo-1.0.tar.gz", 100))
+	require.ErrorIs(t, uploadSized(ctx, index, "alice", "foo-1.0.tar.gz", 101), ErrProjectQuotaExceeded)
+}
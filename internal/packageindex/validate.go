@@ -0,0 +1,66 @@
+package packageindex
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+var (
+	ErrInvalidPackageName = errors.New("invalid package name")
+	ErrInvalidFileName    = errors.New("invalid file name")
+	// ErrInvalidMetadata is returned for uploads whose core metadata is malformed.
+	ErrInvalidMetadata = errors.New("invalid metadata")
+)
+
+var (
+	// https://packaging.python.org/en/latest/specifications/name-normalization/#name-format
+	validPackageName = regexp.MustCompile(`(?i)^([A-Z0-9]|[A-Z0-9][A-Z0-9._-]*[A-Z0-9])$`)
+	// Distribution file names only ever contain these characters, see
+	// https://packaging.python.org/en/latest/specifications/binary-distribution-format/#escaping-and-unicode
+	// and https://packaging.python.org/en/latest/specifications/source-distribution-format/#source-distribution-file-name.
+	validFileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+!-]*$`)
+)
+
+// maxFileNameLength is the file name limit of most file systems.
+const maxFileNameLength = 255
+
+// ValidatePackageName checks that the name is a valid project name. Valid names can never
+// contain path separators or dot segments, so they are safe to use as a storage path segment.
+func ValidatePackageName(name string) error {
+	if len(name) > maxFileNameLength || !validPackageName.MatchString(name) {
+		return errors.Wrapf(ErrInvalidPackageName, "%q", name)
+	}
+	return nil
+}
+
+// ValidateFileName checks that the name is a plain distribution file name that stays within
+// the package directory. Hidden files are rejected as well, since storage backends keep
+// temporary and internal files under dot-prefixed names.
+func ValidateFileName(name string) error {
+	if len(name) > maxFileNameLength || !validFileName.MatchString(name) || strings.Contains(name, "..") {
+		return errors.Wrapf(ErrInvalidFileName, "%q", name)
+	}
+	return nil
+}
+
+// ValidateRequiresPython checks that a Requires-Python value is a valid version specifier set.
+func ValidateRequiresPython(requiresPython string) error {
+	if _, err := utils.ParseSpecifierSet(requiresPython); err != nil {
+		return errors.Wrapf(ErrInvalidMetadata, "Requires-Python: %s", err)
+	}
+	return nil
+}
+
+// ValidateRequiresDist checks that every Requires-Dist value is a valid dependency specification.
+func ValidateRequiresDist(requiresDist []string) error {
+	for _, requirement := range requiresDist {
+		if _, err := utils.ParseRequirement(requirement); err != nil {
+			return errors.Wrapf(ErrInvalidMetadata, "Requires-Dist: %s", err)
+		}
+	}
+	return nil
+}
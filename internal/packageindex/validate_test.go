@@ -0,0 +1,131 @@
+package packageindex
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// hostileNames are inputs that must never reach the storage as a path segment.
+func hostileNames() []string {
+	return []string{
+		"",
+		".",
+		"..",
+		"../",
+		"../etc",
+		"..\\..\\windows",
+		"foo/../../bar",
+		"foo/bar",
+		"foo\\bar",
+		"/etc/passwd",
+		"/",
+		"C:\\Windows",
+		"C:foo",
+		"foo\x00bar",
+		"foo\nbar",
+		"foo bar",
+		".hidden",
+		".tmp-foo",
+		"-foo",
+		"%2e%2e",
+		"%2e%2e%2fetc",
+		"foo%2fbar",
+		"\u2024\u2024",
+		"foo\u2215bar",
+		strings.Repeat("a", 256),
+	}
+}
+
+func TestValidatePackageName(t *testing.T) {
+	for _, name := range []string{"foo", "Foo_Bar", "foo.bar", "foo-bar", "a", "A1", "zope.interface"} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, ValidatePackageName(name))
+		})
+	}
+
+	for _, name := range append(hostileNames(), "foo-", "foo.", "_foo") {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, ValidatePackageName(name), ErrInvalidPackageName)
+		})
+	}
+}
+
+func TestValidateFileName(t *testing.T) {
+	for _, name := range []string{
+		"foo-1.0.0.tar.gz",
+		"foo_bar-1.0.0-py3-none-any.whl",
+		"foo-1.0.0+local.1-cp311-cp311-manylinux_2_17_x86_64.manylinux2014_x86_64.whl",
+		"foo-1!2.0.zip",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, ValidateFileName(name))
+		})
+	}
+
+	for _, name := range append(hostileNames(), "foo..tar.gz") {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, ValidateFileName(name), ErrInvalidFileName)
+		})
+	}
+}
+
+func TestIndexRejectsHostileNames(t *testing.T) {
+	ctx := context.Background()
+	strg := storage.NewMemoryStorage()
+	require.NoError(t, strg.WriteFile(ctx, "secret/token.txt", strings.NewReader("secret")))
+	index := NewIndex(strg)
+
+	for _, name := range hostileNames() {
+		t.Run(name, func(t *testing.T) {
+			_, err := index.ListPackageFiles(ctx, name)
+			require.ErrorIs(t, err, ErrInvalidPackageName)
+
+			_, err = index.DownloadFile(ctx, name, "foo-1.0.0.tar.gz")
+			require.ErrorIs(t, err, ErrInvalidPackageName)
+
+			_, err = index.DownloadFile(ctx, "foo", name)
+			require.ErrorIs(t, err, ErrInvalidFileName)
+
+			err = index.UploadFile(ctx, &UploadFileRequest{PackageName: name, FileName: "foo-1.0.0.tar.gz"}, strings.NewReader("x"))
+			require.ErrorIs(t, err, ErrInvalidPackageName)
+
+			err = index.UploadFile(ctx, &UploadFileRequest{PackageName: "foo", FileName: name}, strings.NewReader("x"))
+			require.ErrorIs(t, err, ErrInvalidFileName)
+		})
+	}
+
+	// Nothing but the original file may exist.
+	packages, err := strg.ListPackages(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"secret"}, packages)
+}
+
+func TestIndexUploadFile_InvalidMetadata(t *testing.T) {
+	tests := map[string]*UploadFileRequest{
+		"requires python":      {RequiresPython: utils.Pointer(">=3.8,")},
+		"requires dist":        {RequiresDist: []string{"requests>=2.0", "numpy >=1.0 <2.0"}},
+		"requires dist marker": {RequiresDist: []string{`tomli; python_version < "3.11" and`}},
+	}
+
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			index := NewIndex(storage.NewMemoryStorage())
+			req.PackageName, req.Version, req.FileName = "foo", "1.0", "foo-1.0.tar.gz"
+
+			err := index.UploadFile(ctx, req, strings.NewReader("content"))
+			require.ErrorIs(t, err, ErrInvalidMetadata)
+
+			files, err := index.ListPackageFiles(ctx, "foo")
+			require.NoError(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
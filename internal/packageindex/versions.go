@@ -0,0 +1,52 @@
+package packageindex
+
+import (
+	"sort"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// VersionGroup is the files of one version of a package.
+type VersionGroup struct {
+	Version *utils.Version
+	// Files are sorted by name.
+	Files []string
+}
+
+// GroupFilesByVersion groups distribution files by their PEP 440 version, newest version first.
+// Versions that only differ in their release padding, like 1.0 and 1.0.0, are the same group.
+// Files whose name or version can't be parsed are returned apart, sorted by name.
+func GroupFilesByVersion(files []string) (groups []*VersionGroup, unparseable []string) {
+	for _, file := range files {
+		dist, err := utils.ParseDistributionFileName(file)
+		if err != nil {
+			unparseable = append(unparseable, file)
+			continue
+		}
+		version, err := utils.ParseVersion(dist.Version)
+		if err != nil {
+			unparseable = append(unparseable, file)
+			continue
+		}
+
+		var group *VersionGroup
+		for _, g := range groups {
+			if g.Version.Compare(version) == 0 {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &VersionGroup{Version: version}
+			groups = append(groups, group)
+		}
+		group.Files = append(group.Files, file)
+	}
+
+	sort.Slice(groups, func(a, b int) bool { return groups[a].Version.Compare(groups[b].Version) > 0 })
+	for _, g := range groups {
+		sort.Strings(g.Files)
+	}
+	sort.Strings(unparseable)
+	return groups, unparseable
+}
@@ -0,0 +1,200 @@
+package packageindex
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+// wheelTarget is an interpreter and its platforms, for which wheels are picked like pip does.
+type wheelTarget struct {
+	tags       []utils.Tag
+	priorities map[utils.Tag]int
+}
+
+func newWheelTarget(implementation string, python *utils.Version, platforms []string) (*wheelTarget, error) {
+	tags, err := utils.SupportedTags(implementation, python, platforms)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidWheelTarget, err.Error())
+	}
+	return &wheelTarget{tags: tags, priorities: utils.TagPriorities(tags)}, nil
+}
+
+// tag returns the tag of a rank.
+func (t *wheelTarget) tag(rank int) string {
+	return t.tags[rank].String()
+}
+
+// rank reports whether the target supports a wheel, and the priority of its best tag, lower first.
+func (t *wheelTarget) rank(dist *utils.DistributionFile) (int, bool) {
+	tags, err := dist.Tags()
+	if err != nil {
+		return 0, false
+	}
+	rank, ok := 0, false
+	for _, tag := range tags {
+		if p, supported := t.priorities[tag]; supported && (!ok || p < rank) {
+			rank, ok = p, true
+		}
+	}
+	return rank, ok
+}
+
+// worst is a rank after every supported tag.
+func (t *wheelTarget) worst() int {
+	return len(t.tags)
+}
+
+// compatiblePlatforms collects the platform tags of wheels that run on one of the platforms.
+func compatiblePlatforms(platforms []string) (map[string]struct{}, error) {
+	compatible := map[string]struct{}{"any": {}}
+	for _, p := range platforms {
+		expanded, err := utils.CompatiblePlatforms(p)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidWheelTarget, err.Error())
+		}
+		for _, c := range expanded {
+			compatible[c] = struct{}{}
+		}
+	}
+	return compatible, nil
+}
+
+// wheelForPlatforms reports whether a wheel runs on one of the platforms collected by
+// compatiblePlatforms, whatever the interpreter.
+func wheelForPlatforms(dist *utils.DistributionFile, compatible map[string]struct{}) bool {
+	tags, err := dist.Tags()
+	if err != nil {
+		return false
+	}
+	for _, tag := range tags {
+		if _, ok := compatible[tag.Platform]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wheelForPython reports whether a wheel runs on a CPython version, whatever the platform.
+func wheelForPython(dist *utils.DistributionFile, python *utils.Version) bool {
+	tags, err := dist.Tags()
+	if err != nil {
+		return false
+	}
+	platforms := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		platforms = append(platforms, tag.Platform)
+	}
+	// Wheels with unsupported platform versions never match.
+	target, err := newWheelTarget("cp", python, platforms)
+	if err != nil {
+		return false
+	}
+	_, ok := target.rank(dist)
+	return ok
+}
+
+var ErrInvalidWheelTarget = errors.New("invalid wheel target")
+
+var implementationPattern = regexp.MustCompile(`^[a-z]+$`)
+
+// WheelTarget is an interpreter and the platforms it runs on.
+type WheelTarget struct {
+	// Implementation is an interpreter abbreviation, such as cp or pp. It defaults to cp.
+	Implementation string
+	// PythonVersion is the Python version the interpreter implements, such as 3.12.
+	PythonVersion string
+	Platforms     []string
+}
+
+// VersionWheels is the wheel of a version that a target prefers.
+type VersionWheels struct {
+	Version string
+	// Best is nil if no wheel of the version supports the target.
+	Best *FileRecord
+	// Tag is the tag of the best wheel that the target supports.
+	Tag string
+	// MissingPlatforms are the platforms of the target that no wheel of the version supports.
+	MissingPlatforms []string
+}
+
+func (i *index) BestWheels(ctx context.Context, packageName string, target *WheelTarget) ([]*VersionWheels, error) {
+	if err := ValidatePackageName(packageName); err != nil {
+		return nil, err
+	}
+	python, err := utils.ParseVersion(target.PythonVersion)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidWheelTarget, "invalid python_version %q", target.PythonVersion)
+	}
+	implementation := strings.ToLower(target.Implementation)
+	if implementation == "" {
+		implementation = "cp"
+	}
+	if !implementationPattern.MatchString(implementation) {
+		return nil, errors.Wrapf(ErrInvalidWheelTarget, "invalid implementation %q", target.Implementation)
+	}
+	all, err := newWheelTarget(implementation, python, target.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	perPlatform := make([]*wheelTarget, len(target.Platforms))
+	for n, platform := range target.Platforms {
+		if perPlatform[n], err = newWheelTarget(implementation, python, []string{platform}); err != nil {
+			return nil, err
+		}
+	}
+
+	records, err := i.loadRecords(ctx, utils.NormalizePackageName(packageName))
+	if err != nil {
+		return nil, err
+	}
+	if len(records.Files) == 0 {
+		return nil, errors.Wrapf(os.ErrNotExist, "no files of %s", packageName)
+	}
+
+	files := make([]string, 0, len(records.Files))
+	for name, record := range records.Files {
+		if record.Metadata.RequiresPython != "" {
+			set, err := utils.ParseSpecifierSet(record.Metadata.RequiresPython)
+			if err != nil || !set.Contains(python, true) {
+				continue
+			}
+		}
+		files = append(files, name)
+	}
+	groups, _ := GroupFilesByVersion(files)
+
+	versions := make([]*VersionWheels, 0, len(groups))
+	for _, g := range groups {
+		v := &VersionWheels{Version: g.Version.String(), MissingPlatforms: []string{}}
+		supported := make([]bool, len(target.Platforms))
+		bestRank := 0
+		for _, file := range g.Files {
+			dist, err := utils.ParseDistributionFileName(file)
+			if err != nil || dist.Type != utils.DistributionWheel {
+				continue
+			}
+			if rank, ok := all.rank(dist); ok && (v.Best == nil || rank < bestRank) {
+				v.Best, bestRank = records.Files[file], rank
+				v.Tag = all.tag(rank)
+			}
+			for n, t := range perPlatform {
+				if _, ok := t.rank(dist); ok {
+					supported[n] = true
+				}
+			}
+		}
+		for n, ok := range supported {
+			if !ok {
+				v.MissingPlatforms = append(v.MissingPlatforms, target.Platforms[n])
+			}
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
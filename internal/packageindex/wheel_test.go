@@ -0,0 +1,65 @@
+package packageindex
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func TestIndexBestWheels(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(storage.NewMemoryStorage())
+
+	uploadFile(ctx, t, index, "foo-2.0.tar.gz", "")
+	uploadFile(ctx, t, index, "foo-2.0-cp312-cp312-manylinux_2_28_x86_64.whl", "")
+	uploadFile(ctx, t, index, "foo-2.0-cp39-abi3-manylinux_2_17_x86_64.manylinux2014_x86_64.whl", "")
+	uploadFile(ctx, t, index, "foo-2.0-cp39-abi3-macosx_11_0_arm64.whl", "")
+	uploadFile(ctx, t, index, "foo-1.0-py2.py3-none-any.whl", "")
+	uploadFile(ctx, t, index, "foo-0.9-py3-none-any.whl", "<3.8")
+	uploadFile(ctx, t, index, "foo-0.8.tar.gz", "")
+
+	versions, err := index.BestWheels(ctx, "Foo", &WheelTarget{
+		PythonVersion: "3.12",
+		Platforms:     []string{"manylinux_2_31_x86_64", "musllinux_1_2_x86_64"},
+	})
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	assert.Equal(t, "2.0", versions[0].Version)
+	assert.Equal(t, "foo-2.0-cp312-cp312-manylinux_2_28_x86_64.whl", versions[0].Best.FileName)
+	assert.Equal(t, "cp312-cp312-manylinux_2_28_x86_64", versions[0].Tag)
+	assert.Equal(t, []string{"musllinux_1_2_x86_64"}, versions[0].MissingPlatforms)
+
+	assert.Equal(t, "1.0", versions[1].Version)
+	assert.Equal(t, "py3-none-any", versions[1].Tag)
+	assert.Empty(t, versions[1].MissingPlatforms)
+
+	// 0.9 doesn't support Python 3.12, and 0.8 has no wheel.
+	assert.Equal(t, "0.8", versions[2].Version)
+	assert.Nil(t, versions[2].Best)
+	assert.Equal(t, []string{"manylinux_2_31_x86_64", "musllinux_1_2_x86_64"}, versions[2].MissingPlatforms)
+
+	// An older glibc only runs the manylinux2014 wheel.
+	versions, err = index.BestWheels(ctx, "foo", &WheelTarget{PythonVersion: "3.12", Platforms: []string{"manylinux_2_17_x86_64"}})
+	require.NoError(t, err)
+	assert.Equal(t, "cp39-abi3-manylinux_2_17_x86_64", versions[0].Tag)
+
+	versions, err = index.BestWheels(ctx, "foo", &WheelTarget{Implementation: "pp", PythonVersion: "3.10", Platforms: []string{"macosx_14_0_arm64"}})
+	require.NoError(t, err)
+	assert.Nil(t, versions[0].Best)
+	assert.Equal(t, "py3-none-any", versions[1].Tag)
+
+	_, err = index.BestWheels(ctx, "foo", &WheelTarget{PythonVersion: "three"})
+	assert.ErrorIs(t, err, ErrInvalidWheelTarget)
+	_, err = index.BestWheels(ctx, "foo", &WheelTarget{Implementation: "c-p", PythonVersion: "3.12"})
+	assert.ErrorIs(t, err, ErrInvalidWheelTarget)
+	_, err = index.BestWheels(ctx, "foo", &WheelTarget{PythonVersion: "3.12", Platforms: []string{"manylinux_2_3000000_x86_64"}})
+	assert.ErrorIs(t, err, ErrInvalidWheelTarget)
+	_, err = index.BestWheels(ctx, "bar", &WheelTarget{PythonVersion: "3.12"})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
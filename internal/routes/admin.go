@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+
+	internalMw "github.com/jeongukjae/pypi-server/internal/middleware"
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func SetupAdminRoutes(e *echo.Echo, index packageindex.Index, adminUsers []string) {
+	g := e.Group("/admin", requireAdmin(adminUsers))
+	g.POST("/reconcile", Reconcile(index))
+	g.GET("/usage", GetUsage(index))
+	// GET only reports what the retention rules would delete.
+	g.GET("/retention", ApplyRetention(index, true))
+	g.POST("/retention", ApplyRetention(index, false))
+	g.POST("/yank", YankFile(index, true))
+	g.DELETE("/yank", YankFile(index, false))
+}
+
+// requireAdmin only lets the given users through. It must run after the Authorizer middleware.
+func requireAdmin(adminUsers []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			info := internalMw.GetUserInfo(c.Request().Context())
+			if info == nil || !slices.Contains(adminUsers, info.Username) {
+				return c.JSON(http.StatusForbidden, &HTTPError{Message: "Admin access required"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// Reconcile registers files written to the storage directly. The optional package query
+// parameter limits it to one package.
+func Reconcile(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var (
+			report *packageindex.ReconcileReport
+			err    error
+		)
+		if packageName := c.QueryParam("package"); packageName != "" {
+			report, err = index.ReconcilePackage(ctx, packageName)
+		} else {
+			report, err = index.Reconcile(ctx)
+		}
+		if errors.Is(err, packageindex.ErrInvalidPackageName) {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid package name", Errors: []string{err.Error()}})
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to reconcile index with storage")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to reconcile", Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetUsage reports the storage used per project and per uploader.
+func GetUsage(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		usage, err := index.Usage(c.Request().Context())
+		if err != nil {
+			log.Ctx(c.Request().Context()).Error().Err(err).Msg("Failed to read usage")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to read usage", Errors: []string{err.Error()}})
+		}
+		return c.JSON(http.StatusOK, usage)
+	}
+}
+
+// ApplyRetention deletes the files that the retention rules don't keep, or only lists them on a
+// dry run. The optional package query parameter limits it to one package.
+func ApplyRetention(index packageindex.Index, dryRun bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var (
+			report *packageindex.RetentionReport
+			err    error
+		)
+		if packageName := c.QueryParam("package"); packageName != "" {
+			report, err = index.ApplyRetentionPackage(ctx, packageName, dryRun)
+		} else {
+			report, err = index.ApplyRetention(ctx, dryRun)
+		}
+		if errors.Is(err, packageindex.ErrInvalidPackageName) {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid package name", Errors: []string{err.Error()}})
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to apply retention rules")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to apply retention rules", Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// YankFile yanks the file given by the package and file query parameters, with the optional reason
+// parameter, or un-yanks it.
+func YankFile(index packageindex.Index, yank bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var reason *string
+		if yank {
+			reason = utils.Pointer(c.QueryParam("reason"))
+		}
+		err := index.YankFile(ctx, c.QueryParam("package"), c.QueryParam("file"), reason)
+		if errors.Is(err, packageindex.ErrInvalidPackageName) || errors.Is(err, packageindex.ErrInvalidFileName) {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid file path", Errors: []string{err.Error()}})
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return c.JSON(http.StatusNotFound, &HTTPError{Message: "File not found"})
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to yank file")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to yank file", Errors: []string{err.Error()}})
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
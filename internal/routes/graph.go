@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func SetupGraphRoutes(e *echo.Echo, index packageindex.Index) {
+	g := e.Group("/api/projects")
+	g.GET("/:package/dependents", GetReverseDependencies(index))
+	g.GET("/:package/:version/dependencies", GetDependencies(index))
+	g.GET("/:package/:version/dependencies/closure", GetDependencyClosure(index))
+}
+
+// graphError maps errors of the dependency graph to responses.
+func graphError(c echo.Context, err error, message string) error {
+	if errors.Is(err, packageindex.ErrInvalidPackageName) {
+		return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid package name", Errors: []string{err.Error()}})
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return c.JSON(http.StatusNotFound, &HTTPError{Message: "Release not found"})
+	}
+	log.Ctx(c.Request().Context()).Error().Err(err).Msg(message)
+	return c.JSON(errorStatus(err), &HTTPError{Message: message, Errors: []string{err.Error()}})
+}
+
+// GetDependencies lists the requirements of a release.
+func GetDependencies(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		deps, err := index.Dependencies(c.Request().Context(), c.Param("package"), c.Param("version"))
+		if err != nil {
+			return graphError(c, err, "Failed to read dependencies")
+		}
+		return c.JSON(http.StatusOK, deps)
+	}
+}
+
+// GetReverseDependencies lists the releases that require a project.
+func GetReverseDependencies(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		dependents, err := index.ReverseDependencies(c.Request().Context(), c.Param("package"))
+		if err != nil {
+			return graphError(c, err, "Failed to read dependents")
+		}
+		return c.JSON(http.StatusOK, dependents)
+	}
+}
+
+// GetDependencyClosure lists every release reachable from a release. With the optional
+// python_version query parameter, markers are evaluated for CPython on Linux.
+func GetDependencyClosure(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var env utils.MarkerEnvironment
+		if pythonVersion := c.QueryParam("python_version"); pythonVersion != "" {
+			if _, err := utils.ParseVersion(pythonVersion); err != nil {
+				return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid python_version", Errors: []string{err.Error()}})
+			}
+			env = utils.DefaultMarkerEnvironment(pythonVersion)
+		}
+
+		closure, err := index.DependencyClosure(c.Request().Context(), c.Param("package"), c.Param("version"), env)
+		if err != nil {
+			return graphError(c, err, "Failed to resolve dependencies")
+		}
+		return c.JSON(http.StatusOK, closure)
+	}
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
+func SetupHealthRoutes(e *echo.Echo, strg storage.Storage) {
+	e.GET("/healthz", Health(strg))
+}
+
+// Health returns 503 while the storage is known to be unavailable. The route doesn't require
+// authentication, so the cause is only logged.
+func Health(strg storage.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := storage.Health(strg); err != nil {
+			log.Ctx(c.Request().Context()).Warn().Err(err).Msg("Storage is unavailable")
+			return c.JSON(http.StatusServiceUnavailable, &HTTPError{Message: "Storage is unavailable"})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
@@ -1,11 +1,13 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 
+	internalMw "github.com/jeongukjae/pypi-server/internal/middleware"
 	"github.com/jeongukjae/pypi-server/internal/packageindex"
 )
 
@@ -32,6 +34,7 @@ type UploadFilePayload struct {
 	Description            *string  `form:"description"`
 	DescriptionContentType *string  `form:"description_content_type"`
 	PyVersion              *string  `form:"pyversion"`
+	RequiresPython         *string  `form:"requires_python"`
 	RequiresDist           []string `form:"requires_dist"`
 
 	Md5Digest        *string `form:"md5_digest"`
@@ -80,19 +83,47 @@ func UploadFile(index packageindex.Index) echo.HandlerFunc {
 				Description:            payload.Description,
 				DescriptionContentType: payload.DescriptionContentType,
 				Pyversion:              payload.PyVersion,
-				RequiresPython:         payload.PyVersion,
+				RequiresPython:         payload.RequiresPython,
 				RequiresDist:           payload.RequiresDist,
 				Md5Digest:              payload.Md5Digest,
 				Sha256Digest:           payload.Sha256Digest,
 				Blake2256Digest:        payload.Blake2_256Digest,
+				Uploader:               uploader(c),
+				Size:                   formFile.Size,
 			},
 			file,
 		); err != nil {
+			if errors.Is(err, packageindex.ErrInvalidPackageName) ||
+				errors.Is(err, packageindex.ErrInvalidFileName) ||
+				errors.Is(err, packageindex.ErrInvalidMetadata) {
+				return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid request", Errors: []string{err.Error()}})
+			}
+			var violation *packageindex.PolicyViolation
+			if errors.As(err, &violation) {
+				errs := make([]string, 0, len(violation.Reasons))
+				for _, reason := range violation.Reasons {
+					errs = append(errs, violation.FileName+": "+reason)
+				}
+				return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Rejected by the upload policy", Errors: errs})
+			}
+			if errors.Is(err, packageindex.ErrProjectQuotaExceeded) {
+				return c.JSON(http.StatusRequestEntityTooLarge, &HTTPError{Message: "Project quota exceeded", Errors: []string{err.Error()}})
+			}
+			if errors.Is(err, packageindex.ErrUserQuotaExceeded) {
+				return c.JSON(http.StatusForbidden, &HTTPError{Message: "User quota exceeded", Errors: []string{err.Error()}})
+			}
 			// TODO: Refine status code.
-			return c.JSON(http.StatusInternalServerError, &HTTPError{Message: "Failed to upload file", Errors: []string{err.Error()}})
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to upload file", Errors: []string{err.Error()}})
 		}
 
 		log.Ctx(c.Request().Context()).Info().Str("package", payload.Name).Str("version", payload.Version).Str("file", formFile.Filename).Msg("File uploaded")
 		return c.JSON(http.StatusOK, map[string]string{"status": "success"})
 	}
 }
+
+func uploader(c echo.Context) string {
+	if info := internalMw.GetUserInfo(c.Request().Context()); info != nil {
+		return info.Username
+	}
+	return ""
+}
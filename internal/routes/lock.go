@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+)
+
+func SetupLockRoutes(e *echo.Echo, index packageindex.Index) {
+	e.POST("/api/pylock/verify", VerifyLock(index))
+}
+
+// VerifyLock checks a pylock.toml lock file, sent as the request body, against the index. Files
+// are expected under the URL the request was sent to, or under the index_url query parameter.
+func VerifyLock(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		indexURL := c.QueryParam("index_url")
+		if indexURL == "" {
+			indexURL = c.Scheme() + "://" + c.Request().Host
+		}
+
+		report, err := index.VerifyLock(ctx, c.Request().Body, indexURL)
+		if errors.Is(err, packageindex.ErrInvalidLockFile) {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid lock file", Errors: []string{err.Error()}})
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to verify lock file")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to verify lock file", Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, &VerifyLockResponse{OK: report.OK(), LockReport: report})
+	}
+}
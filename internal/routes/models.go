@@ -1,6 +1,102 @@
 package routes
 
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+)
+
 type HTTPError struct {
 	Message string   `json:"message"`
 	Errors  []string `json:"errors,omitempty"`
 }
+
+// Simple repository API (PEP 691) JSON responses, with the additions of PEP 700.
+type SimpleMeta struct {
+	APIVersion string `json:"api-version"`
+}
+
+type SimpleProject struct {
+	Name string `json:"name"`
+}
+
+type SimpleProjectList struct {
+	Meta     SimpleMeta      `json:"meta"`
+	Projects []SimpleProject `json:"projects"`
+}
+
+type SimpleFile struct {
+	FileName       string            `json:"filename"`
+	URL            string            `json:"url"`
+	Hashes         map[string]string `json:"hashes"`
+	RequiresPython string            `json:"requires-python,omitempty"`
+	// Size and UploadTime are unknown for files that are not recorded yet.
+	Size       *int64 `json:"size,omitempty"`
+	UploadTime string `json:"upload-time,omitempty"`
+	// Yanked is true or the reason for yanked files (PEP 592), and omitted otherwise.
+	Yanked any `json:"yanked,omitempty"`
+}
+
+type SimpleProjectDetail struct {
+	Meta     SimpleMeta   `json:"meta"`
+	Name     string       `json:"name"`
+	Versions []string     `json:"versions"`
+	Files    []SimpleFile `json:"files"`
+	// UnparseableFiles are the files whose version can't be parsed, which are also listed in Files.
+	// Keys starting with an underscore are reserved for private use by PEP 691.
+	UnparseableFiles []string `json:"_unparseable-files"`
+}
+
+// ResolvedFile is a file pinned by the resolution endpoint.
+type ResolvedFile struct {
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	FileName string `json:"filename"`
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+}
+
+type ResolveResponse struct {
+	Files []ResolvedFile `json:"files"`
+}
+
+type ResolveConflictResponse struct {
+	Message  string                           `json:"message"`
+	Conflict *packageindex.ResolutionConflict `json:"conflict"`
+}
+
+type BestWheelFile struct {
+	FileName string `json:"filename"`
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+	// Tag is the tag of the wheel that the target supports best.
+	Tag string `json:"tag"`
+}
+
+type BestWheelsVersion struct {
+	Version string `json:"version"`
+	// File is null if no wheel of the version supports the target.
+	File             *BestWheelFile `json:"file"`
+	MissingPlatforms []string       `json:"missing_platforms"`
+}
+
+type BestWheelsResponse struct {
+	Versions []BestWheelsVersion `json:"versions"`
+}
+
+type VerifyLockResponse struct {
+	OK bool `json:"ok"`
+	*packageindex.LockReport
+}
+
+// errorStatus returns 503 for storage failures that are likely temporary, so that clients retry,
+// and 500 otherwise.
+func errorStatus(err error) int {
+	if errors.Is(err, storage.ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+)
+
+func SetupResolveRoutes(e *echo.Echo, index packageindex.Index) {
+	e.POST("/api/resolve", Resolve(index))
+	e.POST("/api/requirements", HashedRequirements(index))
+}
+
+// Resolve pins a file for every project needed by a list of requirements, so that clients without
+// a Python toolchain can download exactly those files.
+func Resolve(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var req packageindex.ResolveRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid request", Errors: []string{err.Error()}})
+		}
+
+		resolution, err := index.Resolve(ctx, &req)
+		var conflict *packageindex.ResolutionConflict
+		switch {
+		case errors.As(err, &conflict):
+			return c.JSON(http.StatusConflict, &ResolveConflictResponse{Message: "Requirements can't be satisfied", Conflict: conflict})
+		case errors.Is(err, packageindex.ErrInvalidResolveRequest), errors.Is(err, packageindex.ErrInvalidPackageName),
+			errors.Is(err, packageindex.ErrInvalidWheelTarget):
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid request", Errors: []string{err.Error()}})
+		case errors.Is(err, packageindex.ErrResolutionTooComplex):
+			return c.JSON(http.StatusUnprocessableEntity, &HTTPError{Message: "Requirements are too complex to resolve", Errors: []string{err.Error()}})
+		case err != nil:
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to resolve requirements")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to resolve requirements", Errors: []string{err.Error()}})
+		}
+
+		res := ResolveResponse{Files: make([]ResolvedFile, 0, len(resolution.Files))}
+		for _, f := range resolution.Files {
+			res.Files = append(res.Files, ResolvedFile{
+				Package:  f.Package,
+				Version:  f.Version,
+				FileName: f.FileName,
+				URL:      fileURL(f.Package, f.FileName),
+				SHA256:   f.SHA256,
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// HashedRequirements writes a requirements file for pip install --require-hashes, with the
+// hashes of every file of the pinned releases.
+func HashedRequirements(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var req packageindex.HashPinRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid request", Errors: []string{err.Error()}})
+		}
+
+		pins, err := index.HashPins(ctx, &req)
+		switch {
+		case errors.Is(err, packageindex.ErrInvalidPins), errors.Is(err, packageindex.ErrInvalidWheelTarget):
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid request", Errors: []string{err.Error()}})
+		case errors.Is(err, packageindex.ErrPinNotFound):
+			return c.JSON(http.StatusNotFound, &HTTPError{Message: "Pinned release not found", Errors: []string{err.Error()}})
+		case err != nil:
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to read pinned files")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to read pinned files", Errors: []string{err.Error()}})
+		}
+
+		body := ""
+		for _, pin := range pins {
+			body += pin.RequirementsLine() + "\n"
+		}
+		return c.String(http.StatusOK, body)
+	}
+}
@@ -0,0 +1,498 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/storage"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func newTestServer(strg storage.Storage, opts ...packageindex.IndexOption) *echo.Echo {
+	index := packageindex.NewIndex(strg, opts...)
+
+	e := echo.New()
+	SetupSimpleRoutes(e, index)
+	SetupLegacyRoutes(e, index)
+	SetupGraphRoutes(e, index)
+	SetupResolveRoutes(e, index)
+	SetupLockRoutes(e, index)
+	SetupWheelRoutes(e, index)
+	SetupHealthRoutes(e, strg)
+	return e
+}
+
+func serve(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func get(e *echo.Echo, target string) *httptest.ResponseRecorder {
+	return serve(e, httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+func uploadRequest(t *testing.T, name, version, fileName, content string) *http.Request {
+	t.Helper()
+	return uploadRequestWithFields(t, map[string]string{"name": name, "version": version}, fileName, content)
+}
+
+// uploadRequestWithFields builds an upload of an sdist with the given form fields, which must
+// include at least the name and version.
+func uploadRequestWithFields(t *testing.T, fields map[string]string, fileName, content string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range map[string]string{
+		":action":          "file_upload",
+		"protocol_version": "1",
+		"filetype":         "sdist",
+		"metadata_version": "2.1",
+	} {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	for k, v := range fields {
+		require.NoError(t, w.WriteField(k, v))
+	}
+	fw, err := w.CreateFormFile("content", fileName)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/legacy/", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return req
+}
+
+func TestRoutes(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	rec := serve(e, uploadRequest(t, "foo", "1.0", "foo-1.0.tar.gz", "sdist"))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = get(e, "/simple/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<a href="/simple/foo/">foo</a>`)
+
+	rec = get(e, "/simple/foo/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `/simple/foo/foo-1.0.tar.gz#sha256=`)
+
+	rec = get(e, "/simple/foo/foo-1.0.tar.gz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "sdist", rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get(e, "/simple/foo/foo-2.0.tar.gz").Code)
+	assert.Equal(t, http.StatusBadRequest, get(e, "/simple/foo/.hidden").Code)
+	assert.Equal(t, http.StatusOK, get(e, "/healthz").Code)
+}
+
+func TestRoutes_StorageErrors(t *testing.T) {
+	backend := storage.NewMemoryStorage()
+	require.NoError(t, backend.WriteFile(context.Background(), "foo/foo-1.0.tar.gz", strings.NewReader("sdist")))
+
+	e := newTestServer(storage.NewFaultyStorage(backend, &config.FaultyConfig{
+		Seed:             1,
+		ListPackages:     config.FaultConfig{ErrorRate: 1},
+		ListPackageFiles: config.FaultConfig{ErrorRate: 1},
+		ReadFile:         config.FaultConfig{ErrorRate: 1},
+		WriteFile:        config.FaultConfig{PartialWriteRate: 1},
+	}))
+
+	for _, target := range []string{"/simple/", "/simple/foo/", "/simple/foo/foo-1.0.tar.gz"} {
+		rec := get(e, target)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code, target)
+		assert.Contains(t, rec.Body.String(), storage.ErrInjectedFault.Error(), target)
+	}
+
+	rec := serve(e, uploadRequest(t, "foo", "1.1", "foo-1.1.tar.gz", "sdist"))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Failed to upload file")
+}
+
+func TestRoutes_StorageUnavailable(t *testing.T) {
+	faulty := storage.NewFaultyStorage(storage.NewMemoryStorage(), &config.FaultyConfig{
+		Seed:         1,
+		ListPackages: config.FaultConfig{ErrorRate: 1},
+	})
+	e := newTestServer(storage.NewResilientStorage(faulty, &config.ResilienceConfig{
+		BreakerThreshold:       1,
+		BreakerCooldownSeconds: 60,
+	}))
+
+	assert.Equal(t, http.StatusInternalServerError, get(e, "/simple/").Code)
+
+	// Once the circuit is open, clients are told to come back later.
+	assert.Equal(t, http.StatusServiceUnavailable, get(e, "/simple/").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, get(e, "/simple/foo/").Code)
+
+	// The health check doesn't require authentication, so it doesn't say why.
+	rec := get(e, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"message": "Storage is unavailable"}`, rec.Body.String())
+}
+
+func TestRoutes_QuotaExceeded(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage(), packageindex.WithQuotas(&config.QuotaConfig{
+		DefaultProject: config.QuotaLimit{MaxFiles: 1},
+	}))
+
+	rec := serve(e, uploadRequest(t, "foo", "1.0", "foo-1.0.tar.gz", "sdist"))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = serve(e, uploadRequest(t, "foo", "1.1", "foo-1.1.tar.gz", "sdist"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Contains(t, rec.Body.String(), "would have 2 of 1 files")
+}
+
+func TestRoutes_UploadPolicy(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage(), packageindex.WithUploadPolicy(&config.UploadPolicyConfig{
+		Default: config.UploadPolicyRule{ForbiddenPlatforms: []string{"linux_*"}, ForbidLocalVersions: true},
+	}))
+
+	rec := serve(e, uploadRequest(t, "foo", "1.0+local", "foo-1.0+local-cp312-cp312-linux_x86_64.whl", "bdist_wheel"))
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	var body HTTPError
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "Rejected by the upload policy", body.Message)
+	assert.Equal(t, []string{
+		"foo-1.0+local-cp312-cp312-linux_x86_64.whl: local versions such as 1.0+local can't be uploaded, upload 1.0 instead",
+		"foo-1.0+local-cp312-cp312-linux_x86_64.whl: wheels for the linux_x86_64 platform can't be uploaded, repair them into manylinux or musllinux wheels with auditwheel",
+	}, body.Errors)
+
+	rec = serve(e, uploadRequest(t, "foo", "1.0", "foo-1.0-cp312-cp312-manylinux_2_28_x86_64.whl", "bdist_wheel"))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestRoutes_SimpleVersions(t *testing.T) {
+	strg := storage.NewMemoryStorage()
+	e := newTestServer(strg)
+	for _, version := range []string{"1.0", "2.0rc1", "1.10", "1.9.post1"} {
+		rec := serve(e, uploadRequest(t, "foo", version, "foo-"+version+".tar.gz", "sdist"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	// Written directly, so not recorded and not parseable.
+	require.NoError(t, strg.WriteFile(context.Background(), "foo/foo-latest.tar.gz", strings.NewReader("sdist")))
+
+	req := httptest.NewRequest(http.MethodGet, "/simple/foo/", nil)
+	req.Header.Set(echo.HeaderAccept, "application/vnd.pypi.simple.v1+json, text/html; q=0.01")
+	rec := serve(e, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/vnd.pypi.simple.v1+json", rec.Header().Get(echo.HeaderContentType))
+
+	var detail SimpleProjectDetail
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &detail))
+	assert.Equal(t, "1.1", detail.Meta.APIVersion)
+	assert.Equal(t, []string{"2.0rc1", "1.10", "1.9.post1", "1.0"}, detail.Versions)
+	assert.Equal(t, []string{"foo-latest.tar.gz"}, detail.UnparseableFiles)
+	require.Len(t, detail.Files, 5)
+	assert.Equal(t, "foo-2.0rc1.tar.gz", detail.Files[0].FileName)
+	assert.Equal(t, "/simple/foo/foo-2.0rc1.tar.gz", detail.Files[0].URL)
+	assert.Len(t, detail.Files[0].Hashes["sha256"], 64)
+	assert.Equal(t, int64(5), *detail.Files[0].Size)
+	assert.NotEmpty(t, detail.Files[0].UploadTime)
+	assert.Equal(t, "foo-latest.tar.gz", detail.Files[4].FileName)
+	assert.Empty(t, detail.Files[4].Hashes)
+	assert.Nil(t, detail.Files[4].Size)
+
+	rec = get(e, "/simple/foo/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Less(t, strings.Index(body, "<h2>1.10</h2>"), strings.Index(body, "<h2>1.9.post1</h2>"))
+	assert.Less(t, strings.Index(body, "<h2>1.0</h2>"), strings.Index(body, "<h2>Files with unparseable versions</h2>"))
+	assert.Contains(t, body, `<meta name="pypi:repository-version" content="1.1">`)
+}
+
+func TestRoutes_SimpleNegotiation(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	tests := map[string]struct {
+		accept   string
+		format   string
+		wantCode int
+		wantType string
+	}{
+		"no preference":  {wantCode: http.StatusOK, wantType: "text/html; charset=UTF-8"},
+		"any":            {accept: "*/*", wantCode: http.StatusOK, wantType: "text/html; charset=UTF-8"},
+		"json":           {accept: "application/vnd.pypi.simple.v1+json", wantCode: http.StatusOK, wantType: "application/vnd.pypi.simple.v1+json"},
+		"quality":        {accept: "application/vnd.pypi.simple.v1+json;q=0.5, application/vnd.pypi.simple.v1+html", wantCode: http.StatusOK, wantType: "application/vnd.pypi.simple.v1+html"},
+		"format":         {accept: "text/html", format: "application/vnd.pypi.simple.v1+json", wantCode: http.StatusOK, wantType: "application/vnd.pypi.simple.v1+json"},
+		"unsupported":    {accept: "application/xml", wantCode: http.StatusNotAcceptable},
+		"refused":        {accept: "text/html;q=0", wantCode: http.StatusNotAcceptable},
+		"unknown format": {format: "xml", wantCode: http.StatusNotAcceptable},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			target := "/simple/"
+			if tt.format != "" {
+				target += "?format=" + url.QueryEscape(tt.format)
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.accept != "" {
+				req.Header.Set(echo.HeaderAccept, tt.accept)
+			}
+			rec := serve(e, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantType != "" {
+				assert.Equal(t, tt.wantType, rec.Header().Get(echo.HeaderContentType))
+			}
+			assert.Equal(t, echo.HeaderAccept, rec.Header().Get(echo.HeaderVary))
+		})
+	}
+}
+
+func TestRoutes_RequiresPython(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	for version, requiresPython := range map[string]string{"1.0": ">=3.8,<4", "2.0": ">=3.10"} {
+		req := uploadRequestWithFields(t, map[string]string{
+			"name":            "foo",
+			"version":         version,
+			"requires_python": requiresPython,
+		}, "foo-"+version+".tar.gz", "sdist")
+		rec := serve(e, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec := get(e, "/simple/foo/")
+	assert.Contains(t, rec.Body.String(), `data-requires-python="&gt;=3.8,&lt;4"`)
+
+	rec = get(e, "/simple/foo/?python_version=3.9")
+	assert.Contains(t, rec.Body.String(), "foo-1.0.tar.gz")
+	assert.NotContains(t, rec.Body.String(), "foo-2.0.tar.gz")
+	assert.Equal(t, http.StatusBadRequest, get(e, "/simple/foo/?python_version=three").Code)
+
+	rec = serve(e, uploadRequestWithFields(t, map[string]string{
+		"name":            "foo",
+		"version":         "3.0",
+		"requires_python": ">=3.8,",
+	}, "foo-3.0.tar.gz", "sdist"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Requires-Python")
+}
+
+func TestRoutes_Dependencies(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	for _, upload := range []struct{ name, version, requiresDist string }{
+		{"app", "1.0", `lib>=1.0; python_version < "3.11"`},
+		{"lib", "1.2", "app"},
+	} {
+		rec := serve(e, uploadRequestWithFields(t, map[string]string{
+			"name":          upload.name,
+			"version":       upload.version,
+			"requires_dist": upload.requiresDist,
+		}, upload.name+"-"+upload.version+".tar.gz", "sdist"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec := get(e, "/api/projects/app/1.0/dependencies")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"package":"lib","requirement":"lib>=1.0; python_version < \"3.11\"","specifiers":">=1.0","marker":"python_version < \"3.11\""}]`, rec.Body.String())
+
+	rec = get(e, "/api/projects/LIB/dependents")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"package":"app","version":"1.0","requirement":"lib>=1.0; python_version < \"3.11\"","specifiers":">=1.0"}]`, rec.Body.String())
+
+	rec = get(e, "/api/projects/app/1.0/dependencies/closure")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"root": "app==1.0",
+		"releases": {"app==1.0": ["lib==1.2"], "lib==1.2": ["app==1.0"]},
+		"cycles": [["app==1.0", "lib==1.2", "app==1.0"]]
+	}`, rec.Body.String())
+
+	rec = get(e, "/api/projects/app/1.0/dependencies/closure?python_version=3.12")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"root": "app==1.0", "releases": {"app==1.0": []}}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get(e, "/api/projects/app/1.0/dependencies/closure?python_version=x").Code)
+	assert.Equal(t, http.StatusNotFound, get(e, "/api/projects/app/2.0/dependencies").Code)
+	assert.Equal(t, http.StatusBadRequest, get(e, "/api/projects/-app/dependents").Code)
+}
+
+func TestRoutes_Resolve(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	for _, upload := range []struct{ name, version, requiresDist string }{
+		{"app", "1.0", "lib<2"},
+		{"lib", "1.0", ""},
+		{"lib", "2.0", ""},
+	} {
+		fields := map[string]string{"name": upload.name, "version": upload.version}
+		if upload.requiresDist != "" {
+			fields["requires_dist"] = upload.requiresDist
+		}
+		rec := serve(e, uploadRequestWithFields(t, fields, upload.name+"-"+upload.version+".tar.gz", "sdist"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	resolve := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/resolve", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		return serve(e, req)
+	}
+
+	rec := resolve(`{"requirements": ["app"], "python_version": "3.12", "allow_sdist": true}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res ResolveResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Files, 2)
+	assert.Equal(t, "lib-1.0.tar.gz", res.Files[1].FileName)
+	assert.Equal(t, "/simple/lib/lib-1.0.tar.gz", res.Files[1].URL)
+	assert.Len(t, res.Files[1].SHA256, 64)
+
+	rec = resolve(`{"requirements": ["app", "lib>=2"], "python_version": "3.12", "allow_sdist": true}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	var conflict ResolveConflictResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+	assert.Equal(t, "no version of lib satisfies lib>=2 (required by the request), lib<2 (required by app==1.0)", conflict.Conflict.Message)
+
+	// Without sdists, nothing can be installed.
+	assert.Equal(t, http.StatusConflict, resolve(`{"requirements": ["app"], "python_version": "3.12"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, resolve(`{"requirements": ["app"], "python_version": "x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, resolve(`{"requirements": ["-app"], "python_version": "3.12"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, resolve(`not json`).Code)
+}
+
+func TestRoutes_VerifyLock(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	rec := serve(e, uploadRequest(t, "foo", "1.0", "foo-1.0.tar.gz", "sdist"))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	verify := func(target, lock string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(lock))
+		req.Header.Set(echo.HeaderContentType, "application/toml")
+		return serve(e, req)
+	}
+	lock := `
+lock-version = "1.0"
+[[packages]]
+name = "foo"
+version = "1.0"
+sdist = { url = "%s/simple/foo/foo-1.0.tar.gz", hashes = { sha256 = "93a30bc3f9d1ec5f1a5e0c8aa8fdc3b45e0b2fd0a0e83ecd40d1b71e0b4b96fc" } }
+`
+
+	// httptest requests are sent to example.com.
+	rec = verify("/api/pylock/verify", strings.ReplaceAll(lock, "%s", "http://example.com"))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res VerifyLockResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.False(t, res.OK)
+	require.Len(t, res.HashMismatches, 1)
+	assert.Equal(t, "foo-1.0.tar.gz", res.HashMismatches[0].FileName)
+
+	rec = verify("/api/pylock/verify", strings.ReplaceAll(lock, "%s", "https://elsewhere.example.org"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	res = VerifyLockResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Len(t, res.Overridden, 1)
+
+	rec = verify("/api/pylock/verify?index_url=https://elsewhere.example.org", strings.ReplaceAll(lock, "%s", "https://elsewhere.example.org"))
+	res = VerifyLockResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Empty(t, res.Overridden)
+
+	assert.Equal(t, http.StatusBadRequest, verify("/api/pylock/verify", "lock-version = ").Code)
+}
+
+func TestRoutes_Yanked(t *testing.T) {
+	strg := storage.NewMemoryStorage()
+	e := newTestServer(strg)
+
+	for _, file := range []string{"foo-1.0.tar.gz", "foo-2.0.tar.gz"} {
+		rec := serve(e, uploadRequest(t, "foo", strings.TrimSuffix(file[4:], ".tar.gz"), file, "sdist"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	index := packageindex.NewIndex(strg)
+	require.NoError(t, index.YankFile(context.Background(), "foo", "foo-1.0.tar.gz", utils.Pointer("")))
+	require.NoError(t, index.YankFile(context.Background(), "foo", "foo-2.0.tar.gz", utils.Pointer("<broken>")))
+
+	rec := get(e, "/simple/foo/")
+	assert.Contains(t, rec.Body.String(), `data-yanked="">foo-1.0.tar.gz</a>`)
+	assert.Contains(t, rec.Body.String(), `data-yanked="&lt;broken&gt;">foo-2.0.tar.gz</a>`)
+
+	rec = get(e, "/simple/foo/?format="+url.QueryEscape("application/vnd.pypi.simple.v1+json"))
+	var detail SimpleProjectDetail
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &detail))
+	assert.Equal(t, "<broken>", detail.Files[0].Yanked)
+	assert.Equal(t, true, detail.Files[1].Yanked)
+}
+
+func TestRoutes_HashedRequirements(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	for _, file := range []string{"foo-1.0.tar.gz", "foo-1.0-py3-none-any.whl"} {
+		rec := serve(e, uploadRequest(t, "foo", "1.0", file, file))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	requirements := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/requirements", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		return serve(e, req)
+	}
+
+	rec := requirements(`{"pins": ["foo==1.0"]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, echo.MIMETextPlainCharsetUTF8, rec.Header().Get(echo.HeaderContentType))
+	lines := strings.Split(rec.Body.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, `foo==1.0 \`, lines[0])
+	assert.Regexp(t, `^    --hash=sha256:[0-9a-f]{64} \\$`, lines[1])
+	assert.Regexp(t, `^    --hash=sha256:[0-9a-f]{64}$`, lines[2])
+
+	rec = requirements(`{"pins": ["foo==1.0"], "platforms": ["manylinux_2_17_x86_64"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, strings.Count(rec.Body.String(), "--hash"))
+
+	assert.Equal(t, http.StatusNotFound, requirements(`{"pins": ["foo==2.0"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, requirements(`{"pins": ["foo>=1.0"]}`).Code)
+}
+
+func TestRoutes_BestWheels(t *testing.T) {
+	e := newTestServer(storage.NewMemoryStorage())
+
+	for _, file := range []string{
+		"Foo_Bar-1.0-cp312-cp312-manylinux_2_17_x86_64.whl",
+		"Foo_Bar-1.0-cp312-cp312-musllinux_1_1_x86_64.whl",
+		"Foo_Bar-0.9.tar.gz",
+	} {
+		rec := serve(e, uploadRequest(t, "Foo.Bar", strings.Split(file, "-")[1], file, file))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec := get(e, "/api/projects/foo-bar/wheels?python_version=3.12&platform=manylinux_2_28_x86_64&platform=macosx_14_0_arm64")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res BestWheelsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Versions, 2)
+	require.NotNil(t, res.Versions[0].File)
+	assert.Equal(t, "Foo_Bar-1.0-cp312-cp312-manylinux_2_17_x86_64.whl", res.Versions[0].File.FileName)
+	assert.Equal(t, "/simple/foo-bar/Foo_Bar-1.0-cp312-cp312-manylinux_2_17_x86_64.whl", res.Versions[0].File.URL)
+	assert.Equal(t, "cp312-cp312-manylinux_2_17_x86_64", res.Versions[0].File.Tag)
+	assert.Equal(t, []string{"macosx_14_0_arm64"}, res.Versions[0].MissingPlatforms)
+	assert.Nil(t, res.Versions[1].File)
+
+	assert.Equal(t, http.StatusBadRequest, get(e, "/api/projects/foo-bar/wheels").Code)
+	assert.Equal(t, http.StatusNotFound, get(e, "/api/projects/missing/wheels?python_version=3.12").Code)
+}
@@ -1,12 +1,18 @@
 package routes
 
 import (
+	"errors"
+	"html"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 
 	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/utils"
 )
 
 func SetupSimpleRoutes(e *echo.Echo, index packageindex.Index) {
@@ -15,19 +21,44 @@ func SetupSimpleRoutes(e *echo.Echo, index packageindex.Index) {
 	e.GET("/simple/:package/:file", DownloadFile(index))
 }
 
-// TODO: To support JSON API, add negotiation for Accept header
+const (
+	contentTypeSimpleJSON = "application/vnd.pypi.simple.v1+json"
+	contentTypeSimpleHTML = "application/vnd.pypi.simple.v1+html"
+	contentTypeTextHTML   = "text/html"
+
+	// simpleAPIVersion is 1.1 for the versions, size and upload-time fields of PEP 700.
+	simpleAPIVersion = "1.1"
+	simpleHTMLHead   = `<!DOCTYPE html><html><head><meta name="pypi:repository-version" content="` + simpleAPIVersion + `"></head><body>`
+)
 
 func ListPackages(index packageindex.Index) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		log.Ctx(c.Request().Context()).Debug().Msg("Listing packages")
 
+		contentType, ok := negotiateSimpleFormat(c)
+		if !ok {
+			return c.JSON(http.StatusNotAcceptable, &HTTPError{Message: "Unsupported content type"})
+		}
+
 		packages, err := index.ListPackages(c.Request().Context())
 		if err != nil {
 			log.Ctx(c.Request().Context()).Error().Err(err).Msg("Failed to list packages from database")
-			return c.JSON(http.StatusInternalServerError, &HTTPError{Message: "Failed to list packages", Errors: []string{err.Error()}})
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to list packages", Errors: []string{err.Error()}})
 		}
 
-		html := "<!DOCTYPE html><html><body>"
+		// c.HTML only sets text/html when no other type is set.
+		if contentType != contentTypeTextHTML {
+			c.Response().Header().Set(echo.HeaderContentType, contentType)
+		}
+		if contentType == contentTypeSimpleJSON {
+			projects := make([]SimpleProject, 0, len(packages))
+			for _, pkg := range packages {
+				projects = append(projects, SimpleProject{Name: pkg})
+			}
+			return c.JSON(http.StatusOK, &SimpleProjectList{Meta: SimpleMeta{APIVersion: simpleAPIVersion}, Projects: projects})
+		}
+
+		html := simpleHTMLHead
 		for _, pkg := range packages {
 			html += `<a href="/simple/` + pkg + `/">` + pkg + `</a>`
 		}
@@ -37,24 +68,196 @@ func ListPackages(index packageindex.Index) echo.HandlerFunc {
 	}
 }
 
+// ListPackageFiles lists the files of a package grouped by version, newest version first. Files whose
+// version can't be parsed are listed after them.
 func ListPackageFiles(index packageindex.Index) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		packageName := c.Param("package")
-		// Change to string builder if performance becomes an issue.
 
-		html := "<!DOCTYPE html><html><body>"
+		contentType, ok := negotiateSimpleFormat(c)
+		if !ok {
+			return c.JSON(http.StatusNotAcceptable, &HTTPError{Message: "Unsupported content type"})
+		}
+
 		files, err := index.ListPackageFiles(c.Request().Context(), packageName)
+		if errors.Is(err, packageindex.ErrInvalidPackageName) {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid package name", Errors: []string{err.Error()}})
+		}
 		if err != nil {
 			log.Ctx(c.Request().Context()).Error().Err(err).Msg("Failed to list package files")
-			return c.JSON(http.StatusInternalServerError, &HTTPError{Message: "Failed to list package files", Errors: []string{err.Error()}})
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to list package files", Errors: []string{err.Error()}})
 		}
-		for _, file := range files {
-			html += `<a href="/simple/` + packageName + `/` + file + `">` + file + `</a><br/>`
+		records, err := index.ListFileRecords(c.Request().Context(), packageName)
+		if err != nil {
+			log.Ctx(c.Request().Context()).Error().Err(err).Msg("Failed to list file records")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to list package files", Errors: []string{err.Error()}})
 		}
-		html += "</body></html>"
+		recordsByName := make(map[string]*packageindex.FileRecord, len(records))
+		for _, r := range records {
+			recordsByName[r.FileName] = r
+		}
+		if pythonVersion := c.QueryParam("python_version"); pythonVersion != "" {
+			v, err := utils.ParseVersion(pythonVersion)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid python_version", Errors: []string{err.Error()}})
+			}
+			files = filterRequiresPython(files, recordsByName, v)
+		}
+		groups, unparseable := packageindex.GroupFilesByVersion(files)
 
-		return c.HTML(http.StatusOK, html)
+		// c.HTML only sets text/html when no other type is set.
+		if contentType != contentTypeTextHTML {
+			c.Response().Header().Set(echo.HeaderContentType, contentType)
+		}
+		if contentType == contentTypeSimpleJSON {
+			return c.JSON(http.StatusOK, projectDetail(packageName, groups, unparseable, recordsByName))
+		}
+
+		// Change to string builder if performance becomes an issue.
+		body := simpleHTMLHead
+		for _, g := range groups {
+			body += "<h2>" + g.Version.String() + "</h2>"
+			for _, file := range g.Files {
+				body += fileAnchor(packageName, file, recordsByName[file])
+			}
+		}
+		if len(unparseable) > 0 {
+			body += "<h2>Files with unparseable versions</h2>"
+			for _, file := range unparseable {
+				body += fileAnchor(packageName, file, recordsByName[file])
+			}
+		}
+		body += "</body></html>"
+
+		return c.HTML(http.StatusOK, body)
+	}
+}
+
+// filterRequiresPython leaves out the files whose Requires-Python excludes the Python version. Files
+// without a record or with a malformed Requires-Python are kept, since they can't be ruled out.
+func filterRequiresPython(files []string, records map[string]*packageindex.FileRecord, pythonVersion *utils.Version) []string {
+	filtered := make([]string, 0, len(files))
+	for _, file := range files {
+		if r, ok := records[file]; ok && r.Metadata.RequiresPython != "" {
+			set, err := utils.ParseSpecifierSet(r.Metadata.RequiresPython)
+			// Like pip, pre-releases of Python are matched too.
+			if err == nil && !set.Contains(pythonVersion, true) {
+				continue
+			}
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
+
+func fileURL(packageName, file string) string {
+	return `/simple/` + packageName + `/` + file
+}
+
+// fileAnchor links a file, with its hash and Requires-Python if it is recorded. Files without records
+// are listed without them until they are reconciled.
+func fileAnchor(packageName, file string, r *packageindex.FileRecord) string {
+	href, attrs := fileURL(packageName, file), ""
+	if r != nil {
+		href += "#sha256=" + r.SHA256
+		if r.Metadata.RequiresPython != "" {
+			attrs = ` data-requires-python="` + html.EscapeString(r.Metadata.RequiresPython) + `"`
+		}
+		if r.Yanked != nil {
+			attrs += ` data-yanked="` + html.EscapeString(*r.Yanked) + `"`
+		}
 	}
+	return `<a href="` + href + `"` + attrs + `>` + file + `</a><br/>`
+}
+
+func projectDetail(
+	packageName string,
+	groups []*packageindex.VersionGroup,
+	unparseable []string,
+	records map[string]*packageindex.FileRecord,
+) *SimpleProjectDetail {
+	detail := &SimpleProjectDetail{
+		Meta:             SimpleMeta{APIVersion: simpleAPIVersion},
+		Name:             packageName,
+		Versions:         make([]string, 0, len(groups)),
+		Files:            []SimpleFile{},
+		UnparseableFiles: []string{},
+	}
+	addFile := func(file string) {
+		f := SimpleFile{FileName: file, URL: fileURL(packageName, file), Hashes: map[string]string{}}
+		if r, ok := records[file]; ok {
+			f.Hashes["sha256"] = r.SHA256
+			f.RequiresPython = r.Metadata.RequiresPython
+			f.Size = &r.Size
+			f.UploadTime = r.UploadedAt.UTC().Format("2006-01-02T15:04:05.000000Z")
+			if r.Yanked != nil {
+				f.Yanked = true
+				if *r.Yanked != "" {
+					f.Yanked = *r.Yanked
+				}
+			}
+		}
+		detail.Files = append(detail.Files, f)
+	}
+
+	for _, g := range groups {
+		detail.Versions = append(detail.Versions, g.Version.String())
+		for _, file := range g.Files {
+			addFile(file)
+		}
+	}
+	for _, file := range unparseable {
+		addFile(file)
+		detail.UnparseableFiles = append(detail.UnparseableFiles, file)
+	}
+	return detail
+}
+
+// negotiateSimpleFormat picks the content type of a simple API response from the format query parameter,
+// or else from the Accept header (PEP 691). HTML is picked when the client has no preference, so that
+// clients that don't know the JSON API keep working. It reports false if no supported type is acceptable.
+func negotiateSimpleFormat(c echo.Context) (string, bool) {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+
+	if format := c.QueryParam("format"); format != "" {
+		switch format {
+		case contentTypeSimpleJSON, contentTypeSimpleHTML, contentTypeTextHTML:
+			return format, true
+		}
+		return "", false
+	}
+
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if strings.TrimSpace(accept) == "" {
+		return contentTypeTextHTML, true
+	}
+
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		quality := 1.0
+		for _, param := range params[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		switch mediaType {
+		case contentTypeSimpleJSON, contentTypeSimpleHTML, contentTypeTextHTML:
+		case "text/*", "*/*":
+			mediaType = contentTypeTextHTML
+		default:
+			continue
+		}
+		// Earlier types win ties.
+		if quality > bestQuality {
+			best, bestQuality = mediaType, quality
+		}
+	}
+	return best, best != ""
 }
 
 func DownloadFile(index packageindex.Index) echo.HandlerFunc {
@@ -64,9 +267,15 @@ func DownloadFile(index packageindex.Index) echo.HandlerFunc {
 
 		log.Ctx(c.Request().Context()).Debug().Str("package", packageName).Str("file", fileName).Msg("Downloading file")
 		rc, err := index.DownloadFile(c.Request().Context(), packageName, fileName)
+		if errors.Is(err, packageindex.ErrInvalidPackageName) || errors.Is(err, packageindex.ErrInvalidFileName) {
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid file path", Errors: []string{err.Error()}})
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return c.JSON(http.StatusNotFound, &HTTPError{Message: "File not found"})
+		}
 		if err != nil {
 			log.Ctx(c.Request().Context()).Error().Err(err).Msg("Failed to read file")
-			return c.JSON(http.StatusInternalServerError, &HTTPError{Message: "Failed to read file", Errors: []string{err.Error()}})
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to read file", Errors: []string{err.Error()}})
 		}
 		defer rc.Close()
 
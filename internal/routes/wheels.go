@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/packageindex"
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+func SetupWheelRoutes(e *echo.Echo, index packageindex.Index) {
+	e.GET("/api/projects/:package/wheels", GetBestWheels(index))
+}
+
+// GetBestWheels returns the wheel of each version of a project that a target interpreter prefers,
+// given by the python_version, implementation and repeated platform query parameters.
+func GetBestWheels(index packageindex.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		packageName := c.Param("package")
+
+		versions, err := index.BestWheels(ctx, packageName, &packageindex.WheelTarget{
+			Implementation: c.QueryParam("implementation"),
+			PythonVersion:  c.QueryParam("python_version"),
+			Platforms:      c.QueryParams()["platform"],
+		})
+		switch {
+		case errors.Is(err, packageindex.ErrInvalidPackageName), errors.Is(err, packageindex.ErrInvalidWheelTarget):
+			return c.JSON(http.StatusBadRequest, &HTTPError{Message: "Invalid request", Errors: []string{err.Error()}})
+		case errors.Is(err, os.ErrNotExist):
+			return c.JSON(http.StatusNotFound, &HTTPError{Message: "Package not found"})
+		case err != nil:
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to pick wheels")
+			return c.JSON(errorStatus(err), &HTTPError{Message: "Failed to pick wheels", Errors: []string{err.Error()}})
+		}
+
+		res := BestWheelsResponse{Versions: make([]BestWheelsVersion, 0, len(versions))}
+		for _, v := range versions {
+			version := BestWheelsVersion{Version: v.Version, MissingPlatforms: v.MissingPlatforms}
+			if v.Best != nil {
+				version.File = &BestWheelFile{
+					FileName: v.Best.FileName,
+					URL:      fileURL(utils.NormalizePackageName(packageName), v.Best.FileName),
+					SHA256:   v.Best.SHA256,
+					Tag:      v.Tag,
+				}
+			}
+			res.Versions = append(res.Versions, version)
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
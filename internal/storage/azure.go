@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+type AzureStorage struct {
+	prefix            string
+	uploadBlockSize   int64
+	uploadConcurrency int
+	client            *container.Client
+}
+
+// NewAzureStorage creates a storage backed by a container in Azure Blob Storage.
+//
+// A SAS token takes precedence over a shared key. If neither is configured, a managed identity is used.
+func NewAzureStorage(cfg *config.AzureConfig) (*AzureStorage, error) {
+	serviceURL := cfg.ServiceURL
+	if serviceURL == "" {
+		if cfg.AccountName == "" {
+			return nil, errors.New("azure storage requires either service_url or account_name")
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.AccountName)
+	}
+	containerURL := strings.TrimSuffix(serviceURL, "/") + "/" + cfg.Container
+
+	var (
+		client *container.Client
+		err    error
+	)
+	switch {
+	case cfg.SASToken != "":
+		client, err = container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(cfg.SASToken, "?"), nil)
+	case cfg.AccountKey != "":
+		cred, credErr := container.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+		if credErr != nil {
+			return nil, credErr
+		}
+		client, err = container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	default:
+		opts := &azidentity.ManagedIdentityCredentialOptions{}
+		if cfg.ManagedIdentityClientID != "" {
+			opts.ID = azidentity.ClientID(cfg.ManagedIdentityClientID)
+		}
+		cred, credErr := azidentity.NewManagedIdentityCredential(opts)
+		if credErr != nil {
+			return nil, credErr
+		}
+		client, err = container.NewClient(containerURL, cred, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &AzureStorage{
+		prefix:            cfg.Prefix,
+		uploadBlockSize:   cfg.UploadBlockSize,
+		uploadConcurrency: cfg.UploadConcurrency,
+		client:            client,
+	}, nil
+}
+
+func (s *AzureStorage) ListPackages(ctx context.Context) ([]string, error) {
+	prefix := s.keyPrefix()
+	pager := s.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+
+	packages := []string{}
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Segment.BlobPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(*p.Name, prefix), "/")
+			if name != "" {
+				packages = append(packages, name)
+			}
+		}
+	}
+	return packages, nil
+}
+
+func (s *AzureStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	packageName, err := cleanPackageName(packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := s.keyPrefix() + packageName + "/"
+	pager := s.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+
+	files := []string{}
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			name := strings.TrimPrefix(*item.Name, prefix)
+			if name != "" {
+				files = append(files, name)
+			}
+		}
+	}
+	return files, nil
+}
+
+func (s *AzureStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	name, err := s.blobName(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.NewBlobClient(name).DownloadStream(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *AzureStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	name, err := s.blobName(filePath)
+	if err != nil {
+		return err
+	}
+
+	// Blocks are staged and only committed once the whole stream has been read,
+	// so an interrupted upload never leaves a truncated blob behind.
+	_, err = s.client.NewBlockBlobClient(name).UploadStream(ctx, content, &blockblob.UploadStreamOptions{
+		BlockSize:   s.uploadBlockSize,
+		Concurrency: s.uploadConcurrency,
+	})
+	return err
+}
+
+func (s *AzureStorage) DeleteFile(ctx context.Context, filePath string) error {
+	name, err := s.blobName(filePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.client.NewBlobClient(name).Delete(ctx, nil); err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return os.ErrNotExist
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *AzureStorage) Close() error {
+	return nil
+}
+
+func (s *AzureStorage) blobName(filePath string) (string, error) {
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.keyPrefix(), filePath), nil
+}
+
+// keyPrefix returns the blob name prefix with a trailing slash, or an empty string if no prefix is configured.
+func (s *AzureStorage) keyPrefix() string {
+	prefix := strings.Trim(s.prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
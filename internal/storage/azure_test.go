@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// Well-known development account of Azurite.
+// https://learn.microsoft.com/en-us/azure/storage/common/storage-use-azurite#well-known-storage-account-and-key
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFOCT6JmIwHn8EGlbCkLj0NQ=="
+)
+
+func TestAzureStorageAzurite(t *testing.T) {
+	// e.g. http://127.0.0.1:10000/devstoreaccount1
+	serviceURL := os.Getenv("AZURITE_BLOB_SERVICE_URL")
+	if serviceURL == "" {
+		t.Skip("AZURITE_BLOB_SERVICE_URL is not set")
+	}
+
+	storage, err := NewAzureStorage(&config.AzureConfig{
+		ServiceURL:        serviceURL,
+		AccountName:       azuriteAccountName,
+		AccountKey:        azuriteAccountKey,
+		Container:         "pypi-server-test",
+		Prefix:            "my-prefix",
+		UploadBlockSize:   1024 * 1024,
+		UploadConcurrency: 2,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = storage.client.Create(ctx, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _, _ = storage.client.Delete(context.Background(), nil) })
+
+	// Larger than a single block, so that the upload is split into several staged blocks.
+	content := make([]byte, 3*1024*1024+512)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+
+	writePath := "testpkg/testpkg-1.0.0-py3-none-any.whl"
+	require.NoError(t, storage.WriteFile(ctx, writePath, bytes.NewReader(content)))
+
+	pkgs, err := storage.ListPackages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"testpkg"}, pkgs)
+
+	files, err := storage.ListPackageFiles(ctx, "testpkg")
+	require.NoError(t, err)
+	require.Equal(t, []string{"testpkg-1.0.0-py3-none-any.whl"}, files)
+
+	reader, err := storage.ReadFile(ctx, writePath)
+	require.NoError(t, err)
+
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	require.NoError(t, storage.DeleteFile(ctx, writePath))
+
+	_, err = storage.ReadFile(ctx, writePath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, storage.DeleteFile(ctx, writePath), os.ErrNotExist)
+}
+
+func TestAzureStorageNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Ms-Error-Code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	storage, err := NewAzureStorage(&config.AzureConfig{
+		ServiceURL:  server.URL + "/" + azuriteAccountName,
+		AccountName: azuriteAccountName,
+		AccountKey:  azuriteAccountKey,
+		Container:   "pypi-server-test",
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = storage.ReadFile(ctx, "testpkg/missing.whl")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	err = storage.DeleteFile(ctx, "testpkg/missing.whl")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNewAzureStorageRequiresLocation(t *testing.T) {
+	_, err := NewAzureStorage(&config.AzureConfig{Container: "c", SASToken: "sv=2020"})
+	assert.ErrorContains(t, err, "account_name")
+}
@@ -0,0 +1,607 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+const (
+	// blobsDir holds file contents named by their sha256.
+	blobsDir = ".blobs"
+	// refcountsDir holds a blobRecord for every blob.
+	refcountsDir = ".refcounts"
+
+	// blobRefMagic starts every reference file. Distribution files are zip or gzip archives,
+	// so they never start with it.
+	blobRefMagic   = "#pypi-server-blob-ref\n"
+	maxBlobRefSize = 4096
+)
+
+// blobRef is stored at <package>/<file> in place of the file content.
+type blobRef struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// blobRecord tracks how many references point to a blob, and since when there are none.
+type blobRecord struct {
+	Count     int        `json:"count"`
+	Size      int64      `json:"size"`
+	ZeroSince *time.Time `json:"zero_since,omitempty"`
+}
+
+// GCReport summarizes a garbage collection run.
+type GCReport struct {
+	Blobs int
+	// Deleted lists the sha256 of deleted blobs.
+	Deleted    []string
+	FreedBytes int64
+	// Dangling lists references to blobs that don't exist.
+	Dangling []string
+}
+
+// ContentAddressedStorage stores every distinct file content once under its sha256, and keeps
+// a small reference at <package>/<file> instead. Identical uploads share the same blob.
+//
+// Reference counts are updated on every write and delete. Blobs without references are deleted
+// by the garbage collector once they have been unreferenced for the grace period, which keeps
+// a concurrent upload of the same content from losing its blob. The collector recomputes the
+// counts from the references, so counts left behind by a crash are corrected on the next run.
+//
+// Files written before the layout was enabled are still served as they are, and can be
+// converted with MigrateLayout.
+type ContentAddressedStorage struct {
+	backend     Storage
+	gracePeriod time.Duration
+
+	// mu serializes updates of references and reference counts.
+	mu sync.Mutex
+	// gcMu serializes garbage collection runs, which share touched.
+	gcMu sync.Mutex
+	// touched collects blobs whose count changed while the garbage collector was counting references.
+	touched map[string]struct{}
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewContentAddressedStorage(backend Storage, cfg *config.ContentAddressedConfig) *ContentAddressedStorage {
+	log.Info().Msg("Using content addressed storage layout")
+
+	ctx, stop := context.WithCancel(context.Background())
+	s := &ContentAddressedStorage{
+		backend:     backend,
+		gracePeriod: time.Duration(cfg.GCGracePeriodSeconds) * time.Second,
+		stop:        stop,
+		done:        make(chan struct{}),
+	}
+	go s.gcLoop(ctx, time.Duration(cfg.GCIntervalSeconds)*time.Second)
+	return s
+}
+
+func (s *ContentAddressedStorage) ListPackages(ctx context.Context) ([]string, error) {
+	packages, err := s.backend.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(packages, isBlobsInternal), nil
+}
+
+func (s *ContentAddressedStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if isBlobsInternal(packageName) {
+		return []string{}, nil
+	}
+	return s.backend.ListPackageFiles(ctx, packageName)
+}
+
+func (s *ContentAddressedStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	filePath, err := s.cleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(rc)
+	ref, err := readBlobRef(br)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	if ref == nil {
+		// Written before the layout was enabled.
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, rc}, nil
+	}
+	_ = rc.Close()
+
+	blob, err := s.backend.ReadFile(ctx, blobPath(ref.SHA256))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Ctx(ctx).Error().Str("path", filePath).Str("sha256", ref.SHA256).Msg("Reference points to a missing blob")
+	}
+	return blob, err
+}
+
+func (s *ContentAddressedStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	filePath, err := s.cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	spool, size, err := spoolToTemp(ctx, io.TeeReader(content, h))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	if err := s.ensureBlob(ctx, sum, spool, size); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.readRef(ctx, filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if old != nil && old.SHA256 == sum {
+		return nil
+	}
+
+	record, err := s.readRecord(ctx, sum)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if record == nil || record.Count == 0 {
+		// The garbage collector may have deleted the blob since it was checked.
+		if err := s.ensureBlob(ctx, sum, spool, size); err != nil {
+			return err
+		}
+	}
+
+	if err := s.addRefcountLocked(ctx, sum, size, 1); err != nil {
+		return err
+	}
+	if err := s.writeRef(ctx, filePath, blobRef{SHA256: sum, Size: size}); err != nil {
+		return err
+	}
+	if old != nil {
+		if err := s.addRefcountLocked(ctx, old.SHA256, old.Size, -1); err != nil {
+			// The garbage collector corrects the count.
+			log.Ctx(ctx).Warn().Err(err).Str("sha256", old.SHA256).Msg("Failed to decrement reference count")
+		}
+	}
+	return nil
+}
+
+func (s *ContentAddressedStorage) DeleteFile(ctx context.Context, filePath string) error {
+	filePath, err := s.cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref, err := s.readRef(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	if err := s.backend.DeleteFile(ctx, filePath); err != nil {
+		return err
+	}
+	if ref != nil {
+		if err := s.addRefcountLocked(ctx, ref.SHA256, ref.Size, -1); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("sha256", ref.SHA256).Msg("Failed to decrement reference count")
+		}
+	}
+	return nil
+}
+
+func (s *ContentAddressedStorage) Close() error {
+	s.stop()
+	<-s.done
+	return s.backend.Close()
+}
+
+func (s *ContentAddressedStorage) Watch(ctx context.Context, fn func(packageName string)) error {
+	return watchBackend(ctx, s.backend, fn)
+}
+
+func (s *ContentAddressedStorage) Health() error {
+	return checkBackendHealth(s.backend)
+}
+
+// GC deletes blobs that have been unreferenced for longer than the grace period and rewrites
+// reference counts that don't match the references.
+//
+// References are counted without holding the lock, so uploads and deletes keep going during a
+// run. The lock is only taken to update or delete a single blob, after checking that nothing
+// touched it since counting started.
+func (s *ContentAddressedStorage) GC(ctx context.Context) (*GCReport, error) {
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	s.mu.Lock()
+	s.touched = map[string]struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.touched = nil
+		s.mu.Unlock()
+	}()
+
+	blobs, err := s.backend.ListPackageFiles(ctx, blobsDir)
+	if err != nil {
+		return nil, err
+	}
+	blobSet := make(map[string]struct{}, len(blobs))
+	for _, sum := range blobs {
+		blobSet[sum] = struct{}{}
+	}
+
+	counts, dangling, err := s.countRefs(ctx, blobSet)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{Blobs: len(blobs)}
+	now := time.Now()
+	for _, sum := range blobs {
+		deleted, size, err := s.collectBlob(ctx, sum, counts[sum], now)
+		if err != nil {
+			return report, err
+		}
+		if deleted {
+			log.Ctx(ctx).Info().Str("sha256", sum).Int64("size", size).Msg("Deleted unreferenced blob")
+			report.Deleted = append(report.Deleted, sum)
+			report.FreedBytes += size
+		}
+	}
+
+	// Drop records of blobs that no longer exist.
+	records, err := s.backend.ListPackageFiles(ctx, refcountsDir)
+	if err != nil {
+		return report, err
+	}
+	for _, sum := range records {
+		if _, ok := blobSet[sum]; ok {
+			continue
+		}
+		if err := s.dropRecord(ctx, sum); err != nil {
+			return report, err
+		}
+	}
+
+	report.Dangling = dangling
+	for _, p := range dangling {
+		log.Ctx(ctx).Error().Str("path", p).Msg("Reference points to a missing blob")
+	}
+	return report, nil
+}
+
+// collectBlob corrects the record of a blob that has count references, and deletes the blob once
+// it has been unreferenced for the grace period. It reports whether the blob was deleted, and its size.
+func (s *ContentAddressedStorage) collectBlob(ctx context.Context, sum string, count int, now time.Time) (bool, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Counts of blobs that were referenced or dereferenced while counting are not reliable.
+	if _, ok := s.touched[sum]; ok {
+		return false, 0, nil
+	}
+
+	record, err := s.readRecord(ctx, sum)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, 0, err
+	}
+
+	switch {
+	case count > 0:
+		if record == nil || record.Count != count || record.ZeroSince != nil {
+			size := int64(0)
+			if record != nil {
+				size = record.Size
+			}
+			return false, 0, s.writeRecord(ctx, sum, blobRecord{Count: count, Size: size})
+		}
+	case record != nil && record.ZeroSince != nil && now.Sub(*record.ZeroSince) >= s.gracePeriod:
+		if err := s.backend.DeleteFile(ctx, blobPath(sum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, 0, err
+		}
+		if err := s.backend.DeleteFile(ctx, recordPath(sum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, 0, err
+		}
+		return true, record.Size, nil
+	case record == nil || record.ZeroSince == nil:
+		r := blobRecord{ZeroSince: &now}
+		if record != nil {
+			r.Size = record.Size
+		}
+		return false, 0, s.writeRecord(ctx, sum, r)
+	}
+	return false, 0, nil
+}
+
+// dropRecord deletes the record of a blob that doesn't exist, unless an upload wrote it since counting started.
+func (s *ContentAddressedStorage) dropRecord(ctx context.Context, sum string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.touched[sum]; ok {
+		return nil
+	}
+	if err := s.backend.DeleteFile(ctx, recordPath(sum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MigrateLayout moves files written before the layout was enabled into blobs. It returns the
+// paths of the converted files.
+func (s *ContentAddressedStorage) MigrateLayout(ctx context.Context) ([]string, error) {
+	packages, err := s.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := []string{}
+	for _, pkg := range packages {
+		files, err := s.backend.ListPackageFiles(ctx, pkg)
+		if err != nil {
+			return converted, err
+		}
+
+		for _, f := range files {
+			filePath := path.Join(pkg, f)
+			ref, err := s.readRef(ctx, filePath)
+			if err != nil {
+				return converted, err
+			}
+			if ref != nil {
+				continue
+			}
+
+			rc, err := s.backend.ReadFile(ctx, filePath)
+			if err != nil {
+				return converted, err
+			}
+			err = s.WriteFile(ctx, filePath, rc)
+			_ = rc.Close()
+			if err != nil {
+				return converted, err
+			}
+			converted = append(converted, filePath)
+		}
+	}
+	return converted, nil
+}
+
+// countRefs counts the references to every blob, and lists references to blobs missing from blobs.
+func (s *ContentAddressedStorage) countRefs(ctx context.Context, blobs map[string]struct{}) (map[string]int, []string, error) {
+	packages, err := s.ListPackages(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counts := map[string]int{}
+	dangling := []string{}
+	for _, pkg := range packages {
+		files, err := s.backend.ListPackageFiles(ctx, pkg)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range files {
+			filePath := path.Join(pkg, f)
+			ref, err := s.readRef(ctx, filePath)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if ref == nil {
+				continue
+			}
+			counts[ref.SHA256]++
+			if _, ok := blobs[ref.SHA256]; !ok {
+				dangling = append(dangling, filePath)
+			}
+		}
+	}
+	return counts, dangling, nil
+}
+
+func (s *ContentAddressedStorage) gcLoop(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := s.GC(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Msg("Blob garbage collection failed")
+			}
+			continue
+		}
+		log.Info().Int("blobs", report.Blobs).Int("deleted", len(report.Deleted)).Int64("freed_bytes", report.FreedBytes).Msg("Collected unreferenced blobs")
+	}
+}
+
+// ensureBlob uploads the content unless a blob with the same sha256 already exists.
+func (s *ContentAddressedStorage) ensureBlob(ctx context.Context, sum string, spool *os.File, size int64) error {
+	rc, err := s.backend.ReadFile(ctx, blobPath(sum))
+	if err == nil {
+		return rc.Close()
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.backend.WriteFile(ctx, blobPath(sum), io.NewSectionReader(spool, 0, size))
+}
+
+func (s *ContentAddressedStorage) addRefcountLocked(ctx context.Context, sum string, size int64, delta int) error {
+	if s.touched != nil {
+		s.touched[sum] = struct{}{}
+	}
+
+	record, err := s.readRecord(ctx, sum)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if record == nil {
+		record = &blobRecord{}
+	}
+
+	record.Count = max(record.Count+delta, 0)
+	record.Size = size
+	record.ZeroSince = nil
+	if record.Count == 0 {
+		now := time.Now()
+		record.ZeroSince = &now
+	}
+	return s.writeRecord(ctx, sum, *record)
+}
+
+// readRef returns the reference stored at the path, or nil if the path holds file content.
+func (s *ContentAddressedStorage) readRef(ctx context.Context, filePath string) (*blobRef, error) {
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return readBlobRef(bufio.NewReader(rc))
+}
+
+func (s *ContentAddressedStorage) writeRef(ctx context.Context, filePath string, ref blobRef) error {
+	b, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return s.backend.WriteFile(ctx, filePath, io.MultiReader(strings.NewReader(blobRefMagic), bytes.NewReader(b)))
+}
+
+func (s *ContentAddressedStorage) readRecord(ctx context.Context, sum string) (*blobRecord, error) {
+	rc, err := s.backend.ReadFile(ctx, recordPath(sum))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var record blobRecord
+	if err := json.NewDecoder(io.LimitReader(rc, maxBlobRefSize)).Decode(&record); err != nil {
+		return nil, fmt.Errorf("invalid reference count of %s: %w", sum, err)
+	}
+	return &record, nil
+}
+
+func (s *ContentAddressedStorage) writeRecord(ctx context.Context, sum string, record blobRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.backend.WriteFile(ctx, recordPath(sum), bytes.NewReader(b))
+}
+
+// cleanPath rejects paths inside the internal directories, so that blobs can't be modified directly.
+func (s *ContentAddressedStorage) cleanPath(filePath string) (string, error) {
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	packageName, _, _ := strings.Cut(filePath, "/")
+	if isBlobsInternal(packageName) {
+		return "", ErrInvalidPath
+	}
+	return filePath, nil
+}
+
+// readBlobRef parses a reference, or returns nil without consuming anything if the content is not one.
+func readBlobRef(r *bufio.Reader) (*blobRef, error) {
+	magic, err := r.Peek(len(blobRefMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if string(magic) != blobRefMagic {
+		return nil, nil //nolint:nilnil // Not being a reference is not an error.
+	}
+
+	if _, err := r.Discard(len(blobRefMagic)); err != nil {
+		return nil, err
+	}
+	var ref blobRef
+	if err := json.NewDecoder(io.LimitReader(r, maxBlobRefSize)).Decode(&ref); err != nil {
+		return nil, fmt.Errorf("invalid blob reference: %w", err)
+	}
+	if _, err := hex.DecodeString(ref.SHA256); err != nil || len(ref.SHA256) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid blob reference: %q", ref.SHA256)
+	}
+	return &ref, nil
+}
+
+func isBlobsInternal(packageName string) bool {
+	return packageName == blobsDir || packageName == refcountsDir
+}
+
+func blobPath(sum string) string {
+	return path.Join(blobsDir, sum)
+}
+
+func recordPath(sum string) string {
+	return path.Join(refcountsDir, sum)
+}
+
+// spoolToTemp copies the content to a temporary file, so that it can be read more than once.
+// The caller must close and remove the file.
+func spoolToTemp(ctx context.Context, content io.Reader) (*os.File, int64, error) {
+	spool, err := os.CreateTemp("", "pypi-server-spool-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := io.Copy(spool, contextReader{ctx: ctx, r: content})
+	if err != nil {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+		return nil, 0, err
+	}
+	return spool, size, nil
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+func newTestContentAddressedStorage(t *testing.T, backend Storage) *ContentAddressedStorage {
+	t.Helper()
+
+	storage := NewContentAddressedStorage(backend, &config.ContentAddressedConfig{})
+	t.Cleanup(func() { _ = storage.Close() })
+	return storage
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func readRecordCount(t *testing.T, s *ContentAddressedStorage, sum string) int {
+	t.Helper()
+
+	record, err := s.readRecord(t.Context(), sum)
+	require.NoError(t, err)
+	return record.Count
+}
+
+func TestContentAddressedStorage_Deduplicates(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newTestContentAddressedStorage(t, backend)
+
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0-py3-none-any.whl", bytes.NewBufferString("wheel")))
+	require.NoError(t, storage.WriteFile(t.Context(), "bar/foo-1.0-py3-none-any.whl", bytes.NewBufferString("wheel")))
+	// Writing the same content again doesn't add a reference.
+	require.NoError(t, storage.WriteFile(t.Context(), "bar/foo-1.0-py3-none-any.whl", bytes.NewBufferString("wheel")))
+
+	blobs, err := backend.ListPackageFiles(t.Context(), blobsDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{sha256Hex("wheel")}, blobs)
+	assert.Equal(t, 2, readRecordCount(t, storage, sha256Hex("wheel")))
+
+	assert.Equal(t, "wheel", readAll(t, storage, "foo/foo-1.0-py3-none-any.whl"))
+	assert.Equal(t, "wheel", readAll(t, storage, "bar/foo-1.0-py3-none-any.whl"))
+
+	packages, err := storage.ListPackages(t.Context())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, packages)
+
+	_, err = storage.ReadFile(t.Context(), blobPath(sha256Hex("wheel")))
+	require.ErrorIs(t, err, ErrInvalidPath)
+}
+
+func TestContentAddressedStorage_GC(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newTestContentAddressedStorage(t, backend)
+
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("v1")))
+	require.NoError(t, storage.WriteFile(t.Context(), "bar/bar-1.0.tar.gz", bytes.NewBufferString("v1")))
+	// Overwriting dereferences the old content.
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("v2")))
+	assert.Equal(t, 1, readRecordCount(t, storage, sha256Hex("v1")))
+
+	require.NoError(t, storage.DeleteFile(t.Context(), "bar/bar-1.0.tar.gz"))
+	assert.Equal(t, 0, readRecordCount(t, storage, sha256Hex("v1")))
+
+	// Unreferenced blobs are kept during the grace period.
+	storage.gracePeriod = time.Hour
+	report, err := storage.GC(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Blobs)
+	assert.Empty(t, report.Deleted)
+
+	storage.gracePeriod = 0
+	report, err = storage.GC(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{sha256Hex("v1")}, report.Deleted)
+	assert.Equal(t, int64(2), report.FreedBytes)
+
+	_, err = backend.ReadFile(t.Context(), blobPath(sha256Hex("v1")))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, "v2", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	// Re-uploading collected content stores the blob again.
+	require.NoError(t, storage.WriteFile(t.Context(), "bar/bar-1.0.tar.gz", bytes.NewBufferString("v1")))
+	assert.Equal(t, "v1", readAll(t, storage, "bar/bar-1.0.tar.gz"))
+}
+
+func TestContentAddressedStorage_GCFixesCounts(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newTestContentAddressedStorage(t, backend)
+
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+	// Simulate a crash that left a wrong count and a reference to a missing blob.
+	require.NoError(t, storage.writeRecord(t.Context(), sha256Hex("foo"), blobRecord{Count: 5, Size: 3}))
+	require.NoError(t, storage.writeRef(t.Context(), "bar/bar-1.0.tar.gz", blobRef{SHA256: sha256Hex("missing"), Size: 7}))
+
+	report, err := storage.GC(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, report.Deleted)
+	assert.Equal(t, []string{"bar/bar-1.0.tar.gz"}, report.Dangling)
+	assert.Equal(t, 1, readRecordCount(t, storage, sha256Hex("foo")))
+
+	_, err = storage.ReadFile(t.Context(), "bar/bar-1.0.tar.gz")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// listBlockingStorage holds listings of one package until release is closed.
+type listBlockingStorage struct {
+	Storage
+
+	packageName string
+	started     chan struct{}
+	release     chan struct{}
+}
+
+func (s *listBlockingStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if packageName == s.packageName {
+		select {
+		case s.started <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	return s.Storage.ListPackageFiles(ctx, packageName)
+}
+
+func TestContentAddressedStorage_GCDoesNotBlockWrites(t *testing.T) {
+	backend := &listBlockingStorage{Storage: NewMemoryStorage(), packageName: refcountsDir, started: make(chan struct{}, 1), release: make(chan struct{})}
+	storage := newTestContentAddressedStorage(t, backend)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := storage.GC(t.Context())
+		done <- err
+	}()
+
+	// The collector is past the blobs and lists the records, uploads still go through.
+	<-backend.started
+	require.NoError(t, storage.WriteFile(t.Context(), "bar/bar-1.0.tar.gz", bytes.NewBufferString("bar")))
+	close(backend.release)
+	require.NoError(t, <-done)
+
+	// The record of the blob written during the run is kept.
+	assert.Equal(t, 1, readRecordCount(t, storage, sha256Hex("bar")))
+	assert.Equal(t, "bar", readAll(t, storage, "bar/bar-1.0.tar.gz"))
+}
+
+func TestContentAddressedStorage_MigrateLayout(t *testing.T) {
+	backend := NewMemoryStorage()
+	require.NoError(t, backend.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("same")))
+	require.NoError(t, backend.WriteFile(t.Context(), "bar/bar-1.0.tar.gz", bytes.NewBufferString("same")))
+
+	storage := newTestContentAddressedStorage(t, backend)
+	// Files from before the migration are served as they are.
+	assert.Equal(t, "same", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	converted, err := storage.MigrateLayout(t.Context())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo/foo-1.0.tar.gz", "bar/bar-1.0.tar.gz"}, converted)
+	assert.Equal(t, 2, readRecordCount(t, storage, sha256Hex("same")))
+	assert.Equal(t, "same", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	ref, err := storage.readRef(t.Context(), "bar/bar-1.0.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, &blobRef{SHA256: sha256Hex("same"), Size: 4}, ref)
+
+	converted, err = storage.MigrateLayout(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, converted)
+}
@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// CachedStorage keeps recently read files on local disk and caches listings for a short time.
+//
+// Artifacts are never modified once uploaded, so cached files are served without revalidation.
+// Writes and deletes made through the cache invalidate the affected entries. Changes made to the
+// backend by other processes show up in listings once the listing TTL expires.
+type CachedStorage struct {
+	backend    Storage
+	root       *os.Root
+	maxSize    int64
+	listingTTL time.Duration
+
+	mu sync.Mutex
+	// lru holds *cacheEntry values, most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+	// generation is bumped on every invalidation, so that reads started before a write
+	// don't put stale content back into the cache.
+	generation uint64
+
+	packages *cachedListing
+	files    map[string]*cachedListing
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+type cachedListing struct {
+	values    []string
+	expiresAt time.Time
+}
+
+func NewCachedStorage(backend Storage, cfg *config.CacheConfig) (*CachedStorage, error) {
+	log.Info().Msgf("Using local cache at path: %s", cfg.Path)
+
+	if err := os.MkdirAll(cfg.Path, 0750); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &CachedStorage{
+		backend:    backend,
+		root:       root,
+		maxSize:    cfg.MaxSizeBytes,
+		listingTTL: time.Duration(cfg.ListingTTLSeconds) * time.Second,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+		files:      map[string]*cachedListing{},
+	}
+	if err := s.load(); err != nil {
+		_ = root.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *CachedStorage) ListPackages(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if l := s.packages; l != nil && time.Now().Before(l.expiresAt) {
+		s.mu.Unlock()
+		return slices.Clone(l.values), nil
+	}
+	generation := s.generation
+	s.mu.Unlock()
+
+	packages, err := s.backend.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.generation == generation {
+		s.packages = &cachedListing{values: slices.Clone(packages), expiresAt: time.Now().Add(s.listingTTL)}
+	}
+	s.mu.Unlock()
+
+	return packages, nil
+}
+
+func (s *CachedStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if l, ok := s.files[packageName]; ok && time.Now().Before(l.expiresAt) {
+		s.mu.Unlock()
+		return slices.Clone(l.values), nil
+	}
+	generation := s.generation
+	s.mu.Unlock()
+
+	files, err := s.backend.ListPackageFiles(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.generation == generation {
+		s.files[packageName] = &cachedListing{values: slices.Clone(files), expiresAt: time.Now().Add(s.listingTTL)}
+	}
+	s.mu.Unlock()
+
+	return files, nil
+}
+
+func (s *CachedStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	name := cacheFileName(filePath)
+
+	s.mu.Lock()
+	// Taken before reading the backend, so that a write landing during the read keeps its content out.
+	generation := s.generation
+	if elem, ok := s.entries[name]; ok {
+		s.lru.MoveToFront(elem)
+		// Evicted files stay readable through the open handle, so it is fine to release the lock here.
+		f, err := s.root.Open(name)
+		s.mu.Unlock()
+		if err == nil {
+			return f, nil
+		}
+		log.Ctx(ctx).Warn().Err(err).Str("path", filePath).Msg("Failed to open cached file, reading from backend")
+	} else {
+		s.mu.Unlock()
+	}
+
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	tempName, err := newTempName(name)
+	if err == nil {
+		f, err = s.root.OpenFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+	if err != nil {
+		// Caching is best effort, still serve the file.
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to create cache file")
+		return rc, nil
+	}
+
+	return &cachingReader{
+		storage:    s,
+		source:     rc,
+		temp:       f,
+		tempName:   tempName,
+		name:       name,
+		generation: generation,
+	}, nil
+}
+
+func (s *CachedStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	defer s.invalidate(filePath)
+	return s.backend.WriteFile(ctx, filePath, content)
+}
+
+func (s *CachedStorage) DeleteFile(ctx context.Context, filePath string) error {
+	defer s.invalidate(filePath)
+	return s.backend.DeleteFile(ctx, filePath)
+}
+
+func (s *CachedStorage) Close() error {
+	return errors.Join(s.backend.Close(), s.root.Close())
+}
+
+// Watch forwards changes reported by the backend, and drops the cached listings of changed packages.
+func (s *CachedStorage) Watch(ctx context.Context, fn func(packageName string)) error {
+	return watchBackend(ctx, s.backend, func(packageName string) {
+		s.invalidate(packageName)
+		fn(packageName)
+	})
+}
+
+func (s *CachedStorage) Health() error {
+	return checkBackendHealth(s.backend)
+}
+
+// invalidate drops the cached file and every listing that might include it.
+func (s *CachedStorage) invalidate(filePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	s.packages = nil
+	packageName, _, _ := strings.Cut(path.Clean(filePath), "/")
+	delete(s.files, packageName)
+
+	name := cacheFileName(filePath)
+	if elem, ok := s.entries[name]; ok {
+		s.removeLocked(elem)
+	}
+}
+
+// load indexes files cached by a previous run, so that the cache survives restarts.
+func (s *CachedStorage) load() error {
+	d, err := s.root.Open(".")
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	entries, err := d.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	files := make([]loaded, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), tempFilePrefix) {
+			_ = s.root.Remove(e.Name())
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, loaded{name: e.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+
+	// Most recently written first.
+	slices.SortFunc(files, func(a, b loaded) int { return b.modTime.Compare(a.modTime) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range files {
+		s.entries[f.name] = s.lru.PushBack(&cacheEntry{name: f.name, size: f.size})
+		s.size += f.size
+	}
+	s.evictLocked()
+	return nil
+}
+
+func (s *CachedStorage) add(tempName, name string, size int64, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.generation != generation || size > s.maxSize {
+		_ = s.root.Remove(tempName)
+		return
+	}
+
+	if err := s.root.Rename(tempName, name); err != nil {
+		log.Warn().Err(err).Msg("Failed to store file in cache")
+		_ = s.root.Remove(tempName)
+		return
+	}
+
+	if elem, ok := s.entries[name]; ok {
+		entry, _ := elem.Value.(*cacheEntry)
+		s.lru.Remove(elem)
+		s.size -= entry.size
+	}
+	s.entries[name] = s.lru.PushFront(&cacheEntry{name: name, size: size})
+	s.size += size
+	s.evictLocked()
+}
+
+func (s *CachedStorage) evictLocked() {
+	for s.size > s.maxSize && s.lru.Len() > 0 {
+		s.removeLocked(s.lru.Back())
+	}
+}
+
+func (s *CachedStorage) removeLocked(elem *list.Element) {
+	entry, _ := elem.Value.(*cacheEntry)
+	s.lru.Remove(elem)
+	delete(s.entries, entry.name)
+	s.size -= entry.size
+	if err := s.root.Remove(entry.name); err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("name", entry.name).Msg("Failed to remove cached file")
+	}
+}
+
+func cacheFileName(filePath string) string {
+	sum := sha256.Sum256([]byte(path.Clean(filePath)))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachingReader copies everything read from the backend into a temporary file, which is
+// moved into the cache once the whole file has been read successfully.
+type cachingReader struct {
+	storage    *CachedStorage
+	source     io.ReadCloser
+	temp       *os.File
+	tempName   string
+	name       string
+	generation uint64
+
+	written  int64
+	complete bool
+	failed   bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	if n > 0 && !r.failed {
+		if _, werr := r.temp.Write(p[:n]); werr != nil {
+			r.failed = true
+		}
+		r.written += int64(n)
+	}
+	if errors.Is(err, io.EOF) {
+		r.complete = true
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	err := r.source.Close()
+
+	if cerr := r.temp.Close(); cerr != nil {
+		r.failed = true
+	}
+	if err != nil || r.failed || !r.complete {
+		_ = r.storage.root.Remove(r.tempName)
+		return err
+	}
+
+	r.storage.add(r.tempName, r.name, r.written, r.generation)
+	return nil
+}
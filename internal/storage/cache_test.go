@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// countingStorage counts the calls that reach the wrapped storage.
+type countingStorage struct {
+	Storage
+
+	reads    atomic.Int64
+	listings atomic.Int64
+}
+
+func (s *countingStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	s.reads.Add(1)
+	return s.Storage.ReadFile(ctx, filePath)
+}
+
+func (s *countingStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	s.listings.Add(1)
+	return s.Storage.ListPackageFiles(ctx, packageName)
+}
+
+func newTestCachedStorage(t *testing.T, backend Storage, cfg config.CacheConfig) *CachedStorage {
+	t.Helper()
+
+	if cfg.Path == "" {
+		cfg.Path = t.TempDir()
+	}
+	if cfg.MaxSizeBytes == 0 {
+		cfg.MaxSizeBytes = 1024 * 1024
+	}
+	s, err := NewCachedStorage(backend, &cfg)
+	require.NoError(t, err)
+	return s
+}
+
+func readAll(t *testing.T, s Storage, filePath string) string {
+	t.Helper()
+
+	rc, err := s.ReadFile(context.Background(), filePath)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCachedStorageServesFromCache(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewMemoryStorage()}
+	require.NoError(t, backend.WriteFile(ctx, "pkg/a.whl", strings.NewReader("content of a")))
+
+	cacheDir := t.TempDir()
+	s := newTestCachedStorage(t, backend, config.CacheConfig{Path: cacheDir})
+
+	assert.Equal(t, "content of a", readAll(t, s, "pkg/a.whl"))
+	assert.Equal(t, "content of a", readAll(t, s, "pkg/a.whl"))
+	assert.Equal(t, int64(1), backend.reads.Load())
+
+	// The cache survives restarts.
+	restarted := newTestCachedStorage(t, backend, config.CacheConfig{Path: cacheDir})
+	assert.Equal(t, "content of a", readAll(t, restarted, "pkg/a.whl"))
+	assert.Equal(t, int64(1), backend.reads.Load())
+}
+
+func TestCachedStorageIgnoresPartialReads(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewMemoryStorage()}
+	require.NoError(t, backend.WriteFile(ctx, "pkg/a.whl", strings.NewReader("content of a")))
+
+	s := newTestCachedStorage(t, backend, config.CacheConfig{})
+
+	rc, err := s.ReadFile(ctx, "pkg/a.whl")
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 3))
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	assert.Equal(t, "content of a", readAll(t, s, "pkg/a.whl"))
+	assert.Equal(t, int64(2), backend.reads.Load())
+}
+
+func TestCachedStorageInvalidatesOnWrite(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewMemoryStorage()}
+	s := newTestCachedStorage(t, backend, config.CacheConfig{ListingTTLSeconds: 60})
+
+	require.NoError(t, s.WriteFile(ctx, "pkg/a.whl", strings.NewReader("first")))
+	assert.Equal(t, "first", readAll(t, s, "pkg/a.whl"))
+
+	files, err := s.ListPackageFiles(ctx, "pkg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.whl"}, files)
+
+	require.NoError(t, s.WriteFile(ctx, "pkg/a.whl", strings.NewReader("second")))
+	require.NoError(t, s.WriteFile(ctx, "pkg/b.whl", strings.NewReader("b")))
+	assert.Equal(t, "second", readAll(t, s, "pkg/a.whl"))
+
+	files, err = s.ListPackageFiles(ctx, "pkg")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.whl", "b.whl"}, files)
+
+	require.NoError(t, s.DeleteFile(ctx, "pkg/a.whl"))
+	_, err = s.ReadFile(ctx, "pkg/a.whl")
+	require.Error(t, err)
+
+	files, err = s.ListPackageFiles(ctx, "pkg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.whl"}, files)
+}
+
+// blockingStorage holds reads after they reached the wrapped storage, until release is closed.
+type blockingStorage struct {
+	Storage
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	rc, err := s.Storage.ReadFile(ctx, filePath)
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return rc, err
+}
+
+func TestCachedStorageInvalidatesDuringRead(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingStorage{Storage: NewMemoryStorage(), started: make(chan struct{}, 1), release: make(chan struct{})}
+	require.NoError(t, backend.WriteFile(ctx, "pkg/a.whl", strings.NewReader("first")))
+	s := newTestCachedStorage(t, backend, config.CacheConfig{})
+
+	read := make(chan string, 1)
+	go func() {
+		rc, err := s.ReadFile(ctx, "pkg/a.whl")
+		if err != nil {
+			read <- err.Error()
+			return
+		}
+		defer rc.Close()
+		data, _ := io.ReadAll(rc)
+		read <- string(data)
+	}()
+
+	// The backend has returned the old content, but the cache hasn't stored it yet.
+	<-backend.started
+	require.NoError(t, s.WriteFile(ctx, "pkg/a.whl", strings.NewReader("second")))
+	close(backend.release)
+	assert.Equal(t, "first", <-read)
+
+	assert.Equal(t, "second", readAll(t, s, "pkg/a.whl"))
+}
+
+func TestCachedStorageListingTTL(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewMemoryStorage()}
+	s := newTestCachedStorage(t, backend, config.CacheConfig{ListingTTLSeconds: 1})
+
+	_, err := s.ListPackageFiles(ctx, "pkg")
+	require.NoError(t, err)
+	_, err = s.ListPackageFiles(ctx, "pkg")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), backend.listings.Load())
+
+	// Changes made behind the cache's back show up once the listing expires.
+	require.NoError(t, backend.WriteFile(ctx, "pkg/a.whl", strings.NewReader("a")))
+	assert.Eventually(t, func() bool {
+		files, err := s.ListPackageFiles(ctx, "pkg")
+		return err == nil && len(files) == 1
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func TestCachedStorageEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewMemoryStorage()}
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, backend.WriteFile(ctx, "pkg/"+name+".whl", strings.NewReader(strings.Repeat(name, 4))))
+	}
+
+	s := newTestCachedStorage(t, backend, config.CacheConfig{MaxSizeBytes: 10})
+
+	readAll(t, s, "pkg/a.whl")
+	readAll(t, s, "pkg/b.whl")
+	readAll(t, s, "pkg/a.whl") // a is now more recently used than b
+	readAll(t, s, "pkg/c.whl") // evicts b
+	assert.Equal(t, int64(3), backend.reads.Load())
+
+	readAll(t, s, "pkg/a.whl")
+	readAll(t, s, "pkg/c.whl")
+	assert.Equal(t, int64(3), backend.reads.Load())
+
+	readAll(t, s, "pkg/b.whl")
+	assert.Equal(t, int64(4), backend.reads.Load())
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// storageFactory creates an empty storage for a single test case.
+type storageFactory func(t *testing.T) Storage
+
+// conformanceBackends lists every backend that must pass the conformance suite.
+// New backends should be added here.
+func conformanceBackends() map[string]storageFactory {
+	backends := map[string]storageFactory{
+		"local": func(t *testing.T) Storage {
+			t.Helper()
+			storage, err := NewLocalStorage(&config.LocalConfig{Path: t.TempDir()})
+			require.NoError(t, err)
+			return storage
+		},
+		"local_mixed": func(t *testing.T) Storage {
+			t.Helper()
+			storage, err := NewLocalStorage(&config.LocalConfig{Path: t.TempDir(), Layout: "mixed"})
+			require.NoError(t, err)
+			return storage
+		},
+		"memory": func(*testing.T) Storage {
+			return NewMemoryStorage()
+		},
+		"s3": func(t *testing.T) Storage {
+			t.Helper()
+			return newFakeS3Storage(t, "my-prefix")
+		},
+		"gcs": func(t *testing.T) Storage {
+			t.Helper()
+			return newFakeGCSStorage(t, "my-prefix")
+		},
+		"mirror": func(t *testing.T) Storage {
+			t.Helper()
+			return newTestMirrorStorage(t, config.MirrorConfig{}, NewMemoryStorage(), NewMemoryStorage())
+		},
+		"encrypted": func(t *testing.T) Storage {
+			t.Helper()
+			return newTestEncryptedStorage(t, NewMemoryStorage(), "k1", map[string][]byte{"k1": newTestKey(t)})
+		},
+		"content_addressed": func(t *testing.T) Storage {
+			t.Helper()
+			return newTestContentAddressedStorage(t, NewMemoryStorage())
+		},
+		"cached": func(t *testing.T) Storage {
+			t.Helper()
+			return newTestCachedStorage(t, NewMemoryStorage(), config.CacheConfig{ListingTTLSeconds: 60})
+		},
+		"faulty": func(*testing.T) Storage {
+			// Without faults configured, it must behave like its backend.
+			return NewFaultyStorage(NewMemoryStorage(), &config.FaultyConfig{Seed: 1})
+		},
+		"resilient": func(*testing.T) Storage {
+			return NewResilientStorage(NewMemoryStorage(), newTestResilienceConfig())
+		},
+	}
+
+	if serviceURL := os.Getenv("AZURITE_BLOB_SERVICE_URL"); serviceURL != "" {
+		backends["azure"] = func(t *testing.T) Storage {
+			t.Helper()
+			return newAzuriteStorage(t, serviceURL)
+		}
+	}
+
+	return backends
+}
+
+func newFakeS3Storage(t *testing.T, prefix string) *S3Storage {
+	t.Helper()
+
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("test-bucket"))
+
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	storage, err := NewS3Storage(context.Background(), &config.S3Config{
+		Bucket:       "test-bucket",
+		Prefix:       prefix,
+		Region:       "us-east-1",
+		Endpoint:     server.URL,
+		UsePathStyle: true,
+		AccessKey:    "test",
+		SecretKey:    "test",
+	})
+	require.NoError(t, err)
+	return storage
+}
+
+func newAzuriteStorage(t *testing.T, serviceURL string) *AzureStorage {
+	t.Helper()
+
+	storage, err := NewAzureStorage(&config.AzureConfig{
+		ServiceURL:  serviceURL,
+		AccountName: azuriteAccountName,
+		AccountKey:  azuriteAccountKey,
+		// Container names must be lowercase alphanumerics and dashes.
+		Container: strings.ToLower(strings.NewReplacer("/", "-", "_", "-").Replace(t.Name())),
+		Prefix:    "my-prefix",
+	})
+	require.NoError(t, err)
+
+	_, err = storage.client.Create(context.Background(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _, _ = storage.client.Delete(context.Background(), nil) })
+
+	return storage
+}
+
+func TestStorageConformance(t *testing.T) {
+	for name, newStorage := range conformanceBackends() {
+		t.Run(name, func(t *testing.T) {
+			testStorageConformance(t, newStorage)
+		})
+	}
+}
+
+// testStorageConformance checks the behaviour that the package index relies on,
+// so that every backend can be swapped for another one.
+func testStorageConformance(t *testing.T, newStorage storageFactory) { //nolint:funlen // A flat list of cases is easier to read.
+	t.Helper()
+
+	ctx := context.Background()
+
+	write := func(t *testing.T, s Storage, filePath, content string) {
+		t.Helper()
+		require.NoError(t, s.WriteFile(ctx, filePath, strings.NewReader(content)))
+	}
+	read := func(t *testing.T, s Storage, filePath string) string {
+		t.Helper()
+		rc, err := s.ReadFile(ctx, filePath)
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		s := newStorage(t)
+
+		pkgs, err := s.ListPackages(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, pkgs)
+
+		files, err := s.ListPackageFiles(ctx, "missing")
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		s := newStorage(t)
+
+		content := make([]byte, 6*1024*1024+123)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+
+		require.NoError(t, s.WriteFile(ctx, "pkg/pkg-1.0.0.tar.gz", bytes.NewReader(content)))
+		assert.Equal(t, string(content), read(t, s, "pkg/pkg-1.0.0.tar.gz"))
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		s := newStorage(t)
+
+		write(t, s, "pkg/file.whl", "first version of the file")
+		write(t, s, "pkg/file.whl", "second")
+		assert.Equal(t, "second", read(t, s, "pkg/file.whl"))
+
+		files, err := s.ListPackageFiles(ctx, "pkg")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"file.whl"}, files)
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		s := newStorage(t)
+
+		write(t, s, "foo/foo-1.0.0.tar.gz", "a")
+		write(t, s, "foo/foo-1.0.0-py3-none-any.whl", "b")
+		write(t, s, "foo-bar/foo_bar-2.0.0.tar.gz", "c")
+		write(t, s, "baz/baz-0.1.0.tar.gz", "d")
+
+		pkgs, err := s.ListPackages(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"foo", "foo-bar", "baz"}, pkgs)
+
+		files, err := s.ListPackageFiles(ctx, "foo")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"foo-1.0.0.tar.gz", "foo-1.0.0-py3-none-any.whl"}, files)
+
+		files, err = s.ListPackageFiles(ctx, "foo-bar")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo_bar-2.0.0.tar.gz"}, files)
+	})
+
+	t.Run("ManyFiles", func(t *testing.T) {
+		s := newStorage(t)
+
+		// More than a single page of most object storage listing APIs.
+		const n = 1005
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		sem := make(chan struct{}, 16)
+		for i := range n {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				errs <- s.WriteFile(ctx, fmt.Sprintf("pkg/pkg-%d.0.tar.gz", i), strings.NewReader("x"))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		files, err := s.ListPackageFiles(ctx, "pkg")
+		require.NoError(t, err)
+		assert.Len(t, files, n)
+	})
+
+	t.Run("ReadMissing", func(t *testing.T) {
+		s := newStorage(t)
+
+		_, err := s.ReadFile(ctx, "pkg/missing.whl")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStorage(t)
+
+		write(t, s, "pkg/a.whl", "a")
+		write(t, s, "pkg/b.whl", "b")
+
+		require.NoError(t, s.DeleteFile(ctx, "pkg/a.whl"))
+
+		_, err := s.ReadFile(ctx, "pkg/a.whl")
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.ErrorIs(t, s.DeleteFile(ctx, "pkg/a.whl"), os.ErrNotExist)
+
+		files, err := s.ListPackageFiles(ctx, "pkg")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b.whl"}, files)
+		assert.Equal(t, "b", read(t, s, "pkg/b.whl"))
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		s := newStorage(t)
+		write(t, s, "pkg/a.whl", "a")
+
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := s.ListPackageFiles(canceledCtx, "pkg")
+		require.ErrorIs(t, err, context.Canceled)
+
+		_, err = s.ReadFile(canceledCtx, "pkg/a.whl")
+		require.ErrorIs(t, err, context.Canceled)
+
+		require.ErrorIs(t, s.DeleteFile(canceledCtx, "pkg/a.whl"), context.Canceled)
+		require.Error(t, s.WriteFile(canceledCtx, "pkg/b.whl", strings.NewReader("b")))
+
+		// Nothing may have changed.
+		files, err := s.ListPackageFiles(ctx, "pkg")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.whl"}, files)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		s := newStorage(t)
+		assert.NoError(t, s.Close())
+	})
+}
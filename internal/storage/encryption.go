@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+var (
+	// ErrNotEncrypted is returned when reading a file that was stored without encryption.
+	ErrNotEncrypted = errors.New("file is not encrypted")
+	// ErrUnknownKey is returned when a file was encrypted with a key that is not in the keyring.
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrCorrupted is returned when an encrypted file was modified, truncated or is otherwise unreadable.
+	ErrCorrupted = errors.New("encrypted file is corrupted")
+)
+
+// Encrypted files start with a header, followed by the content split into chunks that are sealed
+// separately with AES-GCM:
+//
+//	magic (8) | chunk size (4) | salt (32) | key id length (1) | key id
+//
+// Every file is sealed with its own key, derived from the keyring key and the random salt with
+// HKDF-SHA256, so that nonces can't repeat across files however many are written with a key. The
+// nonce of each chunk is 7 zero bytes, the chunk index (4) and a final-chunk flag (1), which detects
+// reordered, dropped and truncated chunks. The header is authenticated as additional data.
+const (
+	encryptionMagic        = "PYPIENC\x02"
+	encryptionChunkSize    = 64 * 1024
+	maxEncryptionChunkSize = 16 * 1024 * 1024
+	noncePrefixSize        = 7
+	encryptionSaltSize     = 32
+	maxKeyIDLength         = 255
+	fileKeyInfo            = "pypi-server file encryption"
+)
+
+// EncryptedStorage encrypts file contents before they reach the backend.
+// Listings and deletions are passed through unchanged, so project and file names stay readable.
+type EncryptedStorage struct {
+	backend Storage
+	keyring *Keyring
+
+	// allowUnencrypted serves files without an encryption header as they are, which is useful
+	// while existing files are being encrypted with the reencrypt command.
+	allowUnencrypted bool
+}
+
+func NewEncryptedStorage(backend Storage, cfg *config.EncryptionConfig) (*EncryptedStorage, error) {
+	keyring, err := LoadKeyring(cfg.KeyringPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("primary_key", keyring.Primary()).Msg("Encrypting stored files")
+
+	return &EncryptedStorage{backend: backend, keyring: keyring, allowUnencrypted: cfg.AllowUnencryptedReads}, nil
+}
+
+func (s *EncryptedStorage) ListPackages(ctx context.Context) ([]string, error) {
+	return s.backend.ListPackages(ctx)
+}
+
+func (s *EncryptedStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	return s.backend.ListPackageFiles(ctx, packageName)
+}
+
+func (s *EncryptedStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.newDecryptingReader(rc)
+	if errors.Is(err, ErrNotEncrypted) && s.allowUnencrypted {
+		err = nil
+	}
+	if err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
+
+func (s *EncryptedStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	r, err := s.newEncryptingReader(content)
+	if err != nil {
+		return err
+	}
+	return s.backend.WriteFile(ctx, filePath, r)
+}
+
+func (s *EncryptedStorage) DeleteFile(ctx context.Context, filePath string) error {
+	return s.backend.DeleteFile(ctx, filePath)
+}
+
+func (s *EncryptedStorage) Close() error {
+	return s.backend.Close()
+}
+
+func (s *EncryptedStorage) Watch(ctx context.Context, fn func(packageName string)) error {
+	return watchBackend(ctx, s.backend, fn)
+}
+
+func (s *EncryptedStorage) Health() error {
+	return checkBackendHealth(s.backend)
+}
+
+func (s *EncryptedStorage) Keyring() *Keyring {
+	return s.keyring
+}
+
+// KeyID returns the id of the key the file is encrypted with, or ErrNotEncrypted.
+func (s *EncryptedStorage) KeyID(ctx context.Context, filePath string) (string, error) {
+	h, err := s.readHeader(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	return h.keyID, nil
+}
+
+func (s *EncryptedStorage) readHeader(ctx context.Context, filePath string) (encryptionHeader, error) {
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return encryptionHeader{}, err
+	}
+	defer rc.Close()
+
+	return readEncryptionHeader(bufio.NewReader(rc))
+}
+
+// Reencrypt encrypts the file with the primary key, unless it already is. Unencrypted files are
+// encrypted as well. It reports whether the file was rewritten.
+func (s *EncryptedStorage) Reencrypt(ctx context.Context, filePath string) (bool, error) {
+	h, err := s.readHeader(ctx, filePath)
+	if err != nil && !errors.Is(err, ErrNotEncrypted) {
+		return false, err
+	}
+	if err == nil && h.keyID == s.keyring.Primary() {
+		return false, nil
+	}
+
+	// The file is decrypted to a temporary file first, since the backend may not support
+	// reading and overwriting the same file at once.
+	spool, err := os.CreateTemp("", "pypi-server-reencrypt-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return false, err
+	}
+	r, err := s.newDecryptingReader(rc)
+	if errors.Is(err, ErrNotEncrypted) {
+		err = nil
+	}
+	if err == nil {
+		_, err = io.Copy(spool, r)
+	}
+	_ = rc.Close()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return true, s.WriteFile(ctx, filePath, spool)
+}
+
+type encryptionHeader struct {
+	chunkSize int
+	// salt derives the key of the file from the keyring key.
+	salt  []byte
+	keyID string
+	raw   []byte
+}
+
+// aead returns the cipher that seals the chunks of the file.
+func (h *encryptionHeader) aead(k *Keyring) (cipher.AEAD, error) {
+	key, err := k.get(h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = hkdf.Key(sha256.New, key, h.salt, fileKeyInfo, len(key)); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *EncryptedStorage) newEncryptingReader(content io.Reader) (io.Reader, error) {
+	keyID := s.keyring.Primary()
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 0, len(encryptionMagic)+4+encryptionSaltSize+1+len(keyID))
+	raw = append(raw, encryptionMagic...)
+	raw = binary.BigEndian.AppendUint32(raw, encryptionChunkSize)
+	raw = append(raw, salt...)
+	raw = append(raw, byte(len(keyID)))
+	raw = append(raw, keyID...)
+
+	h := encryptionHeader{
+		chunkSize: encryptionChunkSize,
+		salt:      salt,
+		keyID:     keyID,
+		raw:       raw,
+	}
+	aead, err := h.aead(s.keyring)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingReader{
+		aead:   aead,
+		header: h,
+		src:    bufio.NewReaderSize(content, encryptionChunkSize),
+		buf:    bytes.NewBuffer(raw),
+		chunk:  make([]byte, encryptionChunkSize),
+	}, nil
+}
+
+// newDecryptingReader reads the header and returns a reader of the plaintext. If the content is
+// not encrypted, it returns ErrNotEncrypted and a reader that yields the content unchanged.
+func (s *EncryptedStorage) newDecryptingReader(rc io.Reader) (io.Reader, error) {
+	src := bufio.NewReaderSize(rc, encryptionChunkSize+64)
+	h, err := readEncryptionHeader(src)
+	if errors.Is(err, ErrNotEncrypted) {
+		return src, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := h.aead(s.keyring)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		aead:   aead,
+		header: h,
+		src:    src,
+		chunk:  make([]byte, h.chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// readEncryptionHeader parses the header. If the content doesn't start with the magic bytes,
+// it returns ErrNotEncrypted without consuming anything.
+func readEncryptionHeader(r *bufio.Reader) (encryptionHeader, error) {
+	magic, err := r.Peek(len(encryptionMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return encryptionHeader{}, err
+	}
+	if !bytes.Equal(magic, []byte(encryptionMagic)) {
+		return encryptionHeader{}, ErrNotEncrypted
+	}
+
+	fixed := make([]byte, len(encryptionMagic)+4+encryptionSaltSize+1)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return encryptionHeader{}, ErrCorrupted
+	}
+	chunkSize := int(binary.BigEndian.Uint32(fixed[len(encryptionMagic):]))
+	if chunkSize <= 0 || chunkSize > maxEncryptionChunkSize {
+		return encryptionHeader{}, ErrCorrupted
+	}
+
+	keyID := make([]byte, int(fixed[len(fixed)-1]))
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return encryptionHeader{}, ErrCorrupted
+	}
+
+	return encryptionHeader{
+		chunkSize: chunkSize,
+		salt:      fixed[len(encryptionMagic)+4 : len(fixed)-1],
+		keyID:     string(keyID),
+		raw:       append(fixed, keyID...),
+	}, nil
+}
+
+func (h *encryptionHeader) nonce(index uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize, noncePrefixSize+5)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type encryptingReader struct {
+	aead   cipher.AEAD
+	header encryptionHeader
+	src    *bufio.Reader
+	// buf holds sealed data that has not been read yet.
+	buf   *bytes.Buffer
+	chunk []byte
+	index uint32
+	done  bool
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	return r.buf.Read(p)
+}
+
+func (r *encryptingReader) sealNext() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		r.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+			r.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if r.index == ^uint32(0) {
+		return errors.New("file is too large to encrypt")
+	}
+	sealed := r.aead.Seal(nil, r.header.nonce(r.index, r.done), r.chunk[:n], r.header.raw)
+	r.buf.Write(sealed)
+	r.index++
+	return nil
+}
+
+type decryptingReader struct {
+	aead   cipher.AEAD
+	header encryptionHeader
+	src    *bufio.Reader
+	chunk  []byte
+	plain  []byte
+	index  uint32
+	done   bool
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.openNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) openNext() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF):
+		// The final chunk is never empty, so the file was truncated at a chunk boundary.
+		return ErrCorrupted
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := r.aead.Open(r.chunk[:0], r.header.nonce(r.index, last), r.chunk[:n], r.header.raw)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.plain = plain
+	r.index++
+	r.done = last
+	return nil
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func newTestEncryptedStorage(t *testing.T, backend Storage, primary string, keys map[string][]byte) *EncryptedStorage {
+	t.Helper()
+
+	keyring, err := NewKeyring(primary, keys)
+	require.NoError(t, err)
+	return &EncryptedStorage{backend: backend, keyring: keyring}
+}
+
+func TestEncryptedStorage_RoundTrip(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newTestEncryptedStorage(t, backend, "k1", map[string][]byte{"k1": newTestKey(t)})
+
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 17} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+
+		require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewReader(content)))
+		assert.Equal(t, content, []byte(readAll(t, storage, "foo/foo-1.0.tar.gz")), "size %d", size)
+
+		raw := []byte(readAll(t, backend, "foo/foo-1.0.tar.gz"))
+		assert.True(t, bytes.HasPrefix(raw, []byte(encryptionMagic)))
+		if size > 16 {
+			assert.NotContains(t, string(raw), string(content[:16]))
+		}
+	}
+}
+
+func TestEncryptedStorage_DetectsTampering(t *testing.T) {
+	backend := NewMemoryStorage()
+	storage := newTestEncryptedStorage(t, backend, "k1", map[string][]byte{"k1": newTestKey(t)})
+
+	content := bytes.Repeat([]byte("a"), 2*encryptionChunkSize+100)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewReader(content)))
+	raw := []byte(readAll(t, backend, "foo/foo-1.0.tar.gz"))
+	headerSize := len(encryptionMagic) + 4 + encryptionSaltSize + 1 + len("k1")
+	sealedChunkSize := encryptionChunkSize + 16
+
+	tests := map[string][]byte{
+		"flipped byte":          append(append([]byte{}, raw[:headerSize+10]...), append([]byte{raw[headerSize+10] ^ 1}, raw[headerSize+11:]...)...),
+		"truncated at boundary": raw[:headerSize+2*sealedChunkSize],
+		"truncated mid chunk":   raw[:len(raw)-5],
+		"dropped chunk":         append(append([]byte{}, raw[:headerSize]...), raw[headerSize+sealedChunkSize:]...),
+		"truncated header":      raw[:headerSize-1],
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, backend.WriteFile(t.Context(), "foo/tampered.tar.gz", bytes.NewReader(tampered)))
+
+			rc, err := storage.ReadFile(t.Context(), "foo/tampered.tar.gz")
+			if err == nil {
+				_, err = io.ReadAll(rc)
+				_ = rc.Close()
+			}
+			require.ErrorIs(t, err, ErrCorrupted)
+		})
+	}
+}
+
+func TestEncryptedStorage_KeyRotation(t *testing.T) {
+	backend := NewMemoryStorage()
+	keys := map[string][]byte{"k1": newTestKey(t), "k2": newTestKey(t)}
+
+	old := newTestEncryptedStorage(t, backend, "k1", keys)
+	require.NoError(t, old.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+
+	storage := newTestEncryptedStorage(t, backend, "k2", keys)
+	keyID, err := storage.KeyID(t.Context(), "foo/foo-1.0.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keyID)
+	assert.Equal(t, "foo", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	changed, err := storage.Reencrypt(t.Context(), "foo/foo-1.0.tar.gz")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	keyID, err = storage.KeyID(t.Context(), "foo/foo-1.0.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "k2", keyID)
+
+	changed, err = storage.Reencrypt(t.Context(), "foo/foo-1.0.tar.gz")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// The old key is no longer needed.
+	delete(keys, "k1")
+	storage = newTestEncryptedStorage(t, backend, "k2", keys)
+	assert.Equal(t, "foo", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	// A keyring without the key can't read the file.
+	storage = newTestEncryptedStorage(t, backend, "k3", map[string][]byte{"k3": newTestKey(t)})
+	_, err = storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestEncryptedStorage_FileKeys(t *testing.T) {
+	backend := NewMemoryStorage()
+	key := newTestKey(t)
+	storage := newTestEncryptedStorage(t, backend, "k1", map[string][]byte{"k1": key})
+
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/a.tar.gz", bytes.NewBufferString("same content")))
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/b.tar.gz", bytes.NewBufferString("same content")))
+	a, b := []byte(readAll(t, backend, "foo/a.tar.gz")), []byte(readAll(t, backend, "foo/b.tar.gz"))
+	headerSize := len(encryptionMagic) + 4 + encryptionSaltSize + 1 + len("k1")
+	assert.NotEqual(t, a[:headerSize], b[:headerSize])
+	// Both files use the same nonces, so only their own keys keep the ciphertexts apart.
+	assert.NotEqual(t, a[headerSize:], b[headerSize:])
+
+	// The keyring key alone doesn't open the chunks.
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	h := encryptionHeader{}
+	_, err = aead.Open(nil, h.nonce(0, true), a[headerSize:], a[:headerSize])
+	require.Error(t, err)
+}
+
+func TestEncryptedStorage_UnencryptedFiles(t *testing.T) {
+	backend := NewMemoryStorage()
+	require.NoError(t, backend.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("plain")))
+
+	storage := newTestEncryptedStorage(t, backend, "k1", map[string][]byte{"k1": newTestKey(t)})
+	_, err := storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, ErrNotEncrypted)
+
+	storage.allowUnencrypted = true
+	assert.Equal(t, "plain", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	changed, err := storage.Reencrypt(t.Context(), "foo/foo-1.0.tar.gz")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, bytes.HasPrefix([]byte(readAll(t, backend, "foo/foo-1.0.tar.gz")), []byte(encryptionMagic)))
+	assert.Equal(t, "plain", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+}
+
+func TestEncryptedStorage_UnknownFormat(t *testing.T) {
+	backend := NewMemoryStorage()
+	require.NoError(t, backend.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("PYPIENC\x01rest")))
+
+	storage := newTestEncryptedStorage(t, backend, "k1", map[string][]byte{"k1": newTestKey(t)})
+	_, err := storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, ErrNotEncrypted)
+	_, err = storage.KeyID(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestNewEncryptedStorage(t *testing.T) {
+	keyringPath := filepath.Join(t.TempDir(), "keyring.json")
+	b, err := json.Marshal(keyringFile{
+		Primary: "2025-01",
+		Keys:    map[string]string{"2025-01": base64.StdEncoding.EncodeToString(newTestKey(t))},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyringPath, b, 0600))
+
+	storage, err := NewEncryptedStorage(NewMemoryStorage(), &config.EncryptionConfig{KeyringPath: keyringPath})
+	require.NoError(t, err)
+	assert.Equal(t, "2025-01", storage.Keyring().Primary())
+
+	_, err = NewKeyring("missing", map[string][]byte{"k1": newTestKey(t)})
+	require.Error(t, err)
+	_, err = NewKeyring("k1", map[string][]byte{"k1": []byte("short")})
+	require.Error(t, err)
+}
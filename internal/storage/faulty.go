@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// ErrInjectedFault is returned by FaultyStorage for the failures it injects.
+var ErrInjectedFault = errors.New("injected storage fault")
+
+// maxTruncatedBytes bounds how many bytes a truncated read or a partial write lets through.
+const maxTruncatedBytes = 4096
+
+// FaultyStorage injects latency, errors, truncated reads and partial writes into another storage.
+// It is meant for testing how the server behaves while storage misbehaves, and should never be
+// used in production.
+//
+// Faults are drawn from a random source seeded from the config, so the same sequence of operations
+// always sees the same faults.
+type FaultyStorage struct {
+	backend Storage
+	cfg     config.FaultyConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func NewFaultyStorage(backend Storage, cfg *config.FaultyConfig) *FaultyStorage {
+	log.Warn().Uint64("seed", cfg.Seed).Msg("Using faulty storage, storage operations will fail on purpose")
+
+	return &FaultyStorage{
+		backend: backend,
+		cfg:     *cfg,
+		rng:     rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)), //nolint:gosec // Faults must be repeatable.
+	}
+}
+
+func (s *FaultyStorage) ListPackages(ctx context.Context) ([]string, error) {
+	if err := s.inject(ctx, &s.cfg.ListPackages); err != nil {
+		return nil, err
+	}
+	return s.backend.ListPackages(ctx)
+}
+
+func (s *FaultyStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if err := s.inject(ctx, &s.cfg.ListPackageFiles); err != nil {
+		return nil, err
+	}
+	return s.backend.ListPackageFiles(ctx, packageName)
+}
+
+func (s *FaultyStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if err := s.inject(ctx, &s.cfg.ReadFile); err != nil {
+		return nil, err
+	}
+	rc, err := s.backend.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit, ok := s.truncate(s.cfg.ReadFile.TruncateRate); ok {
+		return struct {
+			io.Reader
+			io.Closer
+		}{&truncatedReader{r: rc, remaining: limit}, rc}, nil
+	}
+	return rc, nil
+}
+
+func (s *FaultyStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	if err := s.inject(ctx, &s.cfg.WriteFile); err != nil {
+		return err
+	}
+
+	if limit, ok := s.truncate(s.cfg.WriteFile.PartialWriteRate); ok {
+		if err := s.backend.WriteFile(ctx, filePath, io.LimitReader(content, limit)); err != nil {
+			return err
+		}
+		return ErrInjectedFault
+	}
+	return s.backend.WriteFile(ctx, filePath, content)
+}
+
+func (s *FaultyStorage) DeleteFile(ctx context.Context, filePath string) error {
+	if err := s.inject(ctx, &s.cfg.DeleteFile); err != nil {
+		return err
+	}
+	return s.backend.DeleteFile(ctx, filePath)
+}
+
+func (s *FaultyStorage) Close() error {
+	return s.backend.Close()
+}
+
+// inject waits for the configured latency and then fails at the configured rate.
+func (s *FaultyStorage) inject(ctx context.Context, cfg *config.FaultConfig) error {
+	if cfg.LatencyMilliseconds > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(cfg.LatencyMilliseconds) * time.Millisecond):
+		}
+	}
+	if s.chance(cfg.ErrorRate) {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// truncate decides whether to cut content short, and after how many bytes.
+func (s *FaultyStorage) truncate(rate float64) (int64, bool) {
+	if !s.chance(rate) {
+		return 0, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Int64N(maxTruncatedBytes), true
+}
+
+func (s *FaultyStorage) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < rate
+}
+
+// truncatedReader fails with ErrInjectedFault after remaining bytes. Shorter content fails in place
+// of its EOF, so a truncated read never looks complete.
+type truncatedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, ErrInjectedFault
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = ErrInjectedFault
+	}
+	return n, err
+}
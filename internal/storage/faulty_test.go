@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+func TestFaultyStorage_Deterministic(t *testing.T) {
+	outcomes := func() []bool {
+		storage := NewFaultyStorage(NewMemoryStorage(), &config.FaultyConfig{
+			Seed:         42,
+			ListPackages: config.FaultConfig{ErrorRate: 0.5},
+		})
+		var failed []bool
+		for range 32 {
+			_, err := storage.ListPackages(t.Context())
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	first := outcomes()
+	assert.Equal(t, first, outcomes())
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+}
+
+func TestFaultyStorage_Faults(t *testing.T) {
+	backend := NewMemoryStorage()
+	content := strings.Repeat("x", 2*maxTruncatedBytes)
+	require.NoError(t, backend.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", strings.NewReader(content)))
+
+	storage := NewFaultyStorage(backend, &config.FaultyConfig{
+		Seed:       1,
+		ReadFile:   config.FaultConfig{TruncateRate: 1},
+		WriteFile:  config.FaultConfig{PartialWriteRate: 1},
+		DeleteFile: config.FaultConfig{ErrorRate: 1, LatencyMilliseconds: 10},
+	})
+
+	rc, err := storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.NoError(t, err)
+	read, err := io.ReadAll(rc)
+	require.ErrorIs(t, err, ErrInjectedFault)
+	require.NoError(t, rc.Close())
+	assert.Less(t, len(read), len(content))
+
+	err = storage.WriteFile(t.Context(), "foo/foo-1.1.tar.gz", strings.NewReader(content))
+	require.ErrorIs(t, err, ErrInjectedFault)
+	stored := readAll(t, backend, "foo/foo-1.1.tar.gz")
+	assert.Less(t, len(stored), len(content))
+
+	start := time.Now()
+	require.ErrorIs(t, storage.DeleteFile(t.Context(), "foo/foo-1.0.tar.gz"), ErrInjectedFault)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	require.ErrorIs(t, storage.DeleteFile(ctx, "foo/foo-1.0.tar.gz"), context.Canceled)
+	assert.Equal(t, content, readAll(t, backend, "foo/foo-1.0.tar.gz"))
+}
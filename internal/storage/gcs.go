@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+type GCSStorage struct {
+	bucket string
+	prefix string
+	client *gcs.Client
+}
+
+// NewGCSStorage creates a storage backed by Google Cloud Storage.
+//
+// Credentials are loaded from the service account file when configured. Otherwise, application
+// default credentials are used, which covers workload identity on GKE and the metadata server on GCE.
+func NewGCSStorage(ctx context.Context, cfg *config.GCSConfig) (*GCSStorage, error) {
+	var opts []option.ClientOption
+	if cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+	if cfg.WithoutAuthentication {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+
+	client, err := gcs.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newGCSStorageWithClient(client, cfg.Bucket, cfg.Prefix), nil
+}
+
+func newGCSStorageWithClient(client *gcs.Client, bucket, prefix string) *GCSStorage {
+	return &GCSStorage{bucket: bucket, prefix: prefix, client: client}
+}
+
+func (s *GCSStorage) ListPackages(ctx context.Context) ([]string, error) {
+	prefix := s.keyPrefix()
+	it := s.client.Bucket(s.bucket).Objects(ctx, &gcs.Query{
+		Prefix:    prefix,
+		Delimiter: "/",
+	})
+
+	packages := []string{}
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// With a delimiter, "directories" are returned as synthetic entries that only have a prefix.
+		if attrs.Prefix == "" {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, prefix), "/")
+		if name != "" {
+			packages = append(packages, name)
+		}
+	}
+	return packages, nil
+}
+
+func (s *GCSStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	packageName, err := cleanPackageName(packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := s.keyPrefix() + packageName + "/"
+	it := s.client.Bucket(s.bucket).Objects(ctx, &gcs.Query{
+		Prefix:    prefix,
+		Delimiter: "/",
+	})
+
+	files := []string{}
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if attrs.Prefix != "" {
+			continue
+		}
+		name := strings.TrimPrefix(attrs.Name, prefix)
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+func (s *GCSStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	obj, err := s.object(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *GCSStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	obj, err := s.object(filePath)
+	if err != nil {
+		return err
+	}
+
+	// Cancelling the context is the only way to abort an upload. Closing the writer would commit
+	// whatever has been written so far, so a failed copy must never reach Close on a live context.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := obj.NewWriter(ctx)
+	if _, err := io.Copy(w, content); err != nil {
+		cancel()
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *GCSStorage) DeleteFile(ctx context.Context, filePath string) error {
+	obj, err := s.object(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := obj.Delete(ctx); err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return os.ErrNotExist
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *GCSStorage) Close() error {
+	return s.client.Close()
+}
+
+func (s *GCSStorage) object(filePath string) (*gcs.ObjectHandle, error) {
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Bucket(s.bucket).Object(path.Join(s.keyPrefix(), filePath)), nil
+}
+
+// keyPrefix returns the object name prefix with a trailing slash, or an empty string if no prefix is configured.
+func (s *GCSStorage) keyPrefix() string {
+	prefix := strings.Trim(s.prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+func newFakeGCSStorage(t *testing.T, prefix string) *GCSStorage {
+	t.Helper()
+
+	// The in-process transport of the fake server ignores request contexts, so serve it over HTTP
+	// and point the client at it like a real emulator.
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true, PublicHost: "127.0.0.1"})
+	require.NoError(t, err)
+	t.Cleanup(server.Stop)
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "test-bucket"})
+
+	httpServer := httptest.NewServer(server.HTTPHandler())
+	t.Cleanup(httpServer.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(httpServer.URL, "http://"))
+
+	storage, err := NewGCSStorage(context.Background(), &config.GCSConfig{
+		Bucket: "test-bucket",
+		Prefix: prefix,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+
+	return storage
+}
+
+func TestGCSStorage(t *testing.T) {
+	for _, prefix := range []string{"", "my-prefix", "nested/prefix/"} {
+		t.Run("prefix="+prefix, func(t *testing.T) {
+			storage := newFakeGCSStorage(t, prefix)
+
+			ctx := context.Background()
+			writePath := "testpkg/file.txt"
+			fileContent := "hello world"
+
+			// WriteFile
+			err := storage.WriteFile(ctx, writePath, strings.NewReader(fileContent))
+			require.NoError(t, err)
+
+			// ListPackages
+			pkgs, err := storage.ListPackages(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []string{"testpkg"}, pkgs)
+
+			// ListPackageFiles
+			files, err := storage.ListPackageFiles(ctx, "testpkg")
+			require.NoError(t, err)
+			require.Equal(t, []string{"file.txt"}, files)
+
+			// ReadFile
+			reader, err := storage.ReadFile(ctx, writePath)
+			require.NoError(t, err)
+
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, fileContent, string(data))
+
+			// DeleteFile
+			err = storage.DeleteFile(ctx, writePath)
+			require.NoError(t, err)
+
+			// Confirm deletion
+			_, err = storage.ReadFile(ctx, writePath)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+
+			err = storage.DeleteFile(ctx, writePath)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestGCSStorageAbortsFailedUpload(t *testing.T) {
+	storage := newFakeGCSStorage(t, "")
+	ctx := context.Background()
+
+	err := storage.WriteFile(ctx, "testpkg/file.whl", io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	require.Error(t, err)
+
+	_, err = storage.ReadFile(ctx, "testpkg/file.whl")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,20 @@
+package storage
+
+// HealthChecker is implemented by storages that know whether their backend is currently usable.
+type HealthChecker interface {
+	// Health returns an error describing why the storage is unhealthy, or nil.
+	Health() error
+}
+
+// Health reports whether a storage is usable. Storages that don't track their health are always healthy.
+func Health(s Storage) error {
+	return checkBackendHealth(s)
+}
+
+// checkBackendHealth forwards Health to a decorated backend.
+func checkBackendHealth(backend Storage) error {
+	if h, ok := backend.(HealthChecker); ok {
+		return h.Health()
+	}
+	return nil
+}
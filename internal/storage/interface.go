@@ -20,12 +20,96 @@ type Storage interface {
 }
 
 func New(ctx context.Context, cfg *config.StorageConfig) (Storage, error) {
+	strg, err := newBackend(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resilience wraps the backend directly, so that cached files are still served while it is down.
+	if cfg.Resilience.Enabled {
+		strg = NewResilientStorage(strg, &cfg.Resilience)
+	}
+
+	if cfg.Cache.Enabled {
+		cached, err := NewCachedStorage(strg, &cfg.Cache)
+		if err != nil {
+			_ = strg.Close()
+			return nil, err
+		}
+		strg = cached
+	}
+
+	// Encryption wraps the cache, so that cached files stay encrypted on local disk as well.
+	if cfg.Encryption.Enabled {
+		encrypted, err := NewEncryptedStorage(strg, &cfg.Encryption)
+		if err != nil {
+			_ = strg.Close()
+			return nil, err
+		}
+		strg = encrypted
+	}
+
+	// Blobs are addressed by the sha256 of the plain content, so this has to wrap encryption.
+	if cfg.ContentAddressed.Enabled {
+		strg = NewContentAddressedStorage(strg, &cfg.ContentAddressed)
+	}
+
+	return strg, nil
+}
+
+func newBackend(ctx context.Context, cfg *config.StorageConfig) (Storage, error) {
 	switch cfg.Kind {
 	case "local":
-		return NewLocalStorage(&cfg.Local), nil
+		return NewLocalStorage(&cfg.Local)
 	case "s3":
 		return NewS3Storage(ctx, &cfg.S3)
+	case "gcs":
+		return NewGCSStorage(ctx, &cfg.GCS)
+	case "azure":
+		return NewAzureStorage(&cfg.Azure)
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "mirror":
+		return newMirrorBackend(ctx, &cfg.Mirror)
+	case "faulty":
+		if cfg.Faulty.Backend == nil {
+			return nil, errors.New("faulty storage requires a backend")
+		}
+		backend, err := newBackend(ctx, cfg.Faulty.Backend)
+		if err != nil {
+			return nil, err
+		}
+		return NewFaultyStorage(backend, &cfg.Faulty), nil
 	default:
 		return nil, errors.New("unknown storage kind: " + cfg.Kind)
 	}
 }
+
+func newMirrorBackend(ctx context.Context, cfg *config.MirrorConfig) (Storage, error) {
+	backends := make([]Storage, 0, len(cfg.Backends))
+	closeAll := func() {
+		for _, b := range backends {
+			_ = b.Close()
+		}
+	}
+
+	for i := range cfg.Backends {
+		if cfg.Backends[i].Kind == "mirror" {
+			closeAll()
+			return nil, errors.New("mirror storage cannot contain another mirror")
+		}
+		b, err := newBackend(ctx, &cfg.Backends[i])
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+
+	strg, err := NewMirrorStorage(backends, cfg)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return strg, nil
+}
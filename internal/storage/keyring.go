@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Keyring holds the keys used by EncryptedStorage. New files are encrypted with the primary key,
+// and every other key is kept so that files encrypted before a rotation can still be read.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// keyringFile is the on-disk format of a keyring. Keys are base64 encoded AES keys of 16, 24 or 32 bytes.
+//
+//	{"primary": "2025-01", "keys": {"2025-01": "...", "2024-06": "..."}}
+type keyringFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var f keyringFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(f.Primary, keys)
+}
+
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+
+	k := &Keyring{primary: primary, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIDLength {
+			return nil, fmt.Errorf("key id %q must be between 1 and %d bytes", id, maxKeyIDLength)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+func (k *Keyring) get(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
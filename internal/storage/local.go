@@ -2,89 +2,290 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"slices"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/jeongukjae/pypi-server/internal/config"
 )
 
+// tempFilePrefix marks files that are still being written. They are never listed,
+// and leftovers from a crash are removed when the storage is created.
+const tempFilePrefix = ".tmp-"
+
 type LocalStorage struct {
-	cfg *config.LocalConfig
+	cfg    *config.LocalConfig
+	layout localLayout
+
+	// root confines every file operation to cfg.Path, including symlinks pointing outside of it.
+	root *os.Root
 }
 
-func NewLocalStorage(cfg *config.LocalConfig) *LocalStorage {
+func NewLocalStorage(cfg *config.LocalConfig) (*LocalStorage, error) {
 	log.Info().Msgf("Using local storage at path: %s", cfg.Path)
-	return &LocalStorage{cfg: cfg}
-}
 
-func (s *LocalStorage) ListPackages(context.Context) ([]string, error) {
-	osFiles, err := os.ReadDir(s.cfg.Path)
+	layout, err := parseLocalLayout(cfg.Layout)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
-		}
+		return nil, err
+	}
 
+	if err := os.MkdirAll(cfg.Path, 0750); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LocalStorage{cfg: cfg, layout: layout, root: root}
+	s.removeTempFiles()
+	return s, nil
+}
+
+func (s *LocalStorage) ListPackages(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	osFiles, err := s.readDir(".")
+	if err != nil {
 		return nil, err
 	}
 
 	packages := make([]string, 0, len(osFiles))
+	seen := map[string]struct{}{}
+	add := func(name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			packages = append(packages, name)
+		}
+	}
 	for _, f := range osFiles {
-		if f.IsDir() {
-			packages = append(packages, f.Name())
+		switch {
+		case f.IsDir() && (s.layout != layoutFlat || isInternalDir(f.Name())):
+			add(f.Name())
+		case !f.IsDir() && s.layout != layoutNested:
+			if project, ok := flatFileProject(f.Name()); ok {
+				add(project)
+			}
 		}
 	}
 
 	return packages, nil
 }
 
-func (s *LocalStorage) ListPackageFiles(_ context.Context, packageName string) ([]string, error) {
-	osFiles, err := os.ReadDir(path.Join(s.cfg.Path, packageName))
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
-		}
+func (s *LocalStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
+	packageName, err := cleanPackageName(packageName)
+	if err != nil {
 		return nil, err
 	}
 
-	files := make([]string, 0, len(osFiles))
-	for _, f := range osFiles {
-		if !f.IsDir() {
-			files = append(files, f.Name())
+	files := []string{}
+	if s.layout != layoutFlat || isInternalDir(packageName) {
+		osFiles, err := s.readDir(packageName)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, f := range osFiles {
+			if !f.IsDir() && !strings.HasPrefix(f.Name(), tempFilePrefix) {
+				files = append(files, f.Name())
+			}
+		}
+	}
+
+	if s.layout != layoutNested && !isInternalDir(packageName) {
+		osFiles, err := s.readDir(".")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range osFiles {
+			if project, ok := flatFileProject(f.Name()); ok && !f.IsDir() && project == packageName && !slices.Contains(files, f.Name()) {
+				files = append(files, f.Name())
+			}
 		}
 	}
 
 	return files, nil
 }
 
-func (s *LocalStorage) ReadFile(_ context.Context, filepath string) (io.ReadCloser, error) {
-	return os.Open(path.Join(s.cfg.Path, filepath))
+func (s *LocalStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.layout {
+	case layoutFlat:
+		return s.openFlat(filePath)
+	case layoutMixed:
+		f, err := s.root.Open(filePath)
+		if os.IsNotExist(err) {
+			return s.openFlat(filePath)
+		}
+		return f, err
+	default:
+		return s.root.Open(filePath)
+	}
 }
 
-func (s *LocalStorage) WriteFile(_ context.Context, filepath string, content io.Reader) error {
-	fullPath := path.Join(s.cfg.Path, filepath)
-	parentPath := path.Dir(fullPath)
-	if err := os.MkdirAll(parentPath, 0750); err != nil {
+// WriteFile writes the content to a temporary file next to the destination and renames it into place
+// once it is fully flushed to disk, so readers never observe a partially written file.
+func (s *LocalStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+	if s.layout == layoutFlat {
+		p, ok := flatPath(filePath)
+		if !ok {
+			return fmt.Errorf("%w: %q is not a distribution of its project", ErrInvalidPath, filePath)
+		}
+		filePath = p
+	}
+
+	parentPath := path.Dir(filePath)
+	if err := s.root.MkdirAll(parentPath, 0750); err != nil {
+		return err
+	}
+
+	tempName, err := newTempName(path.Base(filePath))
+	if err != nil {
 		return err
 	}
+	tempPath := path.Join(parentPath, tempName)
 
-	f, err := os.Create(fullPath)
+	// The rename keeps the mode, so files stay readable by other processes serving the directory,
+	// like files made by os.Create.
+	f, err := s.root.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644) //nolint:gosec // See above.
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, content)
-	return err
+	if err := writeAndSync(f, contextReader{ctx: ctx, r: content}); err != nil {
+		_ = s.root.Remove(tempPath)
+		return err
+	}
+
+	if err := s.root.Rename(tempPath, filePath); err != nil {
+		_ = s.root.Remove(tempPath)
+		return err
+	}
+
+	// Persist the rename itself.
+	return s.syncDir(parentPath)
 }
 
-func (s *LocalStorage) DeleteFile(_ context.Context, filepath string) error {
-	return os.Remove(path.Join(s.cfg.Path, filepath))
+func (s *LocalStorage) DeleteFile(ctx context.Context, filePath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	switch s.layout {
+	case layoutFlat:
+		return s.removeFlat(filePath)
+	case layoutMixed:
+		err := s.root.Remove(filePath)
+		if os.IsNotExist(err) {
+			return s.removeFlat(filePath)
+		}
+		return err
+	default:
+		return s.root.Remove(filePath)
+	}
 }
 
 func (s *LocalStorage) Close() error {
-	return nil
+	return s.root.Close()
+}
+
+func (s *LocalStorage) readDir(name string) ([]fs.DirEntry, error) {
+	d, err := s.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	return d.ReadDir(-1)
+}
+
+func (s *LocalStorage) syncDir(name string) error {
+	d, err := s.root.Open(name)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+// removeTempFiles removes temporary files left behind by writes that were interrupted by a crash.
+func (s *LocalStorage) removeTempFiles() {
+	err := fs.WalkDir(s.root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && strings.HasPrefix(d.Name(), tempFilePrefix) {
+			log.Warn().Str("path", p).Msg("Removing leftover temporary file")
+			if err := s.root.Remove(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to remove leftover temporary files")
+	}
+}
+
+// newTempName returns a unique name for a temporary file that will be renamed to name.
+func newTempName(name string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return tempFilePrefix + name + "-" + hex.EncodeToString(suffix), nil
+}
+
+func writeAndSync(f *os.File, content io.Reader) error {
+	if _, err := io.Copy(f, content); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
 }
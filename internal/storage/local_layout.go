@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jeongukjae/pypi-server/internal/utils"
+)
+
+type localLayout string
+
+const (
+	// layoutNested keeps files at <project>/<file>.
+	layoutNested localLayout = "nested"
+	// layoutFlat keeps all files in the root directory like pypiserver, and derives projects from file names.
+	layoutFlat localLayout = "flat"
+	// layoutMixed reads both layouts and writes nested, so that both servers can share a directory.
+	layoutMixed localLayout = "mixed"
+)
+
+func parseLocalLayout(layout string) (localLayout, error) {
+	switch localLayout(layout) {
+	case "", layoutNested:
+		return layoutNested, nil
+	case layoutFlat, layoutMixed:
+		return localLayout(layout), nil
+	default:
+		return "", fmt.Errorf("unknown local storage layout: %q", layout)
+	}
+}
+
+// isInternalDir reports whether the directory holds internal files, such as blobs. These are
+// always nested, whatever the layout.
+func isInternalDir(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// flatFileProject returns the normalized project name of a file in the flat directory.
+func flatFileProject(fileName string) (string, bool) {
+	if strings.HasPrefix(fileName, ".") {
+		return "", false
+	}
+	dist, err := utils.ParseDistributionFileName(fileName)
+	if err != nil {
+		return "", false
+	}
+	return utils.NormalizePackageName(dist.Name), true
+}
+
+// flatPath maps <project>/<file> to <file> in the root directory. It returns false if the file
+// name belongs to another project. Paths in internal directories are returned unchanged.
+func flatPath(filePath string) (string, bool) {
+	packageName, fileName, found := strings.Cut(filePath, "/")
+	if !found || isInternalDir(packageName) {
+		return filePath, true
+	}
+	if strings.Contains(fileName, "/") {
+		return "", false
+	}
+
+	project, ok := flatFileProject(fileName)
+	if !ok || project != packageName {
+		return "", false
+	}
+	return fileName, true
+}
+
+func (s *LocalStorage) openFlat(filePath string) (*os.File, error) {
+	p, ok := flatPath(filePath)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return s.root.Open(p)
+}
+
+func (s *LocalStorage) removeFlat(filePath string) error {
+	p, ok := flatPath(filePath)
+	if !ok {
+		return os.ErrNotExist
+	}
+	return s.root.Remove(p)
+}
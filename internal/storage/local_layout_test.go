@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// newPypiserverDir creates a directory like the one pypiserver serves.
+func newPypiserverDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Foo_Bar-1.0.tar.gz",
+		"foo_bar-1.1-py3-none-any.whl",
+		"python-dateutil-2.8.2.tar.gz",
+		"README.txt",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0600))
+	}
+	return dir
+}
+
+func TestLocalStorageLayout(t *testing.T) {
+	t.Run("flat", func(t *testing.T) {
+		dir := newPypiserverDir(t)
+		storage, err := NewLocalStorage(&config.LocalConfig{Path: dir, Layout: "flat"})
+		require.NoError(t, err)
+
+		packages, err := storage.ListPackages(t.Context())
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"foo-bar", "python-dateutil"}, packages)
+
+		files, err := storage.ListPackageFiles(t.Context(), "foo-bar")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"Foo_Bar-1.0.tar.gz", "foo_bar-1.1-py3-none-any.whl"}, files)
+
+		assert.Equal(t, "Foo_Bar-1.0.tar.gz", readAll(t, storage, "foo-bar/Foo_Bar-1.0.tar.gz"))
+		// Files are only served under their own project.
+		_, err = storage.ReadFile(t.Context(), "python-dateutil/Foo_Bar-1.0.tar.gz")
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		require.NoError(t, storage.WriteFile(t.Context(), "foo-bar/foo_bar-1.2.tar.gz", strings.NewReader("new")))
+		_, err = os.Stat(filepath.Join(dir, "foo_bar-1.2.tar.gz"))
+		require.NoError(t, err)
+
+		err = storage.WriteFile(t.Context(), "python-dateutil/foo_bar-1.3.tar.gz", strings.NewReader("new"))
+		require.ErrorIs(t, err, ErrInvalidPath)
+
+		require.NoError(t, storage.DeleteFile(t.Context(), "foo-bar/Foo_Bar-1.0.tar.gz"))
+		_, err = os.Stat(filepath.Join(dir, "Foo_Bar-1.0.tar.gz"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		// Internal directories stay nested.
+		require.NoError(t, storage.WriteFile(t.Context(), ".blobs/abc", strings.NewReader("blob")))
+		files, err = storage.ListPackageFiles(t.Context(), ".blobs")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"abc"}, files)
+		assert.Equal(t, "blob", readAll(t, storage, ".blobs/abc"))
+	})
+
+	t.Run("mixed", func(t *testing.T) {
+		dir := newPypiserverDir(t)
+		storage, err := NewLocalStorage(&config.LocalConfig{Path: dir, Layout: "mixed"})
+		require.NoError(t, err)
+
+		require.NoError(t, storage.WriteFile(t.Context(), "foo-bar/foo_bar-2.0.tar.gz", strings.NewReader("nested")))
+		require.NoError(t, storage.WriteFile(t.Context(), "baz/baz-1.0.tar.gz", strings.NewReader("nested")))
+		_, err = os.Stat(filepath.Join(dir, "foo-bar", "foo_bar-2.0.tar.gz"))
+		require.NoError(t, err)
+
+		packages, err := storage.ListPackages(t.Context())
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"foo-bar", "python-dateutil", "baz"}, packages)
+
+		files, err := storage.ListPackageFiles(t.Context(), "foo-bar")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"foo_bar-2.0.tar.gz", "Foo_Bar-1.0.tar.gz", "foo_bar-1.1-py3-none-any.whl"}, files)
+
+		assert.Equal(t, "nested", readAll(t, storage, "foo-bar/foo_bar-2.0.tar.gz"))
+		assert.Equal(t, "Foo_Bar-1.0.tar.gz", readAll(t, storage, "foo-bar/Foo_Bar-1.0.tar.gz"))
+
+		require.NoError(t, storage.DeleteFile(t.Context(), "foo-bar/Foo_Bar-1.0.tar.gz"))
+		require.NoError(t, storage.DeleteFile(t.Context(), "foo-bar/foo_bar-2.0.tar.gz"))
+		files, err = storage.ListPackageFiles(t.Context(), "foo-bar")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo_bar-1.1-py3-none-any.whl"}, files)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewLocalStorage(&config.LocalConfig{Path: t.TempDir(), Layout: "sideways"})
+		require.Error(t, err)
+	})
+}
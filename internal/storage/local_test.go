@@ -16,7 +16,8 @@ import (
 
 func TestLocalStorage(t *testing.T) {
 	dir := t.TempDir()
-	storage := NewLocalStorage(&config.LocalConfig{Path: dir})
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
 
 	ctx := context.Background()
 	pkgName := "testpkg"
@@ -25,7 +26,7 @@ func TestLocalStorage(t *testing.T) {
 
 	// WriteFile
 	writePath := filepath.Join(pkgName, fileName)
-	err := storage.WriteFile(ctx, writePath, strings.NewReader(fileContent))
+	err = storage.WriteFile(ctx, writePath, strings.NewReader(fileContent))
 	require.NoError(t, err)
 
 	// ListPackages
@@ -57,3 +58,126 @@ func TestLocalStorage(t *testing.T) {
 	_, err = storage.ReadFile(ctx, writePath)
 	assert.True(t, os.IsNotExist(err))
 }
+
+func TestLocalStorageFailedWriteKeepsPreviousFile(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, storage.WriteFile(ctx, "testpkg/file.whl", strings.NewReader("complete")))
+
+	err = storage.WriteFile(ctx, "testpkg/file.whl", io.MultiReader(strings.NewReader("trunc"), failingReader{}))
+	require.Error(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "testpkg", "file.whl"))
+	require.NoError(t, err)
+	assert.Equal(t, "complete", string(data))
+
+	// The temporary file must be cleaned up as well.
+	entries, err := os.ReadDir(filepath.Join(dir, "testpkg"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestLocalStorageWriteFileMode(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.WriteFile(context.Background(), "testpkg/file.whl", strings.NewReader("content")))
+
+	// A file created with 0644 gets the same mode under the umask of the test.
+	reference := filepath.Join(dir, "testpkg", "reference")
+	require.NoError(t, os.WriteFile(reference, nil, 0644)) //nolint:gosec // Compared with the written file.
+	want, err := os.Stat(reference)
+	require.NoError(t, err)
+	got, err := os.Stat(filepath.Join(dir, "testpkg", "file.whl"))
+	require.NoError(t, err)
+	assert.Equal(t, want.Mode().Perm(), got.Mode().Perm())
+}
+
+func TestLocalStorageWriteFileCanceled(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, storage.WriteFile(ctx, "testpkg/file.whl", strings.NewReader("content")), context.Canceled)
+
+	// Nothing is created, not even the project directory.
+	_, err = os.Stat(filepath.Join(dir, "testpkg"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocalStorageRemovesLeftoverTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "testpkg"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "testpkg", "file.whl"), []byte("complete"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "testpkg", tempFilePrefix+"other.whl-123"), []byte("trunc"), 0600))
+
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+
+	files, err := storage.ListPackageFiles(context.Background(), "testpkg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file.whl"}, files)
+
+	_, err = os.Stat(filepath.Join(dir, "testpkg", tempFilePrefix+"other.whl-123"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocalStorageRejectsEscapingPaths(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "data")
+	require.NoError(t, os.MkdirAll(dir, 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(parent, filepath.Join(dir, "escape")))
+
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+
+	ctx := context.Background()
+	hostilePaths := []string{
+		"../secret.txt",
+		"pkg/../../secret.txt",
+		"/etc/passwd",
+		filepath.Join(parent, "secret.txt"),
+		"..",
+		"",
+		".",
+		"pkg\\..\\..\\secret.txt",
+		"pkg/file\x00.whl",
+		// Symlinks must not lead outside of the root either.
+		"escape/secret.txt",
+	}
+
+	for _, p := range hostilePaths {
+		t.Run(p, func(t *testing.T) {
+			_, err := storage.ReadFile(ctx, p)
+			require.Error(t, err)
+
+			require.Error(t, storage.WriteFile(ctx, p, strings.NewReader("pwned")))
+			require.Error(t, storage.DeleteFile(ctx, p))
+		})
+	}
+
+	for _, name := range []string{"..", "../data", "/", "pkg/nested", "escape"} {
+		t.Run("list "+name, func(t *testing.T) {
+			files, err := storage.ListPackageFiles(ctx, name)
+			if err == nil {
+				assert.Empty(t, files)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(parent, "secret.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+
+	entries, err := os.ReadDir(parent)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MemoryStorage keeps every file in memory. Contents are lost when the process exits,
+// so it is only meant for tests and ephemeral instances such as preview environments.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	log.Info().Msg("Using in-memory storage, files will be lost on restart")
+	return &MemoryStorage{files: map[string][]byte{}}
+}
+
+func (s *MemoryStorage) ListPackages(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := map[string]struct{}{}
+	packages := []string{}
+	for key := range s.files {
+		packageName, _, found := strings.Cut(key, "/")
+		if !found {
+			continue
+		}
+		if _, ok := seen[packageName]; !ok {
+			seen[packageName] = struct{}{}
+			packages = append(packages, packageName)
+		}
+	}
+	return packages, nil
+}
+
+func (s *MemoryStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	packageName, err := cleanPackageName(packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := packageName + "/"
+	files := []string{}
+	for key := range s.files {
+		name, found := strings.CutPrefix(key, prefix)
+		if found && !strings.Contains(name, "/") {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+func (s *MemoryStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, err := cleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored slices are never modified in place, so they can be shared with readers.
+	content, ok := s.files[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (s *MemoryStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	key, err := cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, contextReader{ctx: ctx, r: content}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[key] = buf.Bytes()
+	return nil
+}
+
+func (s *MemoryStorage) DeleteFile(ctx context.Context, filePath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.files[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// MirrorStorage keeps the same files in several backends.
+//
+// Writes and deletes go to every backend and succeed as long as one backend accepts them.
+// Backends that failed are recorded in a repair journal, which a background reconciler replays
+// once they are reachable again. Reads use the first healthy backend and fall back to the others.
+type MirrorStorage struct {
+	backends []Storage
+	journal  *repairJournal
+	cooldown time.Duration
+
+	healthMu       sync.Mutex
+	unhealthyUntil []time.Time
+
+	// repairMu keeps a repair from racing with a write or delete through the mirror.
+	repairMu sync.RWMutex
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewMirrorStorage(backends []Storage, cfg *config.MirrorConfig) (*MirrorStorage, error) {
+	if len(backends) < 2 {
+		return nil, errors.New("mirror storage requires at least two backends")
+	}
+	log.Info().Msgf("Using mirror storage with %d backends", len(backends))
+
+	journal, err := openRepairJournal(cfg.JournalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	s := &MirrorStorage{
+		backends:       backends,
+		journal:        journal,
+		cooldown:       time.Duration(cfg.UnhealthyCooldownSeconds) * time.Second,
+		unhealthyUntil: make([]time.Time, len(backends)),
+		stop:           stop,
+		done:           make(chan struct{}),
+	}
+	go s.reconcileLoop(ctx, time.Duration(cfg.ReconcileIntervalSeconds)*time.Second)
+	return s, nil
+}
+
+func (s *MirrorStorage) ListPackages(ctx context.Context) ([]string, error) {
+	var errs []error
+	for _, i := range s.order() {
+		packages, err := s.backends[i].ListPackages(ctx)
+		if err == nil {
+			s.markHealthy(i)
+			return packages, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		s.markUnhealthy(ctx, i, err)
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *MirrorStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	var errs []error
+	for _, i := range s.order() {
+		files, err := s.backends[i].ListPackageFiles(ctx, packageName)
+		if err == nil {
+			s.markHealthy(i)
+			return files, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrInvalidPath) {
+			return nil, err
+		}
+		s.markUnhealthy(ctx, i, err)
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ReadFile returns the file from the first backend that has it. A missing file is only reported
+// when every backend agrees, since a backend may not have been repaired yet.
+func (s *MirrorStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	var errs []error
+	for _, i := range s.order() {
+		rc, err := s.backends[i].ReadFile(ctx, filePath)
+		if err == nil {
+			s.markHealthy(i)
+			return rc, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrInvalidPath) {
+			return nil, err
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		s.markUnhealthy(ctx, i, err)
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return nil, errors.Join(errs...)
+}
+
+// WriteFile spools the content to a temporary file, so that every backend can read it independently.
+func (s *MirrorStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	spool, size, err := spoolToTemp(ctx, content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	s.repairMu.RLock()
+	defer s.repairMu.RUnlock()
+
+	errs := s.fanOut(func(b Storage) error {
+		return b.WriteFile(ctx, filePath, io.NewSectionReader(spool, 0, size))
+	})
+	return s.finish(ctx, journalOpWrite, filePath, errs)
+}
+
+// DeleteFile deletes the file from every backend. It only reports a missing file if no backend had it.
+func (s *MirrorStorage) DeleteFile(ctx context.Context, filePath string) error {
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	s.repairMu.RLock()
+	defer s.repairMu.RUnlock()
+
+	errs := s.fanOut(func(b Storage) error {
+		return b.DeleteFile(ctx, filePath)
+	})
+
+	missing := 0
+	for i, err := range errs {
+		if errors.Is(err, os.ErrNotExist) {
+			errs[i] = nil
+			missing++
+		}
+	}
+	if err := s.finish(ctx, journalOpDelete, filePath, errs); err != nil {
+		return err
+	}
+	if missing == len(errs) {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (s *MirrorStorage) Close() error {
+	s.stop()
+	<-s.done
+
+	errs := []error{s.journal.close()}
+	for _, b := range s.backends {
+		errs = append(errs, b.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Reconcile replays the repair journal. Entries that still fail are kept for the next run.
+func (s *MirrorStorage) Reconcile(ctx context.Context) error {
+	entries := s.journal.entries()
+	slices.SortFunc(entries, func(a, b journalEntry) int { return a.Time.Compare(b.Time) })
+
+	var errs []error
+	for _, e := range entries {
+		if err := s.repair(ctx, e); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Ctx(ctx).Warn().Err(err).Str("op", e.Op).Int("backend", e.Backend).Str("path", e.Path).Msg("Failed to repair mirror")
+			errs = append(errs, err)
+		}
+	}
+
+	if err := s.journal.compact(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MirrorStorage) repair(ctx context.Context, e journalEntry) error {
+	s.repairMu.Lock()
+	defer s.repairMu.Unlock()
+
+	if e.Backend < 0 || e.Backend >= len(s.backends) {
+		log.Ctx(ctx).Warn().Int("backend", e.Backend).Str("path", e.Path).Msg("Dropping repair for unknown backend")
+		return s.journal.resolveIf(e)
+	}
+	target := s.backends[e.Backend]
+
+	switch e.Op {
+	case journalOpDelete:
+		if err := target.DeleteFile(ctx, e.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	case journalOpWrite:
+		if err := s.copyTo(ctx, e.Backend, e.Path); err != nil {
+			return err
+		}
+	}
+
+	log.Ctx(ctx).Info().Str("op", e.Op).Int("backend", e.Backend).Str("path", e.Path).Msg("Repaired mirror")
+	s.markHealthy(e.Backend)
+	return s.journal.resolveIf(e)
+}
+
+// copyTo copies the file from any other backend that has it. If none has it anymore,
+// the file was deleted in the meantime and there is nothing to repair.
+func (s *MirrorStorage) copyTo(ctx context.Context, target int, filePath string) error {
+	var errs []error
+	for _, i := range s.order() {
+		if i == target {
+			continue
+		}
+
+		rc, err := s.backends[i].ReadFile(ctx, filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err = s.backends[target].WriteFile(ctx, filePath, rc)
+		_ = rc.Close()
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MirrorStorage) reconcileLoop(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Mirror reconciliation incomplete")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fanOut runs fn against every backend concurrently and returns the errors by backend.
+func (s *MirrorStorage) fanOut(fn func(Storage) error) []error {
+	errs := make([]error, len(s.backends))
+	var wg sync.WaitGroup
+	for i, b := range s.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(b)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// finish journals the backends that failed, as long as at least one backend succeeded.
+// Otherwise the operation as a whole failed and there is nothing to repair.
+func (s *MirrorStorage) finish(ctx context.Context, op, filePath string, errs []error) error {
+	if !slices.Contains(errs, nil) {
+		return errors.Join(errs...)
+	}
+
+	for i, err := range errs {
+		if err == nil {
+			s.markHealthy(i)
+			if jerr := s.journal.resolve(i, filePath); jerr != nil {
+				log.Ctx(ctx).Error().Err(jerr).Msg("Failed to update mirror journal")
+			}
+			continue
+		}
+
+		s.markUnhealthy(ctx, i, err)
+		if jerr := s.journal.record(op, i, filePath); jerr != nil {
+			// The change reached another backend, but this one won't be repaired automatically.
+			log.Ctx(ctx).Error().Err(jerr).Int("backend", i).Str("path", filePath).Msg("Failed to record repair in mirror journal")
+		}
+	}
+	return nil
+}
+
+// order returns backend indexes in the order they should be read from: healthy backends without
+// pending repairs first, then healthy backends that are missing changes, then unhealthy ones.
+func (s *MirrorStorage) order() []int {
+	pending := s.journal.pendingBackends()
+	now := time.Now()
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	rank := func(i int) int {
+		switch {
+		case now.Before(s.unhealthyUntil[i]):
+			return 2
+		case pending[i]:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	order := make([]int, len(s.backends))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return rank(a) - rank(b) })
+	return order
+}
+
+// Health returns an error while every backend is failing. Reads and writes still work as long as one is healthy.
+func (s *MirrorStorage) Health() error {
+	now := time.Now()
+	s.healthMu.Lock()
+	unhealthyUntil := slices.Clone(s.unhealthyUntil)
+	s.healthMu.Unlock()
+
+	var errs []error
+	for i, b := range s.backends {
+		err := checkBackendHealth(b)
+		if err == nil && !now.Before(unhealthyUntil[i]) {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("mirror backend %d failed recently", i)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MirrorStorage) markHealthy(i int) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	s.unhealthyUntil[i] = time.Time{}
+}
+
+func (s *MirrorStorage) markUnhealthy(ctx context.Context, i int, err error) {
+	if errors.Is(err, ErrInvalidPath) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	log.Ctx(ctx).Warn().Err(err).Int("backend", i).Msg("Mirror backend failed")
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	s.unhealthyUntil[i] = time.Now().Add(s.cooldown)
+}
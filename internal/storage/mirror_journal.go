@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalOpWrite    = "write"
+	journalOpDelete   = "delete"
+	journalOpResolved = "resolved"
+)
+
+// journalEntry records a write or delete that still has to be applied to one backend of a mirror.
+type journalEntry struct {
+	Op      string    `json:"op"`
+	Backend int       `json:"backend"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+}
+
+type journalKey struct {
+	backend int
+	path    string
+}
+
+// repairJournal is an append-only log of pending repairs. Only the latest entry per backend and
+// path matters, so the file is rewritten with the pending entries after every reconciliation.
+// Without a path, entries are only kept in memory.
+type repairJournal struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	pending map[journalKey]journalEntry
+}
+
+func openRepairJournal(path string) (*repairJournal, error) {
+	j := &repairJournal{path: path, pending: map[journalKey]journalEntry{}}
+	if path == "" {
+		return j, nil
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if err := j.compactLocked(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *repairJournal) load() error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		// A crash can leave a truncated last line behind, which is safe to skip.
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		j.applyLocked(e)
+	}
+	return scanner.Err()
+}
+
+func (j *repairJournal) applyLocked(e journalEntry) {
+	key := journalKey{backend: e.Backend, path: e.Path}
+	if e.Op == journalOpResolved {
+		delete(j.pending, key)
+	} else {
+		j.pending[key] = e
+	}
+}
+
+func (j *repairJournal) appendLocked(e journalEntry) error {
+	j.applyLocked(e)
+	if j.f == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// record marks the path as needing the given operation on the backend.
+func (j *repairJournal) record(op string, backend int, path string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.appendLocked(journalEntry{Op: op, Backend: backend, Path: path, Time: time.Now()})
+}
+
+// resolve drops a pending entry, if any.
+func (j *repairJournal) resolve(backend int, path string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[journalKey{backend: backend, path: path}]; !ok {
+		return nil
+	}
+	return j.appendLocked(journalEntry{Op: journalOpResolved, Backend: backend, Path: path, Time: time.Now()})
+}
+
+// resolveIf drops the pending entry only if it is still the given one.
+func (j *repairJournal) resolveIf(e journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	current, ok := j.pending[journalKey{backend: e.Backend, path: e.Path}]
+	if !ok || current != e {
+		return nil
+	}
+	return j.appendLocked(journalEntry{Op: journalOpResolved, Backend: e.Backend, Path: e.Path, Time: time.Now()})
+}
+
+func (j *repairJournal) entries() []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]journalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// pendingBackends returns the backends that are missing at least one change.
+func (j *repairJournal) pendingBackends() map[int]bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	backends := map[int]bool{}
+	for key := range j.pending {
+		backends[key.backend] = true
+	}
+	return backends
+}
+
+func (j *repairJournal) compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.compactLocked()
+}
+
+// compactLocked rewrites the journal with only the pending entries and reopens it for appending.
+func (j *repairJournal) compactLocked() error {
+	if j.path == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(j.path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, tempFilePrefix+filepath.Base(j.path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range j.pending {
+		if err := enc.Encode(e); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if j.f != nil {
+		_ = j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+func (j *repairJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+var errBackendDown = errors.New("backend down")
+
+// flakyStorage fails every operation while it is down.
+type flakyStorage struct {
+	Storage
+	down atomic.Bool
+}
+
+func (s *flakyStorage) err() error {
+	if s.down.Load() {
+		return errBackendDown
+	}
+	return nil
+}
+
+func (s *flakyStorage) ListPackages(ctx context.Context) ([]string, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListPackages(ctx)
+}
+
+func (s *flakyStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListPackageFiles(ctx, packageName)
+}
+
+func (s *flakyStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ReadFile(ctx, filePath)
+}
+
+func (s *flakyStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.Storage.WriteFile(ctx, filePath, content)
+}
+
+func (s *flakyStorage) DeleteFile(ctx context.Context, filePath string) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.Storage.DeleteFile(ctx, filePath)
+}
+
+func newTestMirrorStorage(t *testing.T, cfg config.MirrorConfig, backends ...Storage) *MirrorStorage {
+	t.Helper()
+
+	storage, err := NewMirrorStorage(backends, &cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+	return storage
+}
+
+func TestMirrorStorage_WriteWhileBackendDown(t *testing.T) {
+	primary := &flakyStorage{Storage: NewMemoryStorage()}
+	replica := NewMemoryStorage()
+	storage := newTestMirrorStorage(t, config.MirrorConfig{UnhealthyCooldownSeconds: 60}, primary, replica)
+
+	primary.down.Store(true)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+	assert.Len(t, storage.journal.entries(), 1)
+
+	// Reads and listings fall back to the replica.
+	assert.Equal(t, "foo", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+	files, err := storage.ListPackageFiles(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo-1.0.tar.gz"}, files)
+
+	// Repairs fail while the backend is still down.
+	require.ErrorIs(t, storage.Reconcile(t.Context()), errBackendDown)
+	assert.Len(t, storage.journal.entries(), 1)
+
+	primary.down.Store(false)
+	// The primary is missing a change, so the replica is still preferred.
+	assert.Equal(t, "foo", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	require.NoError(t, storage.Reconcile(t.Context()))
+	assert.Empty(t, storage.journal.entries())
+	assert.Equal(t, "foo", readAll(t, primary, "foo/foo-1.0.tar.gz"))
+}
+
+func TestMirrorStorage_DeleteWhileBackendDown(t *testing.T) {
+	primary := NewMemoryStorage()
+	replica := &flakyStorage{Storage: NewMemoryStorage()}
+	storage := newTestMirrorStorage(t, config.MirrorConfig{}, primary, replica)
+
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+
+	replica.down.Store(true)
+	require.NoError(t, storage.DeleteFile(t.Context(), "foo/foo-1.0.tar.gz"))
+	_, err := storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, errBackendDown)
+
+	replica.down.Store(false)
+	require.NoError(t, storage.Reconcile(t.Context()))
+	_, err = storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMirrorStorage_AllBackendsDown(t *testing.T) {
+	primary := &flakyStorage{Storage: NewMemoryStorage()}
+	replica := &flakyStorage{Storage: NewMemoryStorage()}
+	storage := newTestMirrorStorage(t, config.MirrorConfig{}, primary, replica)
+
+	primary.down.Store(true)
+	replica.down.Store(true)
+
+	require.ErrorIs(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")), errBackendDown)
+	assert.Empty(t, storage.journal.entries())
+
+	_, err := storage.ListPackages(t.Context())
+	require.ErrorIs(t, err, errBackendDown)
+}
+
+func TestMirrorStorage_LaterWriteResolvesRepair(t *testing.T) {
+	primary := &flakyStorage{Storage: NewMemoryStorage()}
+	storage := newTestMirrorStorage(t, config.MirrorConfig{}, primary, NewMemoryStorage())
+
+	primary.down.Store(true)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("old")))
+	primary.down.Store(false)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("new")))
+
+	assert.Empty(t, storage.journal.entries())
+	assert.Equal(t, "new", readAll(t, primary, "foo/foo-1.0.tar.gz"))
+}
+
+func TestMirrorStorage_JournalSurvivesRestart(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	primary := &flakyStorage{Storage: NewMemoryStorage()}
+	replica := NewMemoryStorage()
+
+	storage, err := NewMirrorStorage([]Storage{primary, replica}, &config.MirrorConfig{JournalPath: journalPath})
+	require.NoError(t, err)
+
+	primary.down.Store(true)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+	require.NoError(t, storage.WriteFile(t.Context(), "bar/bar-1.0.tar.gz", bytes.NewBufferString("bar")))
+	require.NoError(t, storage.DeleteFile(t.Context(), "bar/bar-1.0.tar.gz"))
+	require.NoError(t, storage.Close())
+
+	// A truncated line from a crash is ignored.
+	f, err := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"wri`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	primary.down.Store(false)
+	storage = newTestMirrorStorage(t, config.MirrorConfig{JournalPath: journalPath}, primary, replica)
+	assert.Len(t, storage.journal.entries(), 2)
+
+	require.NoError(t, storage.Reconcile(t.Context()))
+	assert.Empty(t, storage.journal.entries())
+	assert.Equal(t, "foo", readAll(t, primary, "foo/foo-1.0.tar.gz"))
+
+	content, err := os.ReadFile(journalPath)
+	require.NoError(t, err)
+	assert.Empty(t, content)
+}
+
+func TestMirrorStorage_BackgroundReconciler(t *testing.T) {
+	primary := &flakyStorage{Storage: NewMemoryStorage()}
+	storage := newTestMirrorStorage(t, config.MirrorConfig{ReconcileIntervalSeconds: 1}, primary, NewMemoryStorage())
+
+	primary.down.Store(true)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewBufferString("foo")))
+	primary.down.Store(false)
+
+	require.Eventually(t, func() bool {
+		return len(storage.journal.entries()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "foo", readAll(t, primary, "foo/foo-1.0.tar.gz"))
+}
+
+func TestNewMirrorStorage_RequiresTwoBackends(t *testing.T) {
+	_, err := NewMirrorStorage([]Storage{NewMemoryStorage()}, &config.MirrorConfig{})
+	require.Error(t, err)
+}
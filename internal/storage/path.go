@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// ErrInvalidPath is returned for paths that are absolute or would escape the storage root.
+var ErrInvalidPath = errors.New("invalid storage path")
+
+// cleanPath cleans a slash-separated storage path and rejects paths that are absolute,
+// empty or point outside of the storage root. Backends call it before building keys so
+// that a path can never reach a sibling prefix or a parent directory.
+func cleanPath(p string) (string, error) {
+	if p == "" || strings.ContainsAny(p, "\\\x00") || path.IsAbs(p) {
+		return "", ErrInvalidPath
+	}
+
+	cleaned := path.Clean(p)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidPath
+	}
+	return cleaned, nil
+}
+
+// cleanPackageName rejects package names that are not a single path segment.
+func cleanPackageName(packageName string) (string, error) {
+	cleaned, err := cleanPath(packageName)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(cleaned, "/") {
+		return "", ErrInvalidPath
+	}
+	return cleaned, nil
+}
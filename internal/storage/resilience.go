@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// ErrCircuitOpen is returned without calling the backend while it is considered unavailable.
+var ErrCircuitOpen = errors.New("storage is unavailable, circuit breaker is open")
+
+// ResilientStorage bounds every backend operation with a deadline, retries failed operations
+// with jittered exponential backoff, and stops calling a backend that keeps failing.
+//
+// Every operation is retried. Content that isn't an io.Seeker is spooled to a temporary file
+// before writing, since a partially consumed stream can't be sent again. Reads are bounded until
+// the file is opened, so that slow clients can still download large files.
+type ResilientStorage struct {
+	backend      Storage
+	timeout      time.Duration
+	writeTimeout time.Duration
+
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	breaker *circuitBreaker
+}
+
+func NewResilientStorage(backend Storage, cfg *config.ResilienceConfig) *ResilientStorage {
+	log.Info().
+		Int("timeout_seconds", cfg.TimeoutSeconds).
+		Int("max_retries", cfg.MaxRetries).
+		Int("breaker_threshold", cfg.BreakerThreshold).
+		Msg("Using resilient storage")
+
+	return &ResilientStorage{
+		backend:        backend,
+		timeout:        time.Duration(cfg.TimeoutSeconds) * time.Second,
+		writeTimeout:   time.Duration(cfg.WriteTimeoutSeconds) * time.Second,
+		maxRetries:     cfg.MaxRetries,
+		initialBackoff: time.Duration(cfg.InitialBackoffMilliseconds) * time.Millisecond,
+		maxBackoff:     time.Duration(cfg.MaxBackoffMilliseconds) * time.Millisecond,
+		breaker: &circuitBreaker{
+			threshold: cfg.BreakerThreshold,
+			cooldown:  time.Duration(cfg.BreakerCooldownSeconds) * time.Second,
+		},
+	}
+}
+
+func (s *ResilientStorage) ListPackages(ctx context.Context) ([]string, error) {
+	var packages []string
+	err := s.do(ctx, "list packages", s.timeout, nil, func(ctx context.Context) error {
+		var err error
+		packages, err = s.backend.ListPackages(ctx)
+		return err
+	})
+	return packages, err
+}
+
+func (s *ResilientStorage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
+	var files []string
+	err := s.do(ctx, "list package files", s.timeout, nil, func(ctx context.Context) error {
+		var err error
+		files, err = s.backend.ListPackageFiles(ctx, packageName)
+		return err
+	})
+	return files, err
+}
+
+func (s *ResilientStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	// The deadline only covers opening the file, so it is enforced here instead of in do.
+	err := s.do(ctx, "read", 0, nil, func(ctx context.Context) error {
+		readCtx, cancel := context.WithCancel(ctx)
+		var timer *time.Timer
+		if s.timeout > 0 {
+			timer = time.AfterFunc(s.timeout, cancel)
+		}
+
+		r, err := s.backend.ReadFile(readCtx, filePath)
+		if timer != nil && !timer.Stop() {
+			if err == nil {
+				_ = r.Close()
+			}
+			cancel()
+			return fmt.Errorf("opening %s: %w", filePath, context.DeadlineExceeded)
+		}
+		if err != nil {
+			cancel()
+			return err
+		}
+		rc = &cancelingReadCloser{ReadCloser: r, cancel: cancel}
+		return nil
+	})
+	return rc, err
+}
+
+func (s *ResilientStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	var rewind func() error
+	if seeker, ok := content.(io.Seeker); ok {
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			rewind = func() error {
+				_, err := seeker.Seek(start, io.SeekStart)
+				return err
+			}
+		}
+	}
+	if rewind == nil && s.maxRetries > 0 {
+		// Streams can only be read once, so they are spooled to be sent again on a retry.
+		spool, size, err := spoolToTemp(ctx, content)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		return s.WriteFile(ctx, filePath, io.NewSectionReader(spool, 0, size))
+	}
+
+	return s.do(ctx, "write", s.writeTimeout, rewind, func(ctx context.Context) error {
+		return s.backend.WriteFile(ctx, filePath, content)
+	})
+}
+
+func (s *ResilientStorage) DeleteFile(ctx context.Context, filePath string) error {
+	return s.do(ctx, "delete", s.timeout, nil, func(ctx context.Context) error {
+		return s.backend.DeleteFile(ctx, filePath)
+	})
+}
+
+func (s *ResilientStorage) Close() error {
+	return s.backend.Close()
+}
+
+func (s *ResilientStorage) Watch(ctx context.Context, fn func(packageName string)) error {
+	return watchBackend(ctx, s.backend, fn)
+}
+
+// Health returns an error while the circuit breaker is open.
+func (s *ResilientStorage) Health() error {
+	if err := s.breaker.health(); err != nil {
+		return err
+	}
+	return checkBackendHealth(s.backend)
+}
+
+// do runs op until it succeeds, fails permanently or runs out of retries. beforeRetry is called
+// before every retry, if set.
+func (s *ResilientStorage) do(ctx context.Context, name string, timeout time.Duration, beforeRetry func() error, op func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := s.attempt(ctx, timeout, op)
+		if err == nil || attempt >= s.maxRetries || !isRetryable(ctx, err) {
+			return err
+		}
+
+		backoff := s.backoff(attempt)
+		log.Ctx(ctx).Warn().Err(err).Str("operation", name).Int("attempt", attempt+1).Dur("backoff", backoff).Msg("Retrying storage operation")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if beforeRetry != nil {
+			if rewindErr := beforeRetry(); rewindErr != nil {
+				return errors.Join(err, rewindErr)
+			}
+		}
+	}
+}
+
+// attempt runs op once, if the circuit breaker allows it.
+func (s *ResilientStorage) attempt(ctx context.Context, timeout time.Duration, op func(context.Context) error) error {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+
+	opCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		opCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	err := op(opCtx)
+	cancel()
+
+	s.breaker.record(ctx, err)
+	return err
+}
+
+// backoff returns a random duration between half and all of the exponential backoff for the attempt.
+func (s *ResilientStorage) backoff(attempt int) time.Duration {
+	d := s.initialBackoff << min(attempt, 30)
+	if d > s.maxBackoff || d <= 0 {
+		d = s.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1) //nolint:gosec // Jitter doesn't need a secure random source.
+}
+
+// isRetryable reports whether the error might go away on a retry. Errors about the request itself,
+// and errors after the caller gave up, are returned right away.
+func isRetryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil &&
+		!errors.Is(err, os.ErrNotExist) &&
+		!errors.Is(err, ErrInvalidPath) &&
+		!errors.Is(err, ErrCircuitOpen)
+}
+
+// cancelingReadCloser releases the context of a read once the file is closed.
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// circuitBreaker opens after threshold consecutive failures. While open, operations fail right away.
+// After the cooldown, a single operation is let through: the circuit closes if it succeeds, and stays
+// open for another cooldown if it fails.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	lastErr   error
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return fmt.Errorf("%w: %w", ErrCircuitOpen, b.lastErr)
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of an operation. Errors about the request itself mean the backend is
+// reachable, and operations canceled by the caller say nothing about the backend.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbing := b.probing
+	b.probing = false
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+	case ctx.Err() != nil:
+	case err == nil || !isRetryable(ctx, err):
+		if b.failures >= b.threshold {
+			log.Info().Msg("Storage recovered, closing circuit breaker")
+		}
+		b.failures = 0
+		b.lastErr = nil
+	default:
+		b.failures++
+		b.lastErr = err
+		if b.failures == b.threshold || wasProbing {
+			log.Error().Err(err).Dur("cooldown", b.cooldown).Msg("Storage keeps failing, opening circuit breaker")
+		}
+		if b.failures >= b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	}
+}
+
+func (b *circuitBreaker) health() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold {
+		return fmt.Errorf("%w: %w", ErrCircuitOpen, b.lastErr)
+	}
+	return nil
+}
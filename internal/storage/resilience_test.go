@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+func newTestResilienceConfig() *config.ResilienceConfig {
+	return &config.ResilienceConfig{
+		TimeoutSeconds:             5,
+		WriteTimeoutSeconds:        5,
+		MaxRetries:                 3,
+		InitialBackoffMilliseconds: 1,
+		MaxBackoffMilliseconds:     5,
+		BreakerThreshold:           5,
+		BreakerCooldownSeconds:     60,
+	}
+}
+
+// failingStorage fails the next n operations. Writes consume part of the content before failing.
+type failingStorage struct {
+	Storage
+	n     atomic.Int32
+	calls atomic.Int32
+}
+
+func (s *failingStorage) err() error {
+	s.calls.Add(1)
+	if s.n.Add(-1) >= 0 {
+		return errBackendDown
+	}
+	return nil
+}
+
+func (s *failingStorage) ListPackages(ctx context.Context) ([]string, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListPackages(ctx)
+}
+
+func (s *failingStorage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ReadFile(ctx, filePath)
+}
+
+func (s *failingStorage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
+	if err := s.err(); err != nil {
+		_, _ = io.CopyN(io.Discard, content, 2)
+		return err
+	}
+	return s.Storage.WriteFile(ctx, filePath, content)
+}
+
+// hangingStorage blocks every read until the context is done.
+type hangingStorage struct {
+	Storage
+}
+
+func (s *hangingStorage) ReadFile(ctx context.Context, _ string) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResilientStorage_Retries(t *testing.T) {
+	backend := &failingStorage{Storage: NewMemoryStorage()}
+	storage := NewResilientStorage(backend, newTestResilienceConfig())
+
+	backend.n.Store(2)
+	_, err := storage.ListPackages(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), backend.calls.Load())
+
+	// Seekable content is rewound before the write is retried.
+	backend.n.Store(1)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.0.tar.gz", bytes.NewReader([]byte("content"))))
+	assert.Equal(t, "content", readAll(t, storage, "foo/foo-1.0.tar.gz"))
+
+	// Streams are spooled, so they are sent again in full.
+	backend.n.Store(1)
+	require.NoError(t, storage.WriteFile(t.Context(), "foo/foo-1.1.tar.gz", io.MultiReader(strings.NewReader("content"))))
+	assert.Equal(t, "content", readAll(t, storage, "foo/foo-1.1.tar.gz"))
+
+	// Giving up after the last retry.
+	backend.n.Store(10)
+	backend.calls.Store(0)
+	_, err = storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, errBackendDown)
+	assert.Equal(t, int32(4), backend.calls.Load())
+}
+
+func TestResilientStorage_DoesNotRetryPermanentErrors(t *testing.T) {
+	backend := &failingStorage{Storage: NewMemoryStorage()}
+	storage := NewResilientStorage(backend, newTestResilienceConfig())
+
+	_, err := storage.ReadFile(t.Context(), "foo/missing.tar.gz")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, int32(1), backend.calls.Load())
+}
+
+func TestResilientStorage_Timeout(t *testing.T) {
+	cfg := newTestResilienceConfig()
+	cfg.TimeoutSeconds = 1
+	cfg.MaxRetries = 0
+	storage := NewResilientStorage(&hangingStorage{Storage: NewMemoryStorage()}, cfg)
+
+	start := time.Now()
+	_, err := storage.ReadFile(t.Context(), "foo/foo-1.0.tar.gz")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestResilientStorage_CircuitBreaker(t *testing.T) {
+	cfg := newTestResilienceConfig()
+	cfg.MaxRetries = 0
+	cfg.BreakerThreshold = 2
+	backend := &failingStorage{Storage: NewMemoryStorage()}
+	storage := NewResilientStorage(backend, cfg)
+
+	backend.n.Store(2)
+	for range 2 {
+		_, err := storage.ListPackages(t.Context())
+		require.ErrorIs(t, err, errBackendDown)
+	}
+	require.ErrorIs(t, storage.Health(), ErrCircuitOpen)
+
+	// Fails fast without calling the backend.
+	backend.calls.Store(0)
+	_, err := storage.ListPackages(t.Context())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.ErrorIs(t, err, errBackendDown)
+	assert.Equal(t, int32(0), backend.calls.Load())
+
+	// A successful operation after the cooldown closes the circuit.
+	storage.breaker.cooldown = 0
+	storage.breaker.openUntil = time.Time{}
+	_, err = storage.ListPackages(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, storage.Health())
+	require.NoError(t, Health(storage))
+}
@@ -42,48 +42,64 @@ func NewS3Storage(ctx context.Context, cfg *config.S3Config) (*S3Storage, error)
 }
 
 func (s *S3Storage) ListPackages(ctx context.Context) ([]string, error) {
-	input := &s3.ListObjectsV2Input{
+	prefix := s.keyPrefix()
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket:    aws.String(s.bucket),
-		Prefix:    aws.String(s.prefix),
+		Prefix:    aws.String(prefix),
 		Delimiter: aws.String("/"),
-	}
-	resp, err := s.client.ListObjectsV2(ctx, input)
-	if err != nil {
-		return nil, err
-	}
-	var packages []string
-	for _, cp := range resp.CommonPrefixes {
-		name := strings.TrimPrefix(*cp.Prefix, s.prefix)
-		name = strings.TrimSuffix(name, "/")
-		if name != "" {
-			packages = append(packages, name)
+	})
+
+	packages := []string{}
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range resp.CommonPrefixes {
+			name := strings.TrimPrefix(*cp.Prefix, prefix)
+			name = strings.TrimSuffix(name, "/")
+			if name != "" {
+				packages = append(packages, name)
+			}
 		}
 	}
 	return packages, nil
 }
 
 func (s *S3Storage) ListPackageFiles(ctx context.Context, packageName string) ([]string, error) {
-	prefix := path.Join(s.prefix, packageName) + "/"
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(prefix),
-	}
-	resp, err := s.client.ListObjectsV2(ctx, input)
+	packageName, err := cleanPackageName(packageName)
 	if err != nil {
 		return nil, err
 	}
-	var files []string
-	for _, obj := range resp.Contents {
-		name := strings.TrimPrefix(*obj.Key, prefix)
-		if name != "" && !strings.HasSuffix(name, "/") {
-			files = append(files, name)
+
+	prefix := s.keyPrefix() + packageName + "/"
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+
+	files := []string{}
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range resp.Contents {
+			name := strings.TrimPrefix(*obj.Key, prefix)
+			if name != "" && !strings.HasSuffix(name, "/") {
+				files = append(files, name)
+			}
 		}
 	}
 	return files, nil
 }
 
 func (s *S3Storage) ReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
-	key := path.Join(s.prefix, filePath)
+	key, err := s.key(filePath)
+	if err != nil {
+		return nil, err
+	}
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
@@ -100,9 +116,12 @@ func (s *S3Storage) ReadFile(ctx context.Context, filePath string) (io.ReadClose
 }
 
 func (s *S3Storage) WriteFile(ctx context.Context, filePath string, content io.Reader) error {
-	key := path.Join(s.prefix, filePath)
+	key, err := s.key(filePath)
+	if err != nil {
+		return err
+	}
 	uploader := manager.NewUploader(s.client)
-	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 		Body:   content,
@@ -111,8 +130,26 @@ func (s *S3Storage) WriteFile(ctx context.Context, filePath string, content io.R
 }
 
 func (s *S3Storage) DeleteFile(ctx context.Context, filePath string) error {
-	key := path.Join(s.prefix, filePath)
-	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+	key, err := s.key(filePath)
+	if err != nil {
+		return err
+	}
+
+	// DeleteObject succeeds for missing keys, so check for existence first to report
+	// os.ErrNotExist like the other backends.
+	_, err = s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return os.ErrNotExist
+		}
+		return err
+	}
+
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -122,3 +159,20 @@ func (s *S3Storage) DeleteFile(ctx context.Context, filePath string) error {
 func (s *S3Storage) Close() error {
 	return nil
 }
+
+func (s *S3Storage) key(filePath string) (string, error) {
+	filePath, err := cleanPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.keyPrefix(), filePath), nil
+}
+
+// keyPrefix returns the key prefix with a trailing slash, or an empty string if no prefix is configured.
+func (s *S3Storage) keyPrefix() string {
+	prefix := strings.Trim(s.prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrWatchUnsupported is returned by Watch when the backend can't report changes.
+var ErrWatchUnsupported = errors.New("storage does not support watching for changes")
+
+// Watcher is implemented by storages that can report files changed by other processes.
+type Watcher interface {
+	// Watch calls fn with the name of every package whose files might have changed, until ctx is done.
+	// Names are reported as they appear in the backend and may be reported more than once.
+	Watch(ctx context.Context, fn func(packageName string)) error
+}
+
+// watchBackend forwards Watch to a decorated backend.
+func watchBackend(ctx context.Context, backend Storage, fn func(packageName string)) error {
+	w, ok := backend.(Watcher)
+	if !ok {
+		return ErrWatchUnsupported
+	}
+	return w.Watch(ctx, fn)
+}
+
+// Watch reports changes in the storage directory using fsnotify. It watches the root directory,
+// and every package directory unless the layout is flat.
+func (s *LocalStorage) Watch(ctx context.Context, fn func(packageName string)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.Add(s.cfg.Path); err != nil {
+		return err
+	}
+	if s.layout != layoutFlat {
+		entries, err := s.readDir(".")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() && !isInternalDir(e.Name()) {
+				if err := w.Add(filepath.Join(s.cfg.Path, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Ctx(ctx).Warn().Err(err).Msg("Error while watching local storage")
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if packageName, ok := s.watchEvent(w, ev); ok {
+				fn(packageName)
+			}
+		}
+	}
+}
+
+// watchEvent maps an fsnotify event to the package it changed, and watches new package directories.
+func (s *LocalStorage) watchEvent(w *fsnotify.Watcher, ev fsnotify.Event) (string, bool) {
+	if ev.Op == fsnotify.Chmod {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.cfg.Path, ev.Name)
+	if err != nil {
+		return "", false
+	}
+	dir, name := filepath.Split(filepath.ToSlash(rel))
+	dir = strings.TrimSuffix(dir, "/")
+	if strings.HasPrefix(name, ".") || isInternalDir(dir) || strings.Contains(dir, "/") {
+		return "", false
+	}
+	if dir != "" {
+		return dir, true
+	}
+
+	// An entry in the root directory is either a package directory or a file of the flat layout.
+	if s.layout != layoutNested {
+		if project, ok := flatFileProject(name); ok {
+			return project, true
+		}
+	}
+	if s.layout == layoutFlat {
+		return "", false
+	}
+	if ev.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			// Files created before the watch was added are picked up by the caller listing the package.
+			if err := w.Add(ev.Name); err != nil {
+				log.Warn().Err(err).Str("path", ev.Name).Msg("Failed to watch package directory")
+			}
+		}
+	}
+	return name, true
+}
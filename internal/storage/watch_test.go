@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jeongukjae/pypi-server/internal/config"
+)
+
+// watchLocal starts watching a local storage and returns the reported package names.
+func watchLocal(t *testing.T, s *LocalStorage) <-chan string {
+	t.Helper()
+
+	changes := make(chan string, 100)
+	go func() {
+		_ = s.Watch(t.Context(), func(packageName string) { changes <- packageName })
+	}()
+	return changes
+}
+
+// waitForChange writes the file until the watcher reports the package, since the watch is
+// set up asynchronously.
+func waitForChange(t *testing.T, changes <-chan string, filePath, want string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0750))
+		assert.NoError(t, os.WriteFile(filePath, []byte("content"), 0600))
+		for {
+			select {
+			case got := <-changes:
+				if got == want {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestLocalStorage_Watch(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "foo"), 0750))
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir})
+	require.NoError(t, err)
+
+	changes := watchLocal(t, storage)
+	waitForChange(t, changes, filepath.Join(dir, "foo", "foo-1.0.tar.gz"), "foo")
+	// New package directories are watched as well.
+	waitForChange(t, changes, filepath.Join(dir, "bar", "bar-1.0.tar.gz"), "bar")
+	waitForChange(t, changes, filepath.Join(dir, "bar", "bar-1.1.tar.gz"), "bar")
+}
+
+func TestLocalStorage_WatchFlat(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(&config.LocalConfig{Path: dir, Layout: "flat"})
+	require.NoError(t, err)
+
+	changes := watchLocal(t, storage)
+	waitForChange(t, changes, filepath.Join(dir, "Foo_Bar-1.0.tar.gz"), "foo-bar")
+}
+
+func TestCachedStorage_WatchUnsupported(t *testing.T) {
+	storage, err := NewCachedStorage(NewMemoryStorage(), &config.CacheConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+
+	err = storage.Watch(t.Context(), func(string) {})
+	assert.ErrorIs(t, err, ErrWatchUnsupported)
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DistributionType string
+
+const (
+	DistributionWheel DistributionType = "bdist_wheel"
+	DistributionSdist DistributionType = "sdist"
+	DistributionEgg   DistributionType = "bdist_egg"
+)
+
+// DistributionFile is the information encoded in the file name of a distribution.
+type DistributionFile struct {
+	// Name is the project name as written in the file name, which is not normalized.
+	Name    string
+	Version string
+	Type    DistributionType
+
+	// Wheels only.
+	BuildTag    string
+	PythonTag   string
+	ABITag      string
+	PlatformTag string
+}
+
+// sdistExtensions returns the archive formats used for source distributions, including legacy ones.
+func sdistExtensions() []string {
+	return []string{".tar.gz", ".tgz", ".tar.bz2", ".tar.xz", ".tar", ".zip"}
+}
+
+// ParseDistributionFileName extracts the project name and version from a wheel, sdist or egg file name.
+//
+// https://packaging.python.org/en/latest/specifications/binary-distribution-format/#file-name-convention
+// https://packaging.python.org/en/latest/specifications/source-distribution-format/#source-distribution-file-name
+func ParseDistributionFileName(fileName string) (*DistributionFile, error) {
+	if stem, ok := strings.CutSuffix(fileName, ".whl"); ok {
+		return parseWheelFileName(fileName, stem)
+	}
+	if stem, ok := strings.CutSuffix(fileName, ".egg"); ok {
+		return parseEggFileName(fileName, stem)
+	}
+	for _, ext := range sdistExtensions() {
+		if stem, ok := strings.CutSuffix(strings.ToLower(fileName), ext); ok {
+			return parseSdistFileName(fileName, fileName[:len(stem)])
+		}
+	}
+	return nil, fmt.Errorf("unknown distribution file type: %q", fileName)
+}
+
+// {distribution}-{version}(-{build tag})?-{python tag}-{abi tag}-{platform tag}.whl
+func parseWheelFileName(fileName, stem string) (*DistributionFile, error) {
+	parts := strings.Split(stem, "-")
+	if len(parts) != 5 && len(parts) != 6 {
+		return nil, fmt.Errorf("invalid wheel file name: %q", fileName)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid wheel file name: %q", fileName)
+		}
+	}
+
+	f := &DistributionFile{
+		Name:        parts[0],
+		Version:     parts[1],
+		Type:        DistributionWheel,
+		PythonTag:   parts[len(parts)-3],
+		ABITag:      parts[len(parts)-2],
+		PlatformTag: parts[len(parts)-1],
+	}
+	if len(parts) == 6 {
+		f.BuildTag = parts[2]
+		if f.BuildTag[0] < '0' || f.BuildTag[0] > '9' {
+			return nil, fmt.Errorf("build tag must start with a digit: %q", fileName)
+		}
+	}
+	return f, nil
+}
+
+// {name}-{version}(-py{X.Y})?(-{platform})?.egg
+func parseEggFileName(fileName, stem string) (*DistributionFile, error) {
+	parts := strings.Split(stem, "-")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid egg file name: %q", fileName)
+	}
+	return &DistributionFile{Name: parts[0], Version: parts[1], Type: DistributionEgg}, nil
+}
+
+// {name}-{version}.tar.gz
+//
+// Modern sdists escape hyphens in the name, but older ones don't, e.g. python-dateutil-2.8.2.tar.gz.
+// The version starts at the first hyphen followed by a valid version, or else by a digit.
+func parseSdistFileName(fileName, stem string) (*DistributionFile, error) {
+	split := -1
+	for i := range len(stem) {
+		if stem[i] != '-' || i == 0 {
+			continue
+		}
+		if _, err := ParseVersion(stem[i+1:]); err == nil {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		for i := 1; i < len(stem)-1; i++ {
+			if stem[i] == '-' && stem[i+1] >= '0' && stem[i+1] <= '9' {
+				split = i
+				break
+			}
+		}
+	}
+	if split < 0 {
+		return nil, fmt.Errorf("invalid sdist file name: %q", fileName)
+	}
+
+	return &DistributionFile{Name: stem[:split], Version: stem[split+1:], Type: DistributionSdist}, nil
+}
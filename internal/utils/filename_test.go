@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDistributionFileName(t *testing.T) {
+	tests := []struct {
+		input string
+		// want is nil for invalid file names.
+		want *DistributionFile
+	}{
+		{
+			"foo-1.0.tar.gz",
+			&DistributionFile{Name: "foo", Version: "1.0", Type: DistributionSdist},
+		},
+		{
+			"python-dateutil-2.8.2.tar.gz",
+			&DistributionFile{Name: "python-dateutil", Version: "2.8.2", Type: DistributionSdist},
+		},
+		{
+			"Foo_Bar-1.0rc1.zip",
+			&DistributionFile{Name: "Foo_Bar", Version: "1.0rc1", Type: DistributionSdist},
+		},
+		{
+			"foo-bar-2.0-beta.TAR.GZ",
+			&DistributionFile{Name: "foo-bar", Version: "2.0-beta", Type: DistributionSdist},
+		},
+		{
+			"foo_bar-1.0-py3-none-any.whl",
+			&DistributionFile{Name: "foo_bar", Version: "1.0", Type: DistributionWheel, PythonTag: "py3", ABITag: "none", PlatformTag: "any"},
+		},
+		{
+			"numpy-2.0.0-1-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl",
+			&DistributionFile{
+				Name: "numpy", Version: "2.0.0", Type: DistributionWheel, BuildTag: "1",
+				PythonTag: "cp312", ABITag: "cp312", PlatformTag: "manylinux_2_17_x86_64.manylinux2014_x86_64",
+			},
+		},
+		{
+			"foo-1.0-py2.7.egg",
+			&DistributionFile{Name: "foo", Version: "1.0", Type: DistributionEgg},
+		},
+		{"foo.txt", nil},
+		{"foo.tar.gz", nil},
+		{"-1.0.tar.gz", nil},
+		{"foo-1.0-any.whl", nil},
+		{"foo-1.0-x-py3-none-any.whl", nil},
+		{"foo--py3-none-any.whl", nil},
+		{"foo.egg", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDistributionFileName(tt.input)
+			if tt.want == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Marker is an environment marker, which decides whether a requirement applies.
+//
+// https://packaging.python.org/en/latest/specifications/dependency-specifiers/#environment-markers
+type Marker interface {
+	// Evaluate reports whether the marker holds in the environment. Variables missing from
+	// the environment are empty strings.
+	Evaluate(env MarkerEnvironment) bool
+	String() string
+}
+
+// MarkerEnvironment maps marker variables, such as python_version or sys_platform, to their values.
+type MarkerEnvironment map[string]string
+
+// isMarkerVariable reports whether markers may use the variable.
+func isMarkerVariable(name string) bool {
+	switch name {
+	case "python_version", "python_full_version", "os_name", "sys_platform", "platform_release",
+		"platform_system", "platform_version", "platform_machine", "platform_python_implementation",
+		"implementation_name", "implementation_version", "extra":
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultMarkerEnvironment is CPython on x86-64 Linux, for the given Python version such as 3.12 or 3.12.1.
+func DefaultMarkerEnvironment(pythonVersion string) MarkerEnvironment {
+	fullVersion := pythonVersion
+	if strings.Count(fullVersion, ".") < 2 {
+		fullVersion += ".0"
+	}
+	shortVersion := fullVersion[:strings.LastIndex(fullVersion, ".")]
+	return MarkerEnvironment{
+		"python_version":                 shortVersion,
+		"python_full_version":            fullVersion,
+		"os_name":                        "posix",
+		"sys_platform":                   "linux",
+		"platform_system":                "Linux",
+		"platform_machine":               "x86_64",
+		"platform_python_implementation": "CPython",
+		"implementation_name":            "cpython",
+		"implementation_version":         fullVersion,
+	}
+}
+
+// WithExtra returns a copy of the environment for evaluating the requirements of an extra.
+func (env MarkerEnvironment) WithExtra(extra string) MarkerEnvironment {
+	withExtra := make(MarkerEnvironment, len(env)+1)
+	for k, v := range env {
+		withExtra[k] = v
+	}
+	withExtra["extra"] = extra
+	return withExtra
+}
+
+// MarkerOperation is a boolean operation on two markers.
+type MarkerOperation struct {
+	// Operator is either "and" or "or".
+	Operator    string
+	Left, Right Marker
+}
+
+func (m *MarkerOperation) Evaluate(env MarkerEnvironment) bool {
+	if m.Operator == "and" {
+		return m.Left.Evaluate(env) && m.Right.Evaluate(env)
+	}
+	return m.Left.Evaluate(env) || m.Right.Evaluate(env)
+}
+
+func (m *MarkerOperation) String() string {
+	operand := func(o Marker) string {
+		// "or" binds weaker than "and", so it needs parentheses below it.
+		if op, ok := o.(*MarkerOperation); ok && op.Operator == "or" && m.Operator == "and" {
+			return "(" + o.String() + ")"
+		}
+		return o.String()
+	}
+	return operand(m.Left) + " " + m.Operator + " " + operand(m.Right)
+}
+
+// MarkerValue is either a variable or a string literal.
+type MarkerValue struct {
+	Variable string
+	Literal  string
+}
+
+func (v MarkerValue) resolve(env MarkerEnvironment) string {
+	if v.Variable != "" {
+		return env[v.Variable]
+	}
+	return v.Literal
+}
+
+func (v MarkerValue) String() string {
+	if v.Variable != "" {
+		return v.Variable
+	}
+	if strings.Contains(v.Literal, `"`) {
+		return "'" + v.Literal + "'"
+	}
+	return `"` + v.Literal + `"`
+}
+
+// MarkerComparison compares two values, such as python_version >= "3.8".
+type MarkerComparison struct {
+	Left     MarkerValue
+	Operator string
+	Right    MarkerValue
+}
+
+func (m *MarkerComparison) Evaluate(env MarkerEnvironment) bool {
+	left, right := m.Left.resolve(env), m.Right.resolve(env)
+	// Extras are compared by their normalized names.
+	if m.Left.Variable == "extra" || m.Right.Variable == "extra" {
+		left, right = NormalizePackageName(left), NormalizePackageName(right)
+	}
+
+	switch m.Operator {
+	case "in":
+		return strings.Contains(right, left)
+	case "not in":
+		return !strings.Contains(right, left)
+	}
+
+	// Version comparison applies when both sides are versions, and string comparison otherwise.
+	if v, err := ParseVersion(left); err == nil {
+		if s, err := ParseSpecifier(m.Operator + right); err == nil {
+			return s.Contains(v, true)
+		}
+	}
+	switch m.Operator {
+	case "==", "===":
+		return left == right
+	case "!=":
+		return left != right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	}
+	// ~= has no meaning for strings.
+	return false
+}
+
+func (m *MarkerComparison) String() string {
+	return m.Left.String() + " " + m.Operator + " " + m.Right.String()
+}
+
+func ParseMarker(marker string) (Marker, error) {
+	tokens, err := tokenizeMarker(marker)
+	if err != nil {
+		return nil, err
+	}
+	p := &markerParser{tokens: tokens}
+	m, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid marker, unexpected %q", p.tokens[p.pos].value)
+	}
+	return m, nil
+}
+
+type markerTokenKind int
+
+const (
+	tokenVariable markerTokenKind = iota
+	tokenString
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenOpen
+	tokenClose
+)
+
+type markerToken struct {
+	kind  markerTokenKind
+	value string
+}
+
+// markerOperators returns the comparison operators of markers, longest first.
+func markerOperators() []string {
+	return []string{"===", "==", "!=", "<=", ">=", "~=", "<", ">"}
+}
+
+//nolint:gocyclo // A tokenizer is a long switch by nature.
+func tokenizeMarker(marker string) ([]markerToken, error) {
+	var tokens []markerToken
+	for i := 0; i < len(marker); {
+		c := marker[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, markerToken{tokenOpen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, markerToken{tokenClose, ")"})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(marker[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid marker, unclosed string: %q", marker)
+			}
+			tokens = append(tokens, markerToken{tokenString, marker[i+1 : i+1+end]})
+			i += end + 2
+		case strings.ContainsRune("=!<>~", rune(c)):
+			op := ""
+			for _, candidate := range markerOperators() {
+				if strings.HasPrefix(marker[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("invalid marker operator at %q", marker[i:])
+			}
+			tokens = append(tokens, markerToken{tokenOperator, op})
+			i += len(op)
+		case c == '_' || c == '.' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+			end := i
+			for end < len(marker) && (marker[end] == '_' || marker[end] == '.' ||
+				('a' <= marker[end] && marker[end] <= 'z') || ('A' <= marker[end] && marker[end] <= 'Z') ||
+				('0' <= marker[end] && marker[end] <= '9')) {
+				end++
+			}
+			word := marker[i:end]
+			i = end
+			switch word {
+			case "and":
+				tokens = append(tokens, markerToken{tokenAnd, word})
+			case "or":
+				tokens = append(tokens, markerToken{tokenOr, word})
+			case "in":
+				tokens = append(tokens, markerToken{tokenOperator, word})
+			case "not":
+				rest := strings.TrimLeft(marker[i:], " \t")
+				after, ok := strings.CutPrefix(rest, "in")
+				if !ok || len(rest) == len(marker[i:]) {
+					return nil, fmt.Errorf("invalid marker, expected \"not in\": %q", marker)
+				}
+				tokens = append(tokens, markerToken{tokenOperator, "not in"})
+				i = len(marker) - len(after)
+			default:
+				// Dotted names are legacy aliases, such as os.name for os_name.
+				variable := strings.ReplaceAll(word, ".", "_")
+				if !isMarkerVariable(variable) {
+					return nil, fmt.Errorf("invalid marker, unknown variable %q", word)
+				}
+				tokens = append(tokens, markerToken{tokenVariable, variable})
+			}
+		default:
+			return nil, fmt.Errorf("invalid marker, unexpected %q", string(c))
+		}
+	}
+	return tokens, nil
+}
+
+// markerParser is a recursive descent parser for markers, where "and" binds stronger than "or".
+type markerParser struct {
+	tokens []markerToken
+	pos    int
+}
+
+func (p *markerParser) next() (markerToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return markerToken{}, false
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *markerParser) peek(kind markerTokenKind) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind
+}
+
+func (p *markerParser) or() (Marker, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOr) {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &MarkerOperation{Operator: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *markerParser) and() (Marker, error) {
+	left, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenAnd) {
+		p.pos++
+		right, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		left = &MarkerOperation{Operator: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *markerParser) expression() (Marker, error) {
+	if p.peek(tokenOpen) {
+		p.pos++
+		m, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(tokenClose) {
+			return nil, fmt.Errorf("invalid marker, unclosed parenthesis")
+		}
+		p.pos++
+		return m, nil
+	}
+
+	left, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.next()
+	if !ok || op.kind != tokenOperator {
+		return nil, fmt.Errorf("invalid marker, expected an operator after %s", left)
+	}
+	right, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return &MarkerComparison{Left: left, Operator: op.value, Right: right}, nil
+}
+
+func (p *markerParser) value() (MarkerValue, error) {
+	t, ok := p.next()
+	switch {
+	case !ok:
+		return MarkerValue{}, fmt.Errorf("invalid marker, unexpected end")
+	case t.kind == tokenVariable:
+		return MarkerValue{Variable: t.value}, nil
+	case t.kind == tokenString:
+		return MarkerValue{Literal: t.value}, nil
+	}
+	return MarkerValue{}, fmt.Errorf("invalid marker, expected a variable or a string, got %q", t.value)
+}
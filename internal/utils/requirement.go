@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Requirement is a dependency specification, as used in Requires-Dist.
+//
+// https://packaging.python.org/en/latest/specifications/dependency-specifiers/
+type Requirement struct {
+	// Name is the project name as written, which is not normalized.
+	Name   string
+	Extras []string
+	// Specifiers is empty for URL requirements and requirements on any version.
+	Specifiers SpecifierSet
+	URL        string
+	// Marker is nil if the requirement always applies.
+	Marker Marker
+}
+
+var (
+	requirementName = regexp.MustCompile(`(?i)^([A-Z0-9]|[A-Z0-9][A-Z0-9._-]*[A-Z0-9])$`)
+	// requirementNameStart matches the longest candidate name at the start of a requirement.
+	requirementNameStart = regexp.MustCompile(`^[A-Za-z0-9._-]+`)
+)
+
+func ParseRequirement(requirement string) (*Requirement, error) {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("invalid requirement %q: %s", requirement, fmt.Sprintf(format, args...))
+	}
+
+	rest := strings.TrimSpace(requirement)
+	name := requirementNameStart.FindString(rest)
+	if !requirementName.MatchString(name) {
+		return nil, fail("invalid project name")
+	}
+	r := &Requirement{Name: name}
+	rest = strings.TrimSpace(rest[len(name):])
+
+	if after, ok := strings.CutPrefix(rest, "["); ok {
+		list, remaining, ok := strings.Cut(after, "]")
+		if !ok {
+			return nil, fail("unclosed extras")
+		}
+		r.Extras = []string{}
+		if strings.TrimSpace(list) != "" {
+			for _, extra := range strings.Split(list, ",") {
+				extra = strings.TrimSpace(extra)
+				if !requirementName.MatchString(extra) {
+					return nil, fail("invalid extra %q", extra)
+				}
+				r.Extras = append(r.Extras, extra)
+			}
+		}
+		rest = strings.TrimSpace(remaining)
+	}
+
+	var marker string
+	hasMarker := false
+	if after, ok := strings.CutPrefix(rest, "@"); ok {
+		// A URL ends at whitespace, so that a ; right after it is part of the URL.
+		after = strings.TrimLeft(after, " \t")
+		end := strings.IndexAny(after, " \t")
+		if end < 0 {
+			end = len(after)
+		}
+		r.URL = after[:end]
+		if r.URL == "" {
+			return nil, fail("empty URL")
+		}
+		rest = strings.TrimSpace(after[end:])
+		if rest != "" {
+			marker, hasMarker = strings.CutPrefix(rest, ";")
+			if !hasMarker {
+				return nil, fail("unexpected %q after URL", rest)
+			}
+		}
+	} else {
+		var specifiers string
+		specifiers, marker, hasMarker = strings.Cut(rest, ";")
+		specifiers = strings.TrimSpace(specifiers)
+		if inner, ok := strings.CutPrefix(specifiers, "("); ok {
+			if inner, ok = strings.CutSuffix(inner, ")"); !ok {
+				return nil, fail("unclosed version specifiers")
+			}
+			specifiers = inner
+		}
+		set, err := ParseSpecifierSet(specifiers)
+		if err != nil {
+			return nil, fail("%s", err)
+		}
+		r.Specifiers = set
+	}
+
+	if hasMarker {
+		m, err := ParseMarker(marker)
+		if err != nil {
+			return nil, fail("%s", err)
+		}
+		r.Marker = m
+	}
+	return r, nil
+}
+
+func (r *Requirement) String() string {
+	var b strings.Builder
+	b.WriteString(r.Name)
+	if len(r.Extras) > 0 {
+		b.WriteString("[" + strings.Join(r.Extras, ",") + "]")
+	}
+	if r.URL != "" {
+		b.WriteString(" @ " + r.URL)
+		if r.Marker != nil {
+			// The URL has to be followed by whitespace.
+			b.WriteString(" ")
+		}
+	} else {
+		b.WriteString(r.Specifiers.String())
+	}
+	if r.Marker != nil {
+		b.WriteString("; " + r.Marker.String())
+	}
+	return b.String()
+}
+
+// Applies reports whether the requirement applies in the environment, for a project installed
+// with the given extras.
+func (r *Requirement) Applies(env MarkerEnvironment, extras ...string) bool {
+	if r.Marker == nil {
+		return true
+	}
+	if len(extras) == 0 {
+		return r.Marker.Evaluate(env)
+	}
+	for _, extra := range extras {
+		if r.Marker.Evaluate(env.WithExtra(extra)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		input      string
+		name       string
+		extras     []string
+		specifiers string
+		url        string
+		marker     string
+		want       string
+	}{
+		{input: "requests", name: "requests", want: "requests"},
+		{input: "requests >= 2.8.1, == 2.8.*", name: "requests", specifiers: ">=2.8.1,==2.8.*", want: "requests>=2.8.1,==2.8.*"},
+		{input: "requests (>=2.8.1)", name: "requests", specifiers: ">=2.8.1", want: "requests>=2.8.1"},
+		{input: "requests [security,tests] >= 2.8.1", name: "requests", extras: []string{"security", "tests"}, specifiers: ">=2.8.1", want: "requests[security,tests]>=2.8.1"},
+		{input: "name[]", name: "name", extras: []string{}, want: "name"},
+		{
+			input:  "pip @ https://github.com/pypa/pip/archive/1.3.1.zip#sha1=da9234ee9982d4bbb3c72346a6de940a148ea686",
+			name:   "pip",
+			url:    "https://github.com/pypa/pip/archive/1.3.1.zip#sha1=da9234ee9982d4bbb3c72346a6de940a148ea686",
+			marker: "",
+			want:   "pip @ https://github.com/pypa/pip/archive/1.3.1.zip#sha1=da9234ee9982d4bbb3c72346a6de940a148ea686",
+		},
+		{
+			input:  "name @ file:///tmp/name.whl ; os_name=='posix'",
+			name:   "name",
+			url:    "file:///tmp/name.whl",
+			marker: `os_name == "posix"`,
+			want:   `name @ file:///tmp/name.whl ; os_name == "posix"`,
+		},
+		{
+			input:      `argparse;python_version<"2.7"`,
+			name:       "argparse",
+			specifiers: "",
+			marker:     `python_version < "2.7"`,
+			want:       `argparse; python_version < "2.7"`,
+		},
+		{
+			input:      `foo_bar.baz>=1.0; (sys_platform == 'win32' or sys_platform == "cygwin") and extra == "test"`,
+			name:       "foo_bar.baz",
+			specifiers: ">=1.0",
+			marker:     `(sys_platform == "win32" or sys_platform == "cygwin") and extra == "test"`,
+			want:       `foo_bar.baz>=1.0; (sys_platform == "win32" or sys_platform == "cygwin") and extra == "test"`,
+		},
+		{
+			input:  `name; os.name == "posix" and "linux" in sys_platform and platform_machine not in "arm64 aarch64"`,
+			name:   "name",
+			marker: `os_name == "posix" and "linux" in sys_platform and platform_machine not in "arm64 aarch64"`,
+			want:   `name; os_name == "posix" and "linux" in sys_platform and platform_machine not in "arm64 aarch64"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			r, err := ParseRequirement(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.name, r.Name)
+			assert.Equal(t, tt.extras, r.Extras)
+			assert.Equal(t, tt.specifiers, r.Specifiers.String())
+			assert.Equal(t, tt.url, r.URL)
+			if tt.marker == "" {
+				assert.Nil(t, r.Marker)
+			} else {
+				require.NotNil(t, r.Marker)
+				assert.Equal(t, tt.marker, r.Marker.String())
+			}
+			assert.Equal(t, tt.want, r.String())
+
+			// The printed form parses to the same requirement.
+			again, err := ParseRequirement(r.String())
+			require.NoError(t, err)
+			assert.Equal(t, r.String(), again.String())
+		})
+	}
+}
+
+func TestParseRequirement_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"-foo",
+		"foo-",
+		"foo[bar",
+		"foo[bar baz]",
+		"foo >=1.0 <2.0",
+		"foo (>=1.0",
+		"foo @",
+		"foo @ https://example.com/foo.whl extra",
+		"foo; python_version",
+		"foo; python_version >= ",
+		`foo; python_version >= "3.8" and`,
+		`foo; (python_version >= "3.8"`,
+		`foo; unknown_var == "x"`,
+		`foo; python_version => "3.8"`,
+		`foo; python_version >= "3.8`,
+		`foo; os_name not "posix"`,
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseRequirement(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMarkerEvaluate(t *testing.T) {
+	env := DefaultMarkerEnvironment("3.11.4")
+
+	tests := []struct {
+		marker string
+		want   bool
+	}{
+		{`python_version >= "3.8"`, true},
+		{`python_version < "3.10"`, false},
+		{`python_version == "3.11"`, true},
+		{`python_version == "3.*"`, true},
+		{`python_full_version ~= "3.11.0"`, true},
+		{`python_version > "3.9" and python_version < "3.12"`, true},
+		{`sys_platform == "win32" or sys_platform == "linux"`, true},
+		{`sys_platform == "win32" or sys_platform == "darwin" and python_version > "3"`, false},
+		{`(sys_platform == "win32" or sys_platform == "linux") and python_version > "3"`, true},
+		{`"linux" in sys_platform`, true},
+		{`platform_machine not in "arm64 aarch64"`, true},
+		{`implementation_name == "cpython"`, true},
+		{`platform_release >= "5"`, false}, // missing variables are empty strings
+		{`os_name < "windows"`, true},      // strings compare as strings
+		{`extra == "test"`, false},
+		{`"3.8" <= python_version`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.marker, func(t *testing.T) {
+			m, err := ParseMarker(tt.marker)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m.Evaluate(env))
+		})
+	}
+}
+
+func TestRequirementApplies(t *testing.T) {
+	env := DefaultMarkerEnvironment("3.12")
+
+	r, err := ParseRequirement(`pytest; extra == "Test_Suite"`)
+	require.NoError(t, err)
+	assert.False(t, r.Applies(env))
+	assert.True(t, r.Applies(env, "docs", "test-suite"))
+
+	r, err = ParseRequirement(`tomli; python_version < "3.11"`)
+	require.NoError(t, err)
+	assert.False(t, r.Applies(env))
+	assert.True(t, r.Applies(DefaultMarkerEnvironment("3.10")))
+
+	r, err = ParseRequirement("requests")
+	require.NoError(t, err)
+	assert.True(t, r.Applies(env, "any"))
+}
+
+// FuzzParseRequirement checks that whatever parses can be printed and parsed back to the same requirement.
+func FuzzParseRequirement(f *testing.F) {
+	for _, seed := range []string{
+		"requests[security]>=2.8.1,==2.8.*",
+		`name @ file:///tmp/name.whl ; os_name == "posix"`,
+		`foo; (sys_platform == 'win32' or extra == "test") and python_version not in "2.7"`,
+	} {
+		f.Add(seed)
+	}
+
+	env := DefaultMarkerEnvironment("3.12")
+	f.Fuzz(func(t *testing.T, input string) {
+		r, err := ParseRequirement(input)
+		if err != nil {
+			return
+		}
+		again, err := ParseRequirement(r.String())
+		require.NoError(t, err, r.String())
+		require.Equal(t, r.String(), again.String())
+		if r.Marker != nil {
+			require.Equal(t, r.Marker.Evaluate(env), again.Marker.Evaluate(env))
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Specifier is a single version clause, such as >=1.0, ==1.2.* or ~=1.4.2.
+//
+// https://packaging.python.org/en/latest/specifications/version-specifiers/#version-specifiers
+type Specifier struct {
+	Operator string
+	// Version is the version as written, without the wildcard suffix.
+	Version string
+	// Wildcard is set for prefix matches, like ==1.2.*.
+	Wildcard bool
+
+	// version is nil for arbitrary equality (===), which compares strings.
+	version *Version
+}
+
+// specifierOperators returns the operators ordered so that no operator is matched as the prefix
+// of a longer one.
+func specifierOperators() []string {
+	return []string{"===", "~=", "==", "!=", "<=", ">=", "<", ">"}
+}
+
+func ParseSpecifier(specifier string) (*Specifier, error) {
+	specifier = strings.TrimSpace(specifier)
+
+	s := &Specifier{}
+	for _, op := range specifierOperators() {
+		if rest, ok := strings.CutPrefix(specifier, op); ok {
+			s.Operator = op
+			s.Version = strings.TrimSpace(rest)
+			break
+		}
+	}
+	if s.Operator == "" {
+		return nil, fmt.Errorf("invalid specifier, missing operator: %q", specifier)
+	}
+	if s.Version == "" || strings.ContainsAny(s.Version, " \t") {
+		return nil, fmt.Errorf("invalid specifier version: %q", specifier)
+	}
+	if s.Operator == "===" {
+		return s, nil
+	}
+
+	if prefix, ok := strings.CutSuffix(s.Version, ".*"); ok {
+		if s.Operator != "==" && s.Operator != "!=" {
+			return nil, fmt.Errorf("wildcards are only allowed with == and !=: %q", specifier)
+		}
+		s.Version, s.Wildcard = prefix, true
+	}
+
+	v, err := ParseVersion(s.Version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid specifier %q: %w", specifier, err)
+	}
+	s.version = v
+
+	switch {
+	case s.Wildcard && (v.PreRelease != nil || v.PostRelease != nil || v.DevRelease != nil || v.Local != nil):
+		return nil, fmt.Errorf("wildcards are only allowed after release segments: %q", specifier)
+	case v.Local != nil && s.Operator != "==" && s.Operator != "!=":
+		return nil, fmt.Errorf("local versions are only allowed with == and !=: %q", specifier)
+	case s.Operator == "~=" && len(v.Releases) < 2:
+		return nil, fmt.Errorf("compatible release needs at least two release segments: %q", specifier)
+	}
+	return s, nil
+}
+
+func (s *Specifier) String() string {
+	if s.Wildcard {
+		return s.Operator + s.Version + ".*"
+	}
+	return s.Operator + s.Version
+}
+
+// IsPreRelease reports whether the specifier names a pre-release, which makes it match
+// pre-releases without being asked to.
+func (s *Specifier) IsPreRelease() bool {
+	switch s.Operator {
+	case "!=":
+		return false
+	case "===":
+		v, err := ParseVersion(s.Version)
+		return err == nil && v.IsPreRelease()
+	}
+	return s.version.IsPreRelease()
+}
+
+// Contains reports whether v matches the specifier. Pre-releases only match if allowPreReleases is
+// set or the specifier names a pre-release.
+//
+//nolint:gocyclo // Each operator is spelled out, as in the specification.
+func (s *Specifier) Contains(v *Version, allowPreReleases bool) bool {
+	if v.IsPreRelease() && !allowPreReleases && !s.IsPreRelease() {
+		return false
+	}
+
+	switch s.Operator {
+	case "===":
+		return strings.EqualFold(v.String(), s.Version)
+	case "==":
+		return s.equal(v)
+	case "!=":
+		return !s.equal(v)
+	case "~=":
+		// ~=1.4.2 is >=1.4.2, ==1.4.*
+		prefix := &Specifier{
+			Operator: "==",
+			Wildcard: true,
+			version:  &Version{Epoch: s.version.Epoch, Releases: s.version.Releases[:len(s.version.Releases)-1]},
+		}
+		return v.Public().Compare(s.version) >= 0 && prefix.equal(v)
+	case "<=":
+		return v.Public().Compare(s.version) <= 0
+	case ">=":
+		return v.Public().Compare(s.version) >= 0
+	case "<":
+		if v.Public().Compare(s.version) >= 0 {
+			return false
+		}
+		// <3.1 doesn't match pre-releases of 3.1, unless it is a pre-release itself.
+		return s.version.IsPreRelease() || !v.IsPreRelease() || v.Base().Compare(s.version.Base()) != 0
+	case ">":
+		if v.Public().Compare(s.version) <= 0 {
+			return false
+		}
+		// >3.1 doesn't match post-releases of 3.1, unless it is a post-release itself, nor local versions of 3.1.
+		if s.version.PostRelease == nil && v.PostRelease != nil && v.Base().Compare(s.version.Base()) == 0 {
+			return false
+		}
+		return v.Local == nil || v.Base().Compare(s.version.Base()) != 0
+	}
+	return false
+}
+
+// equal is version matching, where versions without a local label match any local label,
+// and wildcards match versions by their release prefix.
+func (s *Specifier) equal(v *Version) bool {
+	if !s.Wildcard {
+		if s.version.Local == nil {
+			v = v.Public()
+		}
+		return v.Compare(s.version) == 0
+	}
+
+	if derefOr(v.Epoch, 0) != derefOr(s.version.Epoch, 0) {
+		return false
+	}
+	for i, r := range s.version.Releases {
+		candidate := int64(0)
+		if i < len(v.Releases) {
+			candidate = v.Releases[i]
+		}
+		if candidate != r {
+			return false
+		}
+	}
+	return true
+}
+
+// SpecifierSet is a comma separated list of specifiers, all of which must match. An empty set
+// matches every version.
+type SpecifierSet []*Specifier
+
+func ParseSpecifierSet(specifiers string) (SpecifierSet, error) {
+	set := SpecifierSet{}
+	for _, part := range strings.Split(specifiers, ",") {
+		if strings.TrimSpace(part) == "" {
+			if strings.TrimSpace(specifiers) == "" {
+				break
+			}
+			return nil, fmt.Errorf("invalid specifier set, empty specifier: %q", specifiers)
+		}
+		s, err := ParseSpecifier(part)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, s)
+	}
+	return set, nil
+}
+
+func (set SpecifierSet) String() string {
+	parts := make([]string, 0, len(set))
+	for _, s := range set {
+		parts = append(parts, s.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// IsPreRelease reports whether any specifier names a pre-release.
+func (set SpecifierSet) IsPreRelease() bool {
+	for _, s := range set {
+		if s.IsPreRelease() {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether v matches every specifier. Pre-releases only match if allowPreReleases
+// is set or the set names a pre-release.
+func (set SpecifierSet) Contains(v *Version, allowPreReleases bool) bool {
+	allowPreReleases = allowPreReleases || set.IsPreRelease()
+	if v.IsPreRelease() && !allowPreReleases {
+		return false
+	}
+	for _, s := range set {
+		if !s.Contains(v, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the versions that match the set, in order. As PEP 440 recommends, pre-releases are
+// left out, unless the set names one or no final release matches.
+func (set SpecifierSet) Filter(versions []*Version) []*Version {
+	var finals, preReleases []*Version
+	for _, v := range versions {
+		if !set.Contains(v, true) {
+			continue
+		}
+		if v.IsPreRelease() && !set.IsPreRelease() {
+			preReleases = append(preReleases, v)
+			continue
+		}
+		finals = append(finals, v)
+	}
+	if len(finals) == 0 {
+		return preReleases
+	}
+	return finals
+}
@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseVersion(t testing.TB, version string) *Version {
+	t.Helper()
+
+	v, err := ParseVersion(version)
+	require.NoError(t, err)
+	return v
+}
+
+func TestParseSpecifierSet(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{">=3.8", ">=3.8"},
+		{" >= 3.8 , != 3.9.* , < 4 ", ">=3.8,!=3.9.*,<4"},
+		{"~=1.4.2", "~=1.4.2"},
+		{"===foobar", "===foobar"},
+		{"==1.0+local.1", "==1.0+local.1"},
+		{"==2!1.0.*", "==2!1.0.*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			set, err := ParseSpecifierSet(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, set.String())
+		})
+	}
+}
+
+func TestParseSpecifierSet_Invalid(t *testing.T) {
+	tests := []string{
+		"3.8",         // missing operator
+		">=",          // missing version
+		">=3.8,",      // empty specifier
+		"=>3.8",       // unknown operator
+		">=3.*",       // wildcard with an ordered comparison
+		"==1.0a1.*",   // wildcard after a pre-release
+		"~=1",         // compatible release with one segment
+		"~=1.0.*",     // compatible release with a wildcard
+		">=1.0+local", // local version with an ordered comparison
+		"==1.0 .2",    // whitespace in the version
+		">=not-a-version",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseSpecifierSet(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// Examples from https://packaging.python.org/en/latest/specifications/version-specifiers/.
+func TestSpecifierSetContains(t *testing.T) {
+	tests := []struct {
+		specifiers string
+		version    string
+		want       bool
+	}{
+		// Compatible release.
+		{"~=2.2", "2.3", true},
+		{"~=2.2", "2.2", true},
+		{"~=2.2", "3.0", false},
+		{"~=1.4.5", "1.4.9", true},
+		{"~=1.4.5", "1.5.0", false},
+		{"~=1.4.5", "1.4.4", false},
+		{"~=2.2.post3", "2.2.post4", true},
+		{"~=2.2.post3", "2.3", true},
+		{"~=2.2.post3", "2.2", false},
+		{"~=1.4.5a4", "1.4.5", true},
+		{"~=1.4.5a4", "1.4.5a4", true},
+		{"~=1.4.5a4", "1.5", false},
+
+		// Version matching, with padding and local versions.
+		{"==1.1", "1.1.0", true},
+		{"==1.1", "1.1.post1", false},
+		{"==1.1", "1.1+local", true},
+		{"==1.1+local", "1.1+local", true},
+		{"==1.1+local", "1.1+other", false},
+		{"==1.1+local", "1.1", false},
+		{"==1.1.*", "1.1.post1", true},
+		{"==1.1.*", "1.1a1", false}, // pre-releases aren't matched without being asked to
+		{"==1.1.*", "1.10", false},
+		{"==1.1.*", "1.1.2+local", true},
+		{"==1.*", "1", true},
+		{"==1.0.*", "1", true},
+		{"==1!1.*", "1.0", false},
+		{"!=1.1", "1.1.0", false},
+		{"!=1.1.*", "1.1.post1", false},
+		{"!=1.1.*", "1.2", true},
+
+		// Ordered comparison.
+		{">=1.0", "1.0", true},
+		{">=1.0", "1.0+local", true},
+		{"<=1.0", "1.0+local", true},
+		{">1.7", "1.7.1", true},
+		{">1.7", "1.7.post2", false},
+		{">1.7", "1.7+local", false},
+		{">1.7.post2", "1.7.post3", true},
+		{">1.7.post2", "1.7.1", true},
+		{"<3.1", "3.1.dev0", false},
+		{"<3.1", "3.0.dev0", false},
+		{"<3.1a1", "3.1.dev0", true},
+		{"<3.1", "3.0.9", true},
+		{"<1.0", "1.0+local", false},
+
+		// Arbitrary equality.
+		{"===foobar", "foobar", false}, // not a version, so it can't be checked against a Version
+		{"===1.0", "1.0", true},
+		{"===1.0", "1.0.0", false},
+
+		// Pre-releases are only matched when the set names one.
+		{">=1.0", "2.0a1", false},
+		{">=1.0a1", "2.0a1", true},
+		{">=1.0,!=1.5a1", "2.0a1", false},
+		{">=3.8,!=3.9.*,<4", "3.8.10", true},
+		{">=3.8,!=3.9.*,<4", "3.9.1", false},
+		{">=3.8,!=3.9.*,<4", "4.0", false},
+		{"", "1.0", true},
+		{"", "1.0a1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.specifiers+" "+tt.version, func(t *testing.T) {
+			set, err := ParseSpecifierSet(tt.specifiers)
+			require.NoError(t, err)
+			v, err := ParseVersion(tt.version)
+			if err != nil {
+				// Versions that don't parse, like foobar, never match.
+				assert.False(t, tt.want)
+				return
+			}
+			assert.Equal(t, tt.want, set.Contains(v, false))
+		})
+	}
+}
+
+func TestSpecifierSetFilter(t *testing.T) {
+	versions := func(vs ...string) []*Version {
+		parsed := make([]*Version, 0, len(vs))
+		for _, v := range vs {
+			parsed = append(parsed, mustParseVersion(t, v))
+		}
+		return parsed
+	}
+	strs := func(vs []*Version) []string {
+		out := []string{}
+		for _, v := range vs {
+			out = append(out, v.String())
+		}
+		return out
+	}
+
+	set, err := ParseSpecifierSet(">=1.0")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "1.1"}, strs(set.Filter(versions("0.9", "1.0", "1.1", "1.2a1"))))
+	// Pre-releases are used when no final release matches.
+	assert.Equal(t, []string{"1.2a1", "1.3.dev1"}, strs(set.Filter(versions("0.9", "1.2a1", "1.3.dev1"))))
+
+	set, err = ParseSpecifierSet(">=1.2a1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2a1"}, strs(set.Filter(versions("1.1", "1.2a1"))))
+}
+
+// FuzzParseSpecifierSet checks that whatever parses can be printed and parsed back to the same set,
+// and can be matched against versions.
+func FuzzParseSpecifierSet(f *testing.F) {
+	for _, seed := range []string{">=3.8,!=3.9.*,<4", "~=1.4.2", "===foo", "==1.0+abc.5", "<2!1.0rc1.post2.dev3", ""} {
+		f.Add(seed)
+	}
+
+	v := &Version{Releases: []int64{1, 0}}
+	f.Fuzz(func(t *testing.T, input string) {
+		set, err := ParseSpecifierSet(input)
+		if err != nil {
+			return
+		}
+		again, err := ParseSpecifierSet(set.String())
+		require.NoError(t, err, set.String())
+		require.Equal(t, set.String(), again.String())
+		set.Contains(v, true)
+	})
+}
+
+// FuzzSpecifierContains checks the relations between operators that the specification defines,
+// for versions built from fuzzed segments.
+func FuzzSpecifierContains(f *testing.F) {
+	f.Add(uint8(1), uint8(0), uint8(0), uint8(2), uint8(0), uint8(1), uint8(0), uint8(0))
+	f.Add(uint8(3), uint8(9), uint8(1), uint8(3), uint8(9), uint8(0), uint8(0), uint8(2))
+	f.Add(uint8(1), uint8(4), uint8(5), uint8(1), uint8(4), uint8(3), uint8(4), uint8(0))
+
+	f.Fuzz(func(t *testing.T, a1, a2, a3, b1, b2, b3, suffixA, suffixB uint8) {
+		// Local versions are only allowed in == and !=, so the specifiers use public versions.
+		a := fuzzVersion(a1, a2, a3, suffixA, true)
+		b := fuzzVersion(b1, b2, b3, suffixB, false)
+		va, vb := mustParseVersion(t, a), mustParseVersion(t, b)
+		// Specifiers without a local version ignore the local version of candidates.
+		pa := va.Public()
+		spec := func(op string) *Specifier {
+			s, err := ParseSpecifier(op + b)
+			require.NoError(t, err)
+			return s
+		}
+
+		// Ordering is antisymmetric.
+		require.Equal(t, va.Compare(vb), -vb.Compare(va), "%s %s", a, b)
+
+		eq, ne := spec("==").Contains(va, true), spec("!=").Contains(va, true)
+		require.NotEqual(t, eq, ne, "==/!= %s %s", a, b)
+		require.Equal(t, pa.Compare(vb) == 0, eq, "== %s %s", a, b)
+
+		ge, le := spec(">=").Contains(va, true), spec("<=").Contains(va, true)
+		require.Equal(t, pa.Compare(vb) >= 0, ge, ">= %s %s", a, b)
+		require.Equal(t, pa.Compare(vb) <= 0, le, "<= %s %s", a, b)
+		require.Equal(t, eq, ge && le, ">=,<= %s %s", a, b)
+
+		// The exclusive comparisons never match more than the inclusive ones.
+		if spec(">").Contains(va, true) {
+			require.True(t, ge && !eq, "> %s %s", a, b)
+		}
+		if spec("<").Contains(va, true) {
+			require.True(t, le && !eq, "< %s %s", a, b)
+		}
+
+		// A version always matches its own wildcard prefix and compatible release.
+		self := fmt.Sprintf("%d.%d", a1, a2)
+		wildcard, err := ParseSpecifier("==" + self + ".*")
+		require.NoError(t, err)
+		require.True(t, wildcard.Contains(va, true), "==%s.* %s", self, a)
+		compatible, err := ParseSpecifier("~=" + pa.String())
+		require.NoError(t, err)
+		require.True(t, compatible.Contains(va, true), "~=%s %s", pa, a)
+
+		// Pre-releases only match when asked to or named.
+		if va.IsPreRelease() && !spec(">=").IsPreRelease() {
+			require.False(t, spec(">=").Contains(va, false), ">= %s %s", a, b)
+		}
+	})
+}
+
+// fuzzVersion builds a valid version with three release segments and one of a few suffixes.
+func fuzzVersion(r1, r2, r3, suffix uint8, local bool) string {
+	suffixes := []string{"", "a1", "b2", "rc1", ".post1", ".dev1", "a1.dev2", ".post1.dev1"}
+	if local {
+		suffixes = append(suffixes, "+local.7", "+7", "a1+local")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%d.%d%s", r1, r2, r3, suffixes[int(suffix)%len(suffixes)])
+	return b.String()
+}
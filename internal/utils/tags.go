@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tag is one interpreter, ABI and platform combination that a wheel supports.
+//
+// https://packaging.python.org/en/latest/specifications/platform-compatibility-tags/
+type Tag struct {
+	Interpreter string
+	ABI         string
+	Platform    string
+}
+
+func (t Tag) String() string {
+	return t.Interpreter + "-" + t.ABI + "-" + t.Platform
+}
+
+// ParseTags expands a compressed tag set, such as py2.py3-none-any, into its tags.
+func ParseTags(tags string) ([]Tag, error) {
+	parts := strings.Split(tags, "-")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid tag set %q", tags)
+	}
+	return expandTags(parts[0], parts[1], parts[2])
+}
+
+// Tags returns the tags of a wheel, which are empty for other distributions.
+func (f *DistributionFile) Tags() ([]Tag, error) {
+	if f.Type != DistributionWheel {
+		return nil, nil
+	}
+	return expandTags(f.PythonTag, f.ABITag, f.PlatformTag)
+}
+
+func expandTags(interpreters, abis, platforms string) ([]Tag, error) {
+	var tags []Tag
+	for _, interpreter := range strings.Split(interpreters, ".") {
+		for _, abi := range strings.Split(abis, ".") {
+			for _, platform := range strings.Split(platforms, ".") {
+				if interpreter == "" || abi == "" || platform == "" {
+					return nil, fmt.Errorf("invalid tag set %s-%s-%s", interpreters, abis, platforms)
+				}
+				tags = append(tags, Tag{Interpreter: strings.ToLower(interpreter), ABI: strings.ToLower(abi), Platform: strings.ToLower(platform)})
+			}
+		}
+	}
+	return tags, nil
+}
+
+var (
+	manylinuxPattern = regexp.MustCompile(`^manylinux_(\d+)_(\d+)_(.+)$`)
+	musllinuxPattern = regexp.MustCompile(`^musllinux_(\d+)_(\d+)_(.+)$`)
+	macosxPattern    = regexp.MustCompile(`^macosx_(\d+)_(\d+)_(.+)$`)
+)
+
+// maxTagVersion bounds the versions in platform tags and the Python minor version, which are
+// expanded into one tag per older version. It is far above any released glibc 2.x, musl 1.x,
+// macOS or Python 3.x.
+const maxTagVersion = 99
+
+// legacyManylinuxMinor returns the glibc 2.x minor version that a legacy manylinux tag aliases.
+func legacyManylinuxMinor(name string) (int, bool) {
+	switch name {
+	case "manylinux1":
+		return 5, true
+	case "manylinux2010":
+		return 12, true
+	case "manylinux2014":
+		return 17, true
+	default:
+		return 0, false
+	}
+}
+
+// legacyManylinux returns the legacy manylinux tag that aliases a glibc 2.x minor version.
+func legacyManylinux(minor int) (string, bool) {
+	switch minor {
+	case 5:
+		return "manylinux1", true
+	case 12:
+		return "manylinux2010", true
+	case 17:
+		return "manylinux2014", true
+	default:
+		return "", false
+	}
+}
+
+// CompatiblePlatforms expands a platform tag into every platform tag whose wheels run on it, most
+// specific first. Wheels for older glibc, musl or macOS versions run on newer ones, and the legacy
+// manylinux1, manylinux2010 and manylinux2014 tags are aliases of manylinux_2_5, 2_12 and 2_17.
+// Versions beyond glibc 2.99, musl 1.99 or macOS 99.99 are rejected.
+func CompatiblePlatforms(platform string) ([]string, error) {
+	platform = strings.ToLower(platform)
+	if name, arch, ok := strings.Cut(platform, "_"); ok {
+		if minor, ok := legacyManylinuxMinor(name); ok {
+			platform = fmt.Sprintf("manylinux_2_%d_%s", minor, arch)
+		}
+	}
+
+	if m := manylinuxPattern.FindStringSubmatch(platform); m != nil {
+		major, minor, err := tagVersion(platform, m[1], m[2], 2)
+		if err != nil {
+			return nil, err
+		}
+		var platforms []string
+		for v := minor; v >= 0; v-- {
+			// manylinux_2_5 is the oldest manylinux, there are no wheels for older glibc.
+			if major == 2 && v < 5 {
+				break
+			}
+			platforms = append(platforms, fmt.Sprintf("manylinux_%d_%d_%s", major, v, m[3]))
+			if legacy, ok := legacyManylinux(v); ok {
+				platforms = append(platforms, legacy+"_"+m[3])
+			}
+		}
+		return platforms, nil
+	}
+
+	if m := musllinuxPattern.FindStringSubmatch(platform); m != nil {
+		major, minor, err := tagVersion(platform, m[1], m[2], 1)
+		if err != nil {
+			return nil, err
+		}
+		var platforms []string
+		for v := minor; v >= 0; v-- {
+			platforms = append(platforms, fmt.Sprintf("musllinux_%d_%d_%s", major, v, m[3]))
+		}
+		return platforms, nil
+	}
+
+	if m := macosxPattern.FindStringSubmatch(platform); m != nil {
+		major, minor, err := tagVersion(platform, m[1], m[2], maxTagVersion)
+		if err != nil {
+			return nil, err
+		}
+		return compatibleMacOSPlatforms(major, minor, m[3]), nil
+	}
+	return []string{platform}, nil
+}
+
+// tagVersion parses the version of a platform tag, up to maxMajor.maxTagVersion.
+func tagVersion(platform, major, minor string, maxMajor int) (int, int, error) {
+	ma, err := strconv.Atoi(major)
+	if err != nil || ma > maxMajor {
+		return 0, 0, fmt.Errorf("unsupported platform version in %q", platform)
+	}
+	mi, err := strconv.Atoi(minor)
+	if err != nil || mi > maxTagVersion {
+		return 0, 0, fmt.Errorf("unsupported platform version in %q", platform)
+	}
+	return ma, mi, nil
+}
+
+// compatibleMacOSPlatforms lists macOS platforms down to 10.0. Since macOS 11, only the major
+// version changes compatibility, and wheels tag it with a zero minor version. x86-64 Macs also run
+// the multi-architecture formats.
+func compatibleMacOSPlatforms(major, minor int, arch string) []string {
+	formats := []string{arch}
+	switch arch {
+	case "x86_64":
+		formats = append(formats, "intel", "fat64", "fat3", "universal2", "universal")
+	case "arm64":
+		formats = append(formats, "universal2")
+	}
+
+	var versions [][2]int
+	if major >= 11 {
+		for v := major; v >= 11; v-- {
+			versions = append(versions, [2]int{v, 0})
+		}
+		// Apple silicon Macs never ran macOS 10.
+		if arch == "x86_64" {
+			minor = 16
+		} else {
+			minor = -1
+		}
+	}
+	for v := minor; v >= 0; v-- {
+		versions = append(versions, [2]int{10, v})
+	}
+
+	var platforms []string
+	for _, v := range versions {
+		for _, format := range formats {
+			platforms = append(platforms, fmt.Sprintf("macosx_%d_%d_%s", v[0], v[1], format))
+		}
+	}
+	return platforms
+}
+
+// SupportedTags lists the tags an interpreter supports on the platforms, most preferred first,
+// in the order pip uses. The implementation is an interpreter abbreviation such as cp or pp, and
+// the version is the Python version it implements, up to 3.99. Platforms are expanded with
+// CompatiblePlatforms.
+func SupportedTags(implementation string, python *Version, platforms []string) ([]Tag, error) {
+	major, minor := python.Releases[0], int64(0)
+	if len(python.Releases) > 1 {
+		minor = python.Releases[1]
+	}
+	if major > 3 || minor > maxTagVersion {
+		return nil, fmt.Errorf("unsupported Python version %s", python)
+	}
+
+	var expanded []string
+	seen := map[string]struct{}{}
+	for _, p := range platforms {
+		compatible, err := CompatiblePlatforms(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range compatible {
+			if _, ok := seen[c]; !ok {
+				seen[c] = struct{}{}
+				expanded = append(expanded, c)
+			}
+		}
+	}
+	implementation = strings.ToLower(implementation)
+	version := fmt.Sprintf("%d%d", major, minor)
+
+	var tags []Tag
+	add := func(interpreter, abi string, platforms []string) {
+		for _, p := range platforms {
+			tags = append(tags, Tag{Interpreter: interpreter, ABI: abi, Platform: p})
+		}
+	}
+
+	// Tags of the interpreter itself, and its stable ABI on older versions.
+	if implementation == "cp" {
+		add("cp"+version, "cp"+version, expanded)
+		add("cp"+version, "abi3", expanded)
+		add("cp"+version, "none", expanded)
+		for v := minor - 1; v >= 2 && major == 3; v-- {
+			add(fmt.Sprintf("cp%d%d", major, v), "abi3", expanded)
+		}
+	} else {
+		add(implementation+version, "none", expanded)
+	}
+
+	// Generic Python tags, which are compatible with this and older minor versions.
+	generic := []string{"py" + version, fmt.Sprintf("py%d", major)}
+	for v := minor - 1; v >= 0; v-- {
+		generic = append(generic, fmt.Sprintf("py%d%d", major, v))
+	}
+	for _, interpreter := range generic {
+		add(interpreter, "none", expanded)
+	}
+	add(implementation+version, "none", []string{"any"})
+	for _, interpreter := range generic {
+		add(interpreter, "none", []string{"any"})
+	}
+	return tags, nil
+}
+
+// TagPriorities maps tags to their position in a list like the one SupportedTags returns.
+func TagPriorities(tags []Tag) map[Tag]int {
+	priorities := make(map[Tag]int, len(tags))
+	for i, t := range tags {
+		if _, ok := priorities[t]; !ok {
+			priorities[t] = i
+		}
+	}
+	return priorities
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags("py2.py3-none-any")
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{{"py2", "none", "any"}, {"py3", "none", "any"}}, tags)
+
+	tags, err = ParseTags("cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"cp312-cp312-manylinux_2_17_x86_64",
+		"cp312-cp312-manylinux2014_x86_64",
+	}, tagStrings(tags))
+
+	for _, invalid := range []string{"py3-none", "py3..py2-none-any", "py3-none-any-x", ""} {
+		_, err := ParseTags(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	dist, err := ParseDistributionFileName("foo-1.0-1-PY3-none-ANY.whl")
+	require.NoError(t, err)
+	tags, err = dist.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{{"py3", "none", "any"}}, tags)
+}
+
+func TestCompatiblePlatforms(t *testing.T) {
+	tests := []struct {
+		platform string
+		// want is nil for platforms that are rejected.
+		want []string
+	}{
+		{"linux_x86_64", []string{"linux_x86_64"}},
+		{"win_amd64", []string{"win_amd64"}},
+		{"manylinux_2_12_i686", []string{
+			"manylinux_2_12_i686", "manylinux2010_i686", "manylinux_2_11_i686", "manylinux_2_10_i686",
+			"manylinux_2_9_i686", "manylinux_2_8_i686", "manylinux_2_7_i686", "manylinux_2_6_i686",
+			"manylinux_2_5_i686", "manylinux1_i686",
+		}},
+		{"manylinux2014_aarch64", []string{
+			"manylinux_2_17_aarch64", "manylinux2014_aarch64", "manylinux_2_16_aarch64", "manylinux_2_15_aarch64",
+			"manylinux_2_14_aarch64", "manylinux_2_13_aarch64", "manylinux_2_12_aarch64", "manylinux2010_aarch64",
+			"manylinux_2_11_aarch64", "manylinux_2_10_aarch64", "manylinux_2_9_aarch64", "manylinux_2_8_aarch64",
+			"manylinux_2_7_aarch64", "manylinux_2_6_aarch64", "manylinux_2_5_aarch64", "manylinux1_aarch64",
+		}},
+		{"musllinux_1_2_x86_64", []string{"musllinux_1_2_x86_64", "musllinux_1_1_x86_64", "musllinux_1_0_x86_64"}},
+		{"macosx_10_2_x86_64", []string{
+			"macosx_10_2_x86_64", "macosx_10_2_intel", "macosx_10_2_fat64", "macosx_10_2_fat3", "macosx_10_2_universal2", "macosx_10_2_universal",
+			"macosx_10_1_x86_64", "macosx_10_1_intel", "macosx_10_1_fat64", "macosx_10_1_fat3", "macosx_10_1_universal2", "macosx_10_1_universal",
+			"macosx_10_0_x86_64", "macosx_10_0_intel", "macosx_10_0_fat64", "macosx_10_0_fat3", "macosx_10_0_universal2", "macosx_10_0_universal",
+		}},
+		{"macosx_13_0_arm64", []string{
+			"macosx_13_0_arm64", "macosx_13_0_universal2",
+			"macosx_12_0_arm64", "macosx_12_0_universal2",
+			"macosx_11_0_arm64", "macosx_11_0_universal2",
+		}},
+		{"manylinux_2_3000000_x86_64", nil},
+		{"manylinux_2_99999999999999999999_x86_64", nil},
+		{"manylinux_3_17_x86_64", nil},
+		{"musllinux_1_100_x86_64", nil},
+		{"macosx_100_0_arm64", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			platforms, err := CompatiblePlatforms(tt.platform)
+			if tt.want == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, platforms)
+		})
+	}
+
+	// macOS 11 and newer on x86-64 also run wheels for every macOS 10 release.
+	platforms, err := CompatiblePlatforms("macosx_11_0_x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, "macosx_11_0_x86_64", platforms[0])
+	assert.Contains(t, platforms, "macosx_10_16_universal2")
+	assert.Contains(t, platforms, "macosx_10_9_x86_64")
+	assert.NotContains(t, platforms, "macosx_12_0_x86_64")
+}
+
+func TestSupportedTags(t *testing.T) {
+	supported, err := SupportedTags("cp", mustParseVersion(t, "3.12.1"), []string{"manylinux_2_17_x86_64"})
+	require.NoError(t, err)
+	tags := tagStrings(supported)
+	priorities := map[string]int{}
+	for i, tag := range tags {
+		priorities[tag] = i
+	}
+
+	// In the order pip prefers them.
+	ordered := []string{
+		"cp312-cp312-manylinux_2_17_x86_64",
+		"cp312-cp312-manylinux1_x86_64",
+		"cp312-abi3-manylinux_2_17_x86_64",
+		"cp312-none-manylinux_2_17_x86_64",
+		"cp311-abi3-manylinux2014_x86_64",
+		"cp32-abi3-manylinux_2_5_x86_64",
+		"py312-none-manylinux_2_17_x86_64",
+		"py3-none-manylinux_2_17_x86_64",
+		"py30-none-manylinux_2_17_x86_64",
+		"cp312-none-any",
+		"py312-none-any",
+		"py3-none-any",
+		"py311-none-any",
+		"py30-none-any",
+	}
+	for i := 1; i < len(ordered); i++ {
+		require.Contains(t, priorities, ordered[i-1])
+		require.Contains(t, priorities, ordered[i])
+		assert.Less(t, priorities[ordered[i-1]], priorities[ordered[i]], "%s before %s", ordered[i-1], ordered[i])
+	}
+	for _, unsupported := range []string{"cp313-cp313-manylinux_2_17_x86_64", "cp311-cp311-manylinux_2_17_x86_64", "py313-none-any", "py2-none-any", "cp312-cp312-manylinux_2_28_x86_64", "cp312-abi3-linux_x86_64"} {
+		assert.NotContains(t, priorities, unsupported)
+	}
+
+	supported, err = SupportedTags("pp", mustParseVersion(t, "3.10"), nil)
+	require.NoError(t, err)
+	tags = tagStrings(supported)
+	assert.Equal(t, []string{"pp310-none-any", "py310-none-any", "py3-none-any", "py39-none-any", "py38-none-any",
+		"py37-none-any", "py36-none-any", "py35-none-any", "py34-none-any", "py33-none-any", "py32-none-any",
+		"py31-none-any", "py30-none-any"}, tags)
+
+	// Versions that would expand into millions of tags are rejected.
+	_, err = SupportedTags("cp", mustParseVersion(t, "3.999999999"), nil)
+	require.Error(t, err)
+	_, err = SupportedTags("cp", mustParseVersion(t, "3.12"), []string{"manylinux_2_3000000_x86_64"})
+	require.Error(t, err)
+}
+
+func tagStrings(tags []Tag) []string {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		out = append(out, t.String())
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -17,30 +18,18 @@ type Version struct {
 	Local         *string
 }
 
-//nolint:gocyclo // Complexity is acceptable here for the sake of clarity.
+// Compare returns 1 if v is newer than v2, -1 if it is older and 0 if they are equal, following the
+// ordering of PEP 440.
+//
+// https://packaging.python.org/en/latest/specifications/version-specifiers/#summary-of-permitted-suffixes-and-relative-ordering
 func (v *Version) Compare(v2 *Version) int {
-	// 1: v < other
-	// 0: v == other
-	// -1: v > other
-
-	// 1. Epoch
-	switch {
-	case v.Epoch != nil && v2.Epoch != nil:
-		if *v.Epoch > *v2.Epoch {
-			return 1
-		}
-		if *v.Epoch < *v2.Epoch {
-			return -1
-		}
-	case v.Epoch != nil:
-		return 1
-	case v2.Epoch != nil:
-		return -1
+	// 1. Epoch, which is 0 when omitted.
+	if c := compareInt(derefOr(v.Epoch, 0), derefOr(v2.Epoch, 0)); c != 0 {
+		return c
 	}
 
-	// 2. Release
+	// 2. Release, padded with zeros.
 	maxLen := max(len(v2.Releases), len(v.Releases))
-
 	for i := range maxLen {
 		r1 := int64(0)
 		if i < len(v.Releases) {
@@ -50,96 +39,122 @@ func (v *Version) Compare(v2 *Version) int {
 		if i < len(v2.Releases) {
 			r2 = v2.Releases[i]
 		}
-		if r1 > r2 {
-			return 1
-		}
-		if r1 < r2 {
-			return -1
+		if c := compareInt(r1, r2); c != 0 {
+			return c
 		}
 	}
 
-	// 3. Pre-release
-	if v.PreRelease == nil && v2.PreRelease != nil {
-		return 1
+	// 3. Pre-release. A dev release of a final release (1.0.dev1) comes before its pre-releases,
+	// and the final release after them.
+	if c := compareInt(v.preReleaseRank(), v2.preReleaseRank()); c != 0 {
+		return c
 	}
-	if v.PreRelease != nil && v2.PreRelease == nil {
-		return -1
+	if v.PreRelease != nil && v2.PreRelease != nil {
+		if c := compareInt(*v.PreRelease, *v2.PreRelease); c != 0 {
+			return c
+		}
 	}
 
-	preReleaseOrder := map[string]int{"a": 1, "b": 2, "rc": 3}
-	vPreOrder := preReleaseOrder[strings.ToLower(v.PreReleaseTag)]
-	v2PreOrder := preReleaseOrder[strings.ToLower(v2.PreReleaseTag)]
-
-	if vPreOrder > v2PreOrder {
-		return 1
+	// 4. Post-release, where no post-release comes first.
+	if c := compareInt(derefOr(v.PostRelease, -1), derefOr(v2.PostRelease, -1)); c != 0 {
+		return c
 	}
-	if vPreOrder < v2PreOrder {
-		return -1
+
+	// 5. Dev-release, where no dev release comes last.
+	if c := compareInt(derefOr(v.DevRelease, math.MaxInt64), derefOr(v2.DevRelease, math.MaxInt64)); c != 0 {
+		return c
 	}
 
+	// 6. Local version identifier, where no local version comes first.
 	switch {
-	case v.PreRelease != nil && v2.PreRelease != nil:
-		if *v.PreRelease > *v2.PreRelease {
-			return 1
-		}
-		if *v.PreRelease < *v2.PreRelease {
-			return -1
-		}
-	case v.PreRelease != nil:
-		// A release with a pre-release is always smaller than a full release.
-		return -1
-	case v2.PreRelease != nil:
+	case v.Local != nil && v2.Local != nil:
+		return compareLocal(*v.Local, *v2.Local)
+	case v.Local != nil:
 		return 1
+	case v2.Local != nil:
+		return -1
 	}
 
-	// 4. Post-release
-	switch {
-	case v.PostRelease != nil && v2.PostRelease != nil:
-		if *v.PostRelease > *v2.PostRelease {
-			return 1
-		}
-		if *v.PostRelease < *v2.PostRelease {
-			return -1
+	return 0
+}
+
+// preReleaseRank orders the kinds of pre-release: dev releases without pre-release, then alpha, beta,
+// release candidates, and finally releases without pre-release.
+func (v *Version) preReleaseRank() int64 {
+	if v.PreRelease == nil {
+		if v.PostRelease == nil && v.DevRelease != nil {
+			return 0
 		}
-	case v.PostRelease != nil:
-		return 1
-	case v2.PostRelease != nil:
-		return -1
+		return 4
 	}
+	return map[string]int64{"a": 1, "b": 2, "rc": 3}[strings.ToLower(v.PreReleaseTag)]
+}
 
-	// 5. Dev-release
-	switch {
-	case v.DevRelease != nil && v2.DevRelease != nil:
-		if *v.DevRelease > *v2.DevRelease {
+// compareLocal compares local version labels segment by segment. Numeric segments are compared as
+// numbers and come after alphanumeric ones, which are compared case-insensitively, and a label that
+// is a prefix of the other comes first.
+func compareLocal(l1, l2 string) int {
+	s1 := strings.FieldsFunc(l1, isLocalSeparator)
+	s2 := strings.FieldsFunc(l2, isLocalSeparator)
+	for i := 0; i < len(s1) && i < len(s2); i++ {
+		n1, err1 := strconv.ParseInt(s1[i], 10, 64)
+		n2, err2 := strconv.ParseInt(s2[i], 10, 64)
+		switch {
+		case err1 == nil && err2 == nil:
+			if c := compareInt(n1, n2); c != 0 {
+				return c
+			}
+		case err1 == nil:
 			return 1
-		}
-		if *v.DevRelease < *v2.DevRelease {
+		case err2 == nil:
 			return -1
+		default:
+			if c := strings.Compare(strings.ToLower(s1[i]), strings.ToLower(s2[i])); c != 0 {
+				return c
+			}
 		}
-	case v.DevRelease != nil:
-		return -1
-	case v2.DevRelease != nil:
-		return 1
 	}
+	return compareInt(int64(len(s1)), int64(len(s2)))
+}
+
+func isLocalSeparator(r rune) bool {
+	return r == '.' || r == '-' || r == '_'
+}
 
-	// 6. Local version identifier
+func compareInt(a, b int64) int {
 	switch {
-	case v.Local != nil && v2.Local != nil:
-		if *v.Local > *v2.Local {
-			return 1
-		}
-		if *v.Local < *v2.Local {
-			return -1
-		}
-	case v.Local != nil:
+	case a > b:
 		return 1
-	case v2.Local != nil:
+	case a < b:
 		return -1
 	}
-
 	return 0
 }
 
+func derefOr(p *int64, fallback int64) int64 {
+	if p == nil {
+		return fallback
+	}
+	return *p
+}
+
+// IsPreRelease reports whether v is a pre-release or a dev release.
+func (v *Version) IsPreRelease() bool {
+	return v.PreRelease != nil || v.DevRelease != nil
+}
+
+// Public returns v without its local version label.
+func (v *Version) Public() *Version {
+	public := *v
+	public.Local = nil
+	return &public
+}
+
+// Base returns the epoch and release segments of v.
+func (v *Version) Base() *Version {
+	return &Version{Epoch: v.Epoch, Releases: v.Releases}
+}
+
 func (v *Version) String() string {
 	var b strings.Builder
 
@@ -173,6 +188,8 @@ func (v *Version) String() string {
 	return b.String()
 }
 
+var versionPattern = regexp.MustCompile(`^(([1-9][0-9]*)!)?(0|[1-9][0-9]*)((\.(0|[1-9][0-9]*))*)((a|b|rc|alpha|beta|c)(0|[1-9][0-9]*))?(\.post(0|[1-9][0-9]*))?(\.dev(0|[1-9][0-9]*))?(\+([a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*))?$`)
+
 func ParseVersion(version string) (*Version, error) {
 	// Full version: [N!]N(.N)*[{a|b|rc}N][.postN][.devN]
 	//
@@ -187,8 +204,7 @@ func ParseVersion(version string) (*Version, error) {
 	version = strings.TrimSpace(version)
 	version = strings.ToLower(version)
 
-	re := regexp.MustCompile(`^(([1-9][0-9]*)!)?(0|[1-9][0-9]*)((\.(0|[1-9][0-9]*))*)((a|b|rc|alpha|beta|c)(0|[1-9][0-9]*))?(\.post(0|[1-9][0-9]*))?(\.dev(0|[1-9][0-9]*))?(\+([a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*))?$`)
-	matches := re.FindStringSubmatch(version)
+	matches := versionPattern.FindStringSubmatch(version)
 	if matches == nil {
 		return nil, fmt.Errorf("invalid version: %q", version)
 	}
@@ -144,6 +144,19 @@ func TestVersionCompare(t *testing.T) {
 		{"1.0.0+abc", "1.0.0+abc", 0},
 		{"2!1.0.0", "1!1.0.0", 1}, // epoch
 		{"1!1.0.0", "2!1.0.0", -1},
+		{"1!1.0.0", "2.0.0", 1},
+		{"1.0", "1.0.0", 0},       // release padding
+		{"1.0.dev1", "1.0a1", -1}, // dev release before pre-releases
+		{"1.0a1.dev1", "1.0a1", -1},
+		{"1.0a1", "1.0b1", -1},
+		{"1.0b1", "1.0rc1", -1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0a1.post1", "1.0a2", -1},
+		{"1.0.post1.dev1", "1.0.post1", -1},
+		{"1.0.post1.dev1", "1.0", 1},
+		{"1.0.0+1", "1.0.0+abc", 1}, // numeric local segments after alphanumeric ones
+		{"1.0.0+abc.2", "1.0.0+abc.10", -1},
+		{"1.0.0+abc", "1.0.0+abc.1", -1},
 	}
 
 	for _, tt := range tests {
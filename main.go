@@ -25,6 +25,15 @@ import (
 )
 
 func main() { //nolint:funlen // Function length is acceptable here for the sake of clarity.
+	if len(os.Args) > 1 {
+		if command, ok := commands()[os.Args[1]]; ok {
+			if err := command(context.Background(), os.Args[2:]); err != nil {
+				log.Fatal().Err(err).Msgf("%s failed", os.Args[1])
+			}
+			return
+		}
+	}
+
 	configFilePath := flag.String("config", "", "Path to config file")
 	flag.Parse()
 
@@ -48,7 +57,15 @@ func main() { //nolint:funlen // Function length is acceptable here for the sake
 		log.Fatal().Err(err).Msg("Failed to load htpasswd file")
 	}
 
-	index := packageindex.NewIndex(strg)
+	index := packageindex.NewIndex(
+		strg,
+		packageindex.WithIngestOwner(cfg.Ingest.Owner),
+		packageindex.WithQuotas(&cfg.Quotas),
+		packageindex.WithRetention(&cfg.Retention),
+		packageindex.WithUploadPolicy(&cfg.UploadPolicy),
+	)
+	reconciler := packageindex.NewReconciler(index, strg, &cfg.Ingest)
+	retention := packageindex.NewRetentionScheduler(index, &cfg.Retention)
 
 	e := echo.New()
 	e.HideBanner = true
@@ -57,7 +74,8 @@ func main() { //nolint:funlen // Function length is acceptable here for the sake
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
 	e.Use(internalMw.Logger())
-	e.Use(internalMw.Authorizer(authFile))
+	// Health checks come from load balancers and orchestrators, which don't have credentials.
+	e.Use(internalMw.Authorizer(authFile, "/healthz"))
 
 	if cfg.Server.EnableAccessLogger {
 		e.Use(accessLogger())
@@ -65,6 +83,12 @@ func main() { //nolint:funlen // Function length is acceptable here for the sake
 
 	routes.SetupSimpleRoutes(e, index)
 	routes.SetupLegacyRoutes(e, index)
+	routes.SetupGraphRoutes(e, index)
+	routes.SetupResolveRoutes(e, index)
+	routes.SetupLockRoutes(e, index)
+	routes.SetupWheelRoutes(e, index)
+	routes.SetupAdminRoutes(e, index, cfg.AdminUsers)
+	routes.SetupHealthRoutes(e, strg)
 
 	go func() {
 		addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -87,6 +111,9 @@ func main() { //nolint:funlen // Function length is acceptable here for the sake
 		log.Error().Err(err).Msg("Error shutting down server")
 	}
 
+	reconciler.Close()
+	retention.Close()
+
 	log.Info().Msg("Closing storage")
 	if err := strg.Close(); err != nil {
 		log.Error().Err(err).Msg("Error closing storage")